@@ -3,16 +3,23 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
+	"github.com/google/gopacket/layers"
+	"github.com/ropes/banken/pkg/notify"
 	"github.com/ropes/banken/pkg/sniff"
 	"github.com/ropes/banken/pkg/traffic"
+	"github.com/ropes/banken/pkg/view"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 // Banken 番犬　App manages launching consumers of network traffic data
@@ -22,13 +29,189 @@ type Banken struct {
 	ctx    context.Context
 	logger *log.Logger
 
-	at   int
-	topN int
-	bpf  string
+	bpf    string
+	filter *sniff.Filter // BPF applied to live captures
 
-	rc     *traffic.RequestCounter
-	ad     *traffic.AlertDetector
-	status traffic.Notification
+	// settingsMux guards the settings which may be changed while running,
+	// see Reconfigure.
+	settingsMux sync.RWMutex
+	topN        int
+	topWindow   time.Duration // trailing window top URLs are ranked over, 0 since start
+	chartView   int           // index of the chartViews charted
+
+	// threshold is the --alert-threshold request rate rule.
+	threshold traffic.Rule
+
+	// intervals are the reported request count timespans, see Intervals.
+	intervals []interval
+
+	// Local interface selection, see Interfaces.
+	ifaceFilter sniff.InterfaceFilter
+	ifaceWatch  time.Duration
+
+	// Offline capture replay configuration, see ReplayFile.
+	replayFile  string
+	replaySpeed float64
+	clock       traffic.Clock
+
+	// countCapacity bounds the sections and hosts counted, see
+	// CountCapacity.
+	countCapacity int
+
+	// urls aggregates request URLs into the sections counted, see
+	// AggregateURLs.
+	urls *urlAggregator
+
+	// Traffic recorded is saved to and restored from stateFile, see
+	// PersistState.
+	stateFile     string
+	stateInterval time.Duration
+
+	rc        traffic.Counter
+	recent    *traffic.WindowCounter // requests per URL over trailing windows
+	hosts     traffic.Counter
+	methods   *traffic.RequestCounter
+	responses traffic.Counter         // responses captured per section
+	errs      traffic.Counter         // 4xx and 5xx responses per section
+	latency   *traffic.LatencyTracker // response latency distributions per section
+	tls       *traffic.RequestCounter // TLS connections per version
+	ad        *traffic.AlertDetector
+	consumers sync.WaitGroup
+
+	// Bytes transferred per remote host, server port and section.
+	remoteBytes  *traffic.BandwidthTracker
+	portBytes    *traffic.BandwidthTracker
+	sectionBytes *traffic.BandwidthTracker
+	sections     *traffic.SectionTracker // detail of the requests per section
+
+	// drillMux guards the sections of the top URLs displayed, and the one
+	// drilled down into, see Drill.
+	drillMux sync.Mutex
+	shown    []string
+	drilled  string
+
+	// filterMux guards the traffic recorded for the filter applied to the
+	// UI, see Filter.
+	filterMux sync.RWMutex
+	filtered  *filteredTraffic
+
+	// Capture state of each interface, see Captures.
+	capturesMux  sync.Mutex
+	captures     map[string]sniff.CaptureStatus
+	capturesView *widgets.List
+
+	// refresh updates the UI without waiting for the next tick.
+	refresh chan struct{}
+
+	// Captured TLS handshakes and flow bytes feed their own consumers.
+	tlsStream       chan sniff.TLSHello
+	flowStream      chan sniff.FlowBytes
+	recordConsumers sync.WaitGroup
+
+	// Additional alerting rules, see AlertRules, guarded by settingsMux.
+	rules         []traffic.Rule
+	detectors     []ruleDetector
+	notifications chan traffic.Notification
+
+	// External alert notification, see Notifiers.
+	notifyOpts notify.Options
+	notifiers  []notify.Notifier
+	dispatcher *notify.Dispatcher
+
+	historyMux sync.Mutex
+	history    []traffic.Notification
+	status     map[string]traffic.Notification // latest notification per rule name
+}
+
+// latencyWindow is the trailing timespan of the response latency
+// percentiles displayed per URL.
+const latencyWindow = 5 * time.Minute
+
+// talkerWindow is the trailing timespan top talkers are ranked over in the
+// UI.
+const talkerWindow = 5 * time.Minute
+
+// SnapLen is the number of bytes captured of each packet, and the length
+// BPF expressions are compiled for.
+const SnapLen = 1600
+
+// TalkersN is the number of top talkers displayed by the UI.
+const TalkersN = 5
+
+// DefaultCountCapacity is the number of sections and hosts counted unless
+// configured by CountCapacity.
+const DefaultCountCapacity = 10000
+
+// recentKeys is the number of URLs counted over trailing windows, and whose
+// latencies are recorded; the least recently requested URLs beyond it are
+// forgotten.
+const recentKeys = 10000
+
+// talkerKeys is the number of remote hosts, ports and sections whose bytes
+// are recorded; the least recently seen beyond it are forgotten.
+const talkerKeys = 10000
+
+// sectionKeys is the number of paths and clients counted per section for
+// its detail, and detailSections the number of sections detailed; the least
+// recently requested sections beyond it are forgotten.
+const (
+	sectionKeys    = 100
+	detailSections = 1000
+)
+
+// topWindows are the trailing windows cycled through by NextTopWindow, 0
+// ranking URLs since start.
+var topWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour, 0}
+
+// chartView is a timespan the requests chart covers, labelled by s, with a
+// point per span.
+type chartView struct {
+	s    string
+	span time.Duration
+	num  int
+}
+
+// chartViews are the timespans cycled through by NextChartView.
+var chartViews = []chartView{
+	{"10m", 10 * time.Second, 60},
+	{"1h", time.Minute, 60},
+	{"24h", time.Hour, 24},
+}
+
+// interval is a timespan over which request counts are reported, labelled
+// by s.
+type interval struct {
+	s string
+	t time.Duration
+}
+
+// defaultIntervals are the timespans over which request counts are reported
+// unless configured by Intervals.
+var defaultIntervals = []interval{
+	{
+		s: "1m",
+		t: 1 * time.Minute,
+	},
+	{
+		s: "5m",
+		t: 5 * time.Minute,
+	},
+	{
+		s: "15m",
+		t: 15 * time.Minute,
+	},
+	{
+		s: "30m",
+		t: 30 * time.Minute,
+	},
+	{
+		s: "60m",
+		t: 60 * time.Minute,
+	},
+	{
+		s: "24hr",
+		t: 24 * time.Hour,
+	},
 }
 
 // NewBanken initiates instance with at:AlertThreshold, topN: Top N(umber) of
@@ -41,87 +224,525 @@ func NewBanken(ctx context.Context, at, topN int, bpf string, logger *log.Logger
 		ctx:    ctx,
 		logger: logger,
 
-		at:   at,
-		topN: topN,
-		bpf:  bpf,
+		topN:   topN,
+		bpf:    bpf,
+		filter: sniff.NewFilter(bpf),
+
+		threshold: traffic.Rule{
+			Metric:    traffic.MetricRequests,
+			Scope:     traffic.ScopeTotal,
+			Window:    2 * time.Minute,
+			Threshold: float64(at),
+		},
+
+		intervals:     defaultIntervals,
+		countCapacity: DefaultCountCapacity,
+		urls:          defaultURLAggregator,
+
+		clock:    traffic.NewWallClock(),
+		status:   make(map[string]traffic.Notification),
+		captures: make(map[string]sniff.CaptureStatus),
+	}
+}
+
+// AlertThreshold replaces the --alert-threshold rule, configuring the
+// window its request count is measured over, how often it is evaluated, its
+// hysteresis, and whether it alerts on a fixed threshold or anomalies of the
+// learnt baseline. Must be called before Init.
+func (b *Banken) AlertThreshold(r traffic.Rule) error {
+	if err := r.Validate(); err != nil {
+		return fmt.Errorf("invalid alert threshold: %w", err)
+	}
+	b.threshold = r
+	return nil
+}
+
+// AlertRules configures named alerting rules which are evaluated alongside
+// the request rate --alert-threshold. Must be called before Init.
+func (b *Banken) AlertRules(rules []traffic.Rule) error {
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+	b.rules = rules
+	return nil
+}
+
+// validateRules reports whether the rules are valid and uniquely named.
+func validateRules(rules []traffic.Rule) error {
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if r.Name == "" || names[r.Name] {
+			return fmt.Errorf("alert rules require unique names: %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// ruleDetector is the AlertDetector of an additional alerting rule, which is
+// stopped by can when the rule is removed.
+type ruleDetector struct {
+	*traffic.AlertDetector
+	can context.CancelFunc
+}
+
+// newRuleDetector starts the detector of an additional alerting rule.
+func (b *Banken) newRuleDetector(r traffic.Rule) ruleDetector {
+	ctx, can := context.WithCancel(b.ctx)
+	return ruleDetector{traffic.NewRuleDetector(ctx, b.clock, r, b.notifications), can}
+}
+
+// Reconfig are the settings which may be changed while Banken runs, see
+// Reconfigure.
+type Reconfig struct {
+	TopN      int
+	TopWindow time.Duration
+	BPF       string
+	Threshold traffic.Rule
+	Rules     []traffic.Rule
+}
+
+// Reconfigure applies the settings while Banken runs, keeping the traffic
+// recorded. Alert rules which measure the same traffic as before keep their
+// state, other rules start nominal and removed rules stop. The BPF is
+// applied to the running live captures. Must be called after Init.
+func (b *Banken) Reconfigure(c Reconfig) error {
+	if c.TopN < 1 {
+		return fmt.Errorf("top number of URLs must be positive: %d", c.TopN)
+	}
+	if err := validTopWindow(c.TopWindow); err != nil {
+		return err
+	}
+	if err := c.Threshold.Validate(); err != nil {
+		return fmt.Errorf("invalid alert threshold: %w", err)
+	}
+	if err := validateRules(c.Rules); err != nil {
+		return err
+	}
+	bpfChanged := b.replayFile == "" && c.BPF != b.filter.String()
+	if bpfChanged {
+		if _, err := sniff.ValidateFilter(c.BPF, SnapLen); err != nil {
+			return err
+		}
+	}
+	if err := b.ad.Reconfigure(c.Threshold); err != nil {
+		return err
+	}
+
+	b.settingsMux.Lock()
+	b.topN = c.TopN
+	b.topWindow = c.TopWindow
+	b.threshold = c.Threshold
+	current := make(map[string]ruleDetector)
+	for _, d := range b.detectors {
+		current[d.Rule().Name] = d
+	}
+	detectors := make([]ruleDetector, 0, len(c.Rules))
+	for _, r := range c.Rules {
+		if d, ok := current[r.Name]; ok && d.Reconfigure(r) == nil {
+			detectors = append(detectors, d)
+			delete(current, r.Name)
+			continue
+		}
+		detectors = append(detectors, b.newRuleDetector(r))
+	}
+	b.rules = c.Rules
+	b.detectors = detectors
+	b.settingsMux.Unlock()
+
+	// Rules removed, or replaced by a rule measuring other traffic.
+	for name, d := range current {
+		d.can()
+		b.historyMux.Lock()
+		delete(b.status, name)
+		b.historyMux.Unlock()
+	}
+
+	if bpfChanged {
+		if err := b.filter.Set(c.BPF); err != nil {
+			b.logger.Warn(err)
+		}
+	}
+	b.logger.WithFields(log.Fields{"top": c.TopN, "top_window": c.TopWindow, "bpf": b.filter.String(), "rules": len(c.Rules)}).Info("configuration reloaded")
+	return nil
+}
+
+// currentTopN is the number of top URLs displayed and reported.
+func (b *Banken) currentTopN() int {
+	b.settingsMux.RLock()
+	defer b.settingsMux.RUnlock()
+	return b.topN
+}
+
+// TopWindow configures the trailing window top URLs are ranked over, up to
+// traffic.MaxWindow, or 0 to rank them by their requests since start. Must
+// be called before Init.
+func (b *Banken) TopWindow(d time.Duration) error {
+	if err := validTopWindow(d); err != nil {
+		return err
+	}
+	b.topWindow = d
+	return nil
+}
+
+// validTopWindow reports whether top URLs may be ranked over the window.
+func validTopWindow(d time.Duration) error {
+	if d < 0 || d > traffic.MaxWindow {
+		return fmt.Errorf("top window %s must be between 0 and %s", d, traffic.MaxWindow)
+	}
+	return nil
+}
+
+// NextTopWindow ranks top URLs over the next of 1m, 5m, 1h and since start,
+// then refreshes the UI.
+func (b *Banken) NextTopWindow() {
+	b.settingsMux.Lock()
+	next := topWindows[0]
+	for i, w := range topWindows {
+		if w == b.topWindow && i+1 < len(topWindows) {
+			next = topWindows[i+1]
+		}
+	}
+	b.topWindow = next
+	b.settingsMux.Unlock()
+	b.refreshUI()
+}
+
+// NextChartView charts the requests over the next of the last 10m, 1h and
+// 24h, then refreshes the UI.
+func (b *Banken) NextChartView() {
+	b.settingsMux.Lock()
+	b.chartView = (b.chartView + 1) % len(chartViews)
+	b.settingsMux.Unlock()
+	b.refreshUI()
+}
+
+// refreshUI updates the UI without waiting for the next tick.
+func (b *Banken) refreshUI() {
+	select {
+	case b.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// currentChartView is the timespan the requests chart covers.
+func (b *Banken) currentChartView() chartView {
+	b.settingsMux.RLock()
+	defer b.settingsMux.RUnlock()
+	return chartViews[b.chartView]
+}
+
+// chartRequests provides the request counts displayed per span of the chart view,
+// oldest first, and the alert threshold scaled to a span. At most max points
+// are charted, dropping the oldest, but at least 2 so a line can be drawn.
+func (b *Banken) chartRequests(v chartView, max int) (counts []float64, threshold float64) {
+	counts = chartPoints(b.displayedSpanCounts(v.span, v.num), max)
+	r := b.ad.Rule()
+	if r.Window > 0 {
+		threshold = r.Threshold * float64(v.span) / float64(r.Window)
+	}
+	return counts, threshold
+}
+
+// chartPoints drops the oldest counts beyond max, but pads them to at least
+// 2 so a line can be drawn.
+func chartPoints(counts []float64, max int) []float64 {
+	if max < 2 {
+		max = 2
+	}
+	if len(counts) > max {
+		counts = counts[len(counts)-max:]
+	}
+	for len(counts) < 2 {
+		counts = append([]float64{0}, counts...)
+	}
+	return counts
+}
+
+// plot replaces the lines drawn by the chart.
+func plot(chart *widgets.Plot, lines ...[]float64) {
+	chart.Data = lines
+	// Scale an empty chart to 1 rather than dividing by 0.
+	chart.MaxVal = 0
+	if max, _ := ui.GetMaxFloat64From2dSlice(lines); max == 0 {
+		chart.MaxVal = 1
+	}
+}
+
+// currentTopWindow is the trailing window top URLs are ranked over.
+func (b *Banken) currentTopWindow() time.Duration {
+	b.settingsMux.RLock()
+	defer b.settingsMux.RUnlock()
+	return b.topWindow
+}
+
+// topRequests ranks the n most requested URLs over the top window.
+func (b *Banken) topRequests(n int) []ReqCount {
+	if w := b.currentTopWindow(); w > 0 {
+		return topNRequests(b.recent.Recent(w), n)
+	}
+	return topNRequests(b.rc.Export(), n)
+}
+
+// windowLabel describes the trailing window, eg: 'last 5m'.
+func windowLabel(d time.Duration) string {
+	if d == 0 {
+		return "since start"
+	}
+	return "last " + shortDuration(d)
+}
+
+// CountCapacity bounds the memory counting requests per section and host
+// to capacity keys each. The most requested keys are counted with
+// traffic.TopK, overestimating by at most the total requests divided by the
+// capacity; 0 counts every key exactly, in memory growing with every key
+// seen. Must be called before Init.
+func (b *Banken) CountCapacity(capacity int) error {
+	if capacity < 0 {
+		return fmt.Errorf("count capacity %d may not be negative", capacity)
+	}
+	b.countCapacity = capacity
+	return nil
+}
+
+// AggregateURLs configures how the URLs requested are aggregated into the
+// sections counted, displayed and alerted on, by default under the first
+// section of their path, see DefaultURLAggregation. Must be called before
+// Init.
+func (b *Banken) AggregateURLs(c URLAggregation) error {
+	a, err := newURLAggregator(c)
+	if err != nil {
+		return err
+	}
+	b.urls = a
+	return nil
+}
+
+// newCounter initializes a counter of the configured capacity.
+func (b *Banken) newCounter() traffic.Counter {
+	if b.countCapacity == 0 {
+		return new(traffic.RequestCounter)
+	}
+	return traffic.NewTopK(b.countCapacity)
+}
+
+// countError is the most the counter overestimates counts by.
+func countError(c traffic.Counter) uint64 {
+	if e, ok := c.(interface{ ErrorBound() uint64 }); ok {
+		return e.ErrorBound()
+	}
+	return 0
+}
+
+// DefaultIntervals provides the timespans request counts are reported over
+// unless configured by Intervals.
+func DefaultIntervals() []time.Duration {
+	ds := make([]time.Duration, 0, len(defaultIntervals))
+	for _, i := range defaultIntervals {
+		ds = append(ds, i.t)
+	}
+	return ds
+}
+
+// Intervals configures the timespans over which request counts and
+// response latencies are reported. Must be called before Init.
+func (b *Banken) Intervals(spans []time.Duration) error {
+	if len(spans) == 0 {
+		return errors.New("at least one interval is required")
+	}
+	is := make([]interval, 0, len(spans))
+	for _, t := range spans {
+		if t <= 0 {
+			return fmt.Errorf("interval %s must be positive", t)
+		}
+		i := interval{s: shortDuration(t), t: t}
+		for _, d := range defaultIntervals {
+			if d.t == t {
+				i.s = d.s
+			}
+		}
+		is = append(is, i)
+	}
+	b.intervals = is
+	return nil
+}
+
+// Interfaces selects the local interfaces captured from, and how often the
+// interfaces are polled to capture from those which appear later, eg:
+// containers and VPN tunnels; 0 disables polling. Must be called before
+// Init.
+func (b *Banken) Interfaces(f sniff.InterfaceFilter, watch time.Duration) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	if watch < 0 {
+		return fmt.Errorf("interface watch interval %s may not be negative", watch)
+	}
+	b.ifaceFilter = f
+	b.ifaceWatch = watch
+	return nil
+}
+
+// CheckBPF compiles the BPF expression for the link type of the replayed
+// capture file, or the link types of the local interfaces, returning an
+// error if it is invalid. Link types it only fails for are logged.
+func (b *Banken) CheckBPF() error {
+	var lts []layers.LinkType
+	if b.replayFile != "" {
+		lt, err := sniff.FileLinkType(b.replayFile)
+		if err != nil {
+			return err
+		}
+		lts = append(lts, lt)
+	}
+	programs, err := sniff.ValidateFilter(b.bpf, SnapLen, lts...)
+	if err != nil {
+		return err
+	}
+	for _, p := range programs {
+		if p.Err != nil {
+			b.logger.Warnf("BPF %q cannot be applied to %s interfaces: %v", b.bpf, p.LinkType, p.Err)
+		}
+	}
+	return nil
+}
+
+// ReplayFile configures Banken to read packets from the capture file at path
+// instead of the local interfaces. The traffic models are then driven by
+// packet timestamps, replayed at speed times the original rate; a speed of
+// 0 replays as fast as possible. Must be called before Init.
+func (b *Banken) ReplayFile(path string, speed float64) {
+	b.replayFile = path
+	b.replaySpeed = speed
+	b.clock = traffic.NewPacketClock()
+}
+
+// Notifiers configures delivery of alert state changes to external systems.
+// Must be called before Init.
+func (b *Banken) Notifiers(opts notify.Options, notifiers ...notify.Notifier) {
+	b.notifyOpts = opts
+	b.notifiers = notifiers
+}
+
+// Close waits for pending alert notifications to be delivered, and saves
+// the traffic recorded when persisting state.
+func (b *Banken) Close() {
+	if b.dispatcher != nil {
+		b.dispatcher.Close()
+	}
+	if b.persisting() && b.ad != nil {
+		if err := b.saveState(); err != nil {
+			b.logger.Error(err)
+		}
 	}
 }
 
 // Init launches all consumers of the collected packet data models, then logs
 // and updates the UI with http traffic status.
-func (b *Banken) Init(topN, reqCnts, alerts *widgets.List) ([]string, chan sniff.HTTPXPacket, error) {
+func (b *Banken) Init(topN, reqCnts, talkers, captures, alerts *widgets.List, chart *widgets.Plot, detail *view.Detail) ([]string, chan sniff.HTTPXPacket, error) {
 	// Detect interfaces
-	ifaces, err := sniff.DetectInterfaces()
-	if err != nil {
-		b.logger.Fatal(err)
-		return nil, nil, err
+	var ifaces []string
+	if b.replayFile == "" {
+		var err error
+		ifaces, err = sniff.DetectInterfaces(b.ifaceFilter)
+		if err != nil {
+			b.logger.Fatal(err)
+			return nil, nil, err
+		}
+	}
+
+	b.capturesView = captures
+
+	if len(b.notifiers) > 0 {
+		b.dispatcher = notify.NewDispatcher(b.ctx, b.logger, b.notifyOpts, b.notifiers...)
 	}
 
 	// Initialize Traffic Monitor alerter
-	notifications := make(chan traffic.Notification, 1)
-	b.ad = traffic.NewAlertDetector(b.ctx, time.Now(), b.at, notifications)
-	go func(a *traffic.AlertDetector, logger *log.Logger) {
-		i := 0
-		for n := range notifications {
-			i++
-			logger.Infof("RequestRate Notification: %s", n.String())
-			if alerts != nil {
-				alerts.Rows = append(alerts.Rows, fmt.Sprintf("[%d] %s", i, n.String()))
-				ui.Render(alerts)
-			}
-		}
-	}(b.ad, b.logger)
+	b.notifications = make(chan traffic.Notification, 1)
+	b.ad = traffic.NewRuleDetector(b.ctx, b.clock, b.threshold, b.notifications)
+	go b.recordNotifications(b.notifications, alerts)
+	b.detectors = make([]ruleDetector, 0, len(b.rules))
+	for _, r := range b.rules {
+		b.detectors = append(b.detectors, b.newRuleDetector(r))
+	}
 
 	// Initialize Route Counter
-	b.rc = new(traffic.RequestCounter)
-	rcTick := time.NewTicker(5 * time.Second)
-	intervals := []struct {
-		s string
-		t time.Duration
-	}{
-		{
-			s: "1m",
-			t: 1 * time.Minute,
-		},
-		{
-			s: "5m",
-			t: 5 * time.Minute,
-		},
-		{
-			s: "15m",
-			t: 15 * time.Minute,
-		},
-		{
-			s: "30m",
-			t: 30 * time.Minute,
-		},
-		{
-			s: "60m",
-			t: 60 * time.Minute,
-		},
-		{
-			s: "24hr",
-			t: 24 * time.Hour,
-		},
+	b.rc = b.newCounter()
+	b.recent = traffic.NewWindowCounter(b.clock, recentKeys)
+	b.hosts = b.newCounter()
+	b.methods = new(traffic.RequestCounter)
+	b.responses = b.newCounter()
+	b.errs = b.newCounter()
+	b.latency = traffic.NewLatencyTracker(b.clock, recentKeys)
+	b.tls = new(traffic.RequestCounter)
+	b.remoteBytes = traffic.NewBandwidthTracker(b.clock, talkerKeys)
+	b.portBytes = traffic.NewBandwidthTracker(b.clock, talkerKeys)
+	b.sectionBytes = traffic.NewBandwidthTracker(b.clock, talkerKeys)
+	b.sections = traffic.NewSectionTracker(b.clock, sectionKeys, detailSections)
+	if b.persisting() {
+		if err := b.restoreState(); err != nil {
+			b.logger.Warnf("starting without the traffic recorded previously: %v", err)
+		}
+		go b.saveStates()
 	}
+	rcTick := time.NewTicker(5 * time.Second)
+	b.refresh = make(chan struct{}, 1)
 	go func() {
-		for range rcTick.C {
-			m := b.rc.Export()
+		for {
+			select {
+			case <-rcTick.C:
+				b.recent.Prune()
+				b.sections.Prune()
+				b.remoteBytes.Prune()
+				b.portBytes.Prune()
+				b.sectionBytes.Prune()
+				b.latency.Prune()
+				if ft := b.filtering(); ft != nil {
+					ft.recent.Prune()
+				}
+			case <-b.refresh:
+			}
+			resps, errs, lat := b.responses.Export(), b.errs.Export(), b.latency.Recent(latencyWindow)
 			f := log.Fields{}
-			reqs := topNRequests(m, b.topN)
+			n := b.currentTopN()
+			window := windowLabel(b.currentTopWindow())
+			ft := b.filtering()
+			if ft != nil {
+				window = fmt.Sprintf("%s, matching %q since %s", window, ft.filter, ft.since.Format("15:04:05"))
+			}
+			reqs := b.displayedRequests(n)
+			b.show(reqs)
 			top := make([]string, 0)
 			for i, v := range reqs {
-				s := fmt.Sprintf("%s -> %d", v.URL, v.C)
+				s := fmt.Sprintf("%s -> %d%s", v.URL, v.C, responseSummary(resps[v.URL], errs[v.URL], lat[v.URL]))
 				f[fmt.Sprintf("%d", i+1)] = s
 				top = append(top, fmt.Sprintf("[%d]: %s", i+1, s))
 			}
-			b.logger.WithFields(f).Infof("Top %d URLs", b.topN)
+			b.logger.WithFields(f).Infof("Top %d URLs, %s", n, window)
+			if b.logger.IsLevelEnabled(log.DebugLevel) {
+				for _, i := range b.intervals {
+					lat := b.latency.Recent(i.t)
+					latFields := log.Fields{}
+					for _, v := range reqs {
+						if l, ok := lat[v.URL]; ok {
+							latFields[v.URL] = latencyPercentiles(l)
+						}
+					}
+					b.logger.WithFields(latFields).Debugf("response latency over %s", i.s)
+				}
+			}
 
 			counts := make([]string, 0)
 			countFields := log.Fields{}
-			for _, i := range intervals {
-				now := time.Now()
-				c := b.ad.GetSpanCount(now.Add(-i.t), now)
+			if ft != nil {
+				countFields["filter"] = ft.filter.String()
+			}
+			for _, i := range b.intervals {
+				now := b.clock.Time()
+				c := b.displayedSpanCount(now.Add(-i.t), now)
 				if c > 0 {
 					cStr := fmt.Sprintf("%s: %d", i.s, c)
 					countFields[i.s] = c
@@ -130,14 +751,46 @@ func (b *Banken) Init(topN, reqCnts, alerts *widgets.List) ([]string, chan sniff
 			}
 			b.logger.WithFields(countFields).Infof("http request count timespans")
 
+			talks := make([]string, 0)
+			talkFields := log.Fields{}
+			for i, v := range topTalkers(b.remoteBytes.Recent(talkerWindow), TalkersN) {
+				s := fmt.Sprintf("%s -> %s sent, %s received", v.Key, byteSize(v.Sent), byteSize(v.Received))
+				talkFields[fmt.Sprintf("%d", i+1)] = s
+				talks = append(talks, fmt.Sprintf("[%d]: %s", i+1, s))
+			}
+			b.logger.WithFields(talkFields).Infof("Top %d talkers over %s", TalkersN, talkerWindow)
+
 			if topN != nil && reqCnts != nil {
 				if len(top) == 0 {
 					top = []string{"waiting for http traffic..."}
 				}
+				view.SetTopN(n)
+				topN.Title = fmt.Sprintf("Top %d HTTP Requested Paths, %s", n, window)
 				topN.Rows = top
-				ui.Render(topN)
+				view.Render(topN)
 				reqCnts.Rows = counts
-				ui.Render(reqCnts)
+				view.Render(reqCnts)
+			}
+			if talkers != nil {
+				if len(talks) == 0 {
+					talks = []string{"waiting for tcp traffic..."}
+				}
+				talkers.Rows = talks
+				view.Render(talkers)
+			}
+			if chart != nil {
+				v := b.currentChartView()
+				counts, threshold := b.chartRequests(v, chart.Inner.Dx()-5)
+				line := make([]float64, len(counts))
+				for i := range line {
+					line[i] = threshold
+				}
+				chart.Title = fmt.Sprintf("HTTP Requests per %s, last %s -- threshold %.1f", v.span, v.s, threshold)
+				plot(chart, counts, line)
+				view.Render(chart)
+			}
+			if detail != nil {
+				b.renderDetail(detail)
 			}
 		}
 	}()
@@ -147,37 +800,335 @@ func (b *Banken) Init(topN, reqCnts, alerts *widgets.List) ([]string, chan sniff
 	packetStream := make(chan sniff.HTTPXPacket, consumers)
 	// Initialze stream consumers before reading packets
 	for i := 0; i < consumers; i++ {
+		b.consumers.Add(1)
 		go func() {
+			defer b.consumers.Done()
 			for p := range packetStream {
 				// Increment traffic counter
 				b.ad.Increment(1, p.TS)
 
 				// Record the URL's route to counter
-				u := HTTPURLSlug(p.Host, p.Path)
+				u := b.urls.Section(p.Host, p.EscapedPath())
+				b.observeRules(u, p)
 				log.Tracef("PacketConsumer received: %v", u)
 				b.rc.IncKey(u, uint64(1))
+				b.recent.IncKey(u, uint64(1), p.TS)
+				b.hosts.IncKey(normalizeHost(p.Host), uint64(1))
+				b.methods.IncKey(p.Method, uint64(1))
+				if p.StatusCode != 0 {
+					b.responses.IncKey(u, uint64(1))
+					if p.StatusCode >= 400 {
+						b.errs.IncKey(u, uint64(1))
+					}
+					b.latency.Observe(u, p.Latency, p.TS)
+				}
+				b.sectionBytes.Observe(u, p.RequestSize, p.ResponseSize, p.TS)
+				b.sections.Observe(u, p.Path, p.Method, p.Client, p.TS)
+				b.recordFiltered(p.Host, p.Path, p.Method, u, p.TS)
 			}
 		}()
 	}
 
+	// HTTPS paths are encrypted, so connections are counted per server name.
+	b.tlsStream = make(chan sniff.TLSHello, consumers)
+	b.recordConsumers.Add(1)
+	go func() {
+		defer b.recordConsumers.Done()
+		for h := range b.tlsStream {
+			host := h.Server()
+			u := HTTPSURLSlug(host)
+			log.Tracef("TLSConsumer received: %v %s %v", u, h.Version, h.ALPN)
+			b.rc.IncKey(u, uint64(1))
+			b.recent.IncKey(u, uint64(1), h.TS)
+			b.hosts.IncKey(normalizeHost(host), uint64(1))
+			b.tls.IncKey(h.Version, uint64(1))
+			b.recordFiltered(host, "/", "", u, h.TS)
+		}
+	}()
+
+	// Bytes of every TCP flow, HTTP or not.
+	b.flowStream = make(chan sniff.FlowBytes, consumers)
+	b.recordConsumers.Add(1)
+	go func() {
+		defer b.recordConsumers.Done()
+		for f := range b.flowStream {
+			b.remoteBytes.Observe(f.RemoteAddr, f.Sent, f.Received, f.TS)
+			b.portBytes.Observe(f.ServerPort, f.Sent, f.Received, f.TS)
+		}
+	}()
+
 	return ifaces, packetStream, nil
 }
 
 // Run initializes traffic capture for each interface, feeding data
 // to analysis models.
-func (b *Banken) Run(ifaces []string, packetStream chan sniff.HTTPXPacket) {
-	ctx := b.ctx
-	bpfFilter := viper.GetString("bpf")
+//
+// When replaying a capture file Run returns once the whole file has been
+// consumed by the analysis models, or with the error which stopped the
+// replay. Otherwise it runs until the context is closed; interfaces which
+// fail are retried in the background, and Run only returns an error if no
+// interface could be opened.
+func (b *Banken) Run(ifaces []string, packetStream chan sniff.HTTPXPacket) error {
+	if b.replayFile != "" {
+		return b.replay(packetStream)
+	}
+	c := &interfaceCaptures{
+		b:         b,
+		output:    b.output(packetStream),
+		status:    make(chan sniff.CaptureStatus, len(ifaces)),
+		listeners: make(map[string]context.CancelFunc),
+	}
 	for _, iface := range ifaces {
-		go func(iface string) {
-			ctxLogger := b.logger.WithFields(log.Fields{"iface": iface})
-			b.logger.Debugf("BPF: %q", b.bpf)
-			sniff.InterfaceListener(ctx, packetStream, iface, bpfFilter, 1600, ctxLogger.Logger)
-		}(iface)
+		c.start(iface)
+	}
+	changes := make(chan sniff.InterfaceChange)
+	if b.ifaceWatch > 0 {
+		go sniff.WatchInterfaces(b.ctx, b.ifaceFilter, ifaces, b.ifaceWatch, changes)
+	} else if len(ifaces) == 0 {
+		return errors.New("no interfaces to capture from")
 	}
+	return c.watch(ifaces, changes)
+}
+
+// interfaceCaptures manages the listener of each captured interface.
+type interfaceCaptures struct {
+	b         *Banken
+	output    sniff.Output
+	status    chan sniff.CaptureStatus
+	listeners map[string]context.CancelFunc
+}
+
+// start launches a listener on the interface.
+func (c *interfaceCaptures) start(iface string) {
+	ctx, can := context.WithCancel(c.b.ctx)
+	c.listeners[iface] = can
+	go func() {
+		ctxLogger := c.b.logger.WithFields(log.Fields{"iface": iface})
+		c.b.logger.Debugf("BPF: %q", c.b.filter)
+		sniff.InterfaceListener(ctx, c.output, iface, c.b.filter, SnapLen, c.status, ctxLogger.Logger)
+	}()
+}
+
+// stop closes the interface's listener and forgets its status.
+func (c *interfaceCaptures) stop(iface string) {
+	if can, ok := c.listeners[iface]; ok {
+		can()
+		delete(c.listeners, iface)
+	}
+	c.b.forgetCapture(iface)
+}
+
+// watch records the capture status of each interface and follows the
+// interfaces which appear and disappear until the context is closed. It
+// returns an error once every initial interface has failed its first
+// attempt to open without any interface having opened.
+func (c *interfaceCaptures) watch(ifaces []string, changes chan sniff.InterfaceChange) error {
+	initial := make(map[string]bool)
+	for _, iface := range ifaces {
+		initial[iface] = true
+	}
+	failed := make(map[string]error)
+	opened := false
+	for {
+		select {
+		case <-c.b.ctx.Done():
+			return nil
+		case ch := <-changes:
+			if ch.Removed {
+				c.b.logger.Infof("interface %q disappeared, stopping capture", ch.Iface)
+				c.stop(ch.Iface)
+				delete(initial, ch.Iface)
+			} else if _, ok := c.listeners[ch.Iface]; !ok {
+				c.b.logger.Infof("interface %q appeared, starting capture", ch.Iface)
+				c.start(ch.Iface)
+			}
+		case s := <-c.status:
+			if _, ok := c.listeners[s.Iface]; !ok {
+				// Sent before the listener was stopped.
+				continue
+			}
+			c.b.recordCapture(s)
+			switch s.State {
+			case sniff.CaptureRunning:
+				opened = true
+			case sniff.CaptureFailed:
+				if _, ok := failed[s.Iface]; !ok {
+					failed[s.Iface] = s.Err
+				}
+			}
+		}
+		if !opened && len(initial) > 0 && allFailed(initial, failed) {
+			msgs := make([]string, 0, len(initial))
+			for _, iface := range ifaces {
+				if initial[iface] {
+					msgs = append(msgs, fmt.Sprintf("%s: %v", iface, failed[iface]))
+				}
+			}
+			return fmt.Errorf("no interface could be opened; %s", strings.Join(msgs, "; "))
+		}
+	}
+}
+
+// allFailed reports whether every interface has failed.
+func allFailed(ifaces map[string]bool, failed map[string]error) bool {
+	for iface := range ifaces {
+		if _, ok := failed[iface]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// recordCapture retains the interface's capture status and displays the
+// status of every interface.
+func (b *Banken) recordCapture(s sniff.CaptureStatus) {
+	b.capturesMux.Lock()
+	defer b.capturesMux.Unlock()
+	b.captures[s.Iface] = s
+	b.renderCaptures()
+}
+
+// forgetCapture removes the status of an interface which disappeared.
+func (b *Banken) forgetCapture(iface string) {
+	b.capturesMux.Lock()
+	defer b.capturesMux.Unlock()
+	delete(b.captures, iface)
+	b.renderCaptures()
+}
 
-	// Wait for stop signal
-	<-ctx.Done()
+// renderCaptures displays the status of every interface. capturesMux must
+// be held.
+func (b *Banken) renderCaptures() {
+	if b.capturesView == nil {
+		return
+	}
+	names := make([]string, 0, len(b.captures))
+	for iface := range b.captures {
+		names = append(names, iface)
+	}
+	sort.Strings(names)
+	rows := make([]string, 0, len(names))
+	for _, iface := range names {
+		rows = append(rows, captureSummary(b.captures[iface]))
+	}
+	b.capturesView.Rows = rows
+	view.Render(b.capturesView)
+}
+
+// Captures returns the latest capture status of each interface.
+func (b *Banken) Captures() map[string]sniff.CaptureStatus {
+	b.capturesMux.Lock()
+	defer b.capturesMux.Unlock()
+	captures := make(map[string]sniff.CaptureStatus, len(b.captures))
+	for k, v := range b.captures {
+		captures[k] = v
+	}
+	return captures
+}
+
+// replay feeds the capture file through the analysis models, then waits for
+// the packet consumers to drain before flushing the alert detector.
+func (b *Banken) replay(packetStream chan sniff.HTTPXPacket) error {
+	adv, _ := b.clock.(sniff.Advancer)
+	err := sniff.FileListener(b.ctx, b.output(packetStream), b.replayFile, b.bpf, b.replaySpeed, adv, b.logger)
+	if err != nil {
+		return fmt.Errorf("replay of %q failed: %w", b.replayFile, err)
+	}
+	close(packetStream)
+	close(b.tlsStream)
+	close(b.flowStream)
+	b.consumers.Wait()
+	b.recordConsumers.Wait()
+	b.flushDetectors()
+	b.logger.Infof("replay of %q complete", b.replayFile)
+	return nil
+}
+
+// output directs captured records to the consumers launched by Init.
+func (b *Banken) output(packetStream chan sniff.HTTPXPacket) sniff.Output {
+	return sniff.Output{HTTP: packetStream, TLS: b.tlsStream, Flows: b.flowStream}
+}
+
+// recordNotifications logs and displays the notifications of every alert
+// rule, retaining them for reports.
+func (b *Banken) recordNotifications(notifications chan traffic.Notification, alerts *widgets.List) {
+	for n := range notifications {
+		b.logger.WithFields(log.Fields{
+			"rule":      n.RuleName(),
+			"state":     n.State(),
+			"value":     n.Value(),
+			"threshold": n.Threshold(),
+		}).Infof("RequestRate Notification: %s", n.String())
+		b.historyMux.Lock()
+		b.history = append(b.history, n)
+		b.status[n.RuleName()] = n
+		i := len(b.history)
+		if alerts != nil {
+			alerts.Rows = append(alerts.Rows, fmt.Sprintf("[%d] %s", i, n.String()))
+		}
+		b.historyMux.Unlock()
+		if alerts != nil {
+			view.Render(alerts)
+		}
+		if b.dispatcher != nil {
+			b.dispatcher.Send(notificationEvent(n))
+		}
+	}
+}
+
+// notificationEvent describes the notification for external notifiers.
+func notificationEvent(n traffic.Notification) notify.Event {
+	e := notify.Event{
+		Rule:      n.RuleName(),
+		State:     string(n.State()),
+		Message:   n.String(),
+		Time:      n.Time(),
+		Metric:    string(n.Metric()),
+		Key:       n.Key(),
+		Value:     n.Value(),
+		Threshold: n.Threshold(),
+	}
+	// Anomaly rules recovering before their baseline is learnt have no
+	// threshold.
+	if math.IsInf(e.Threshold, 0) {
+		e.Threshold = 0
+	}
+	return e
+}
+
+// observeRules offers the packet to the additional alerting rules.
+func (b *Banken) observeRules(section string, p sniff.HTTPXPacket) {
+	b.settingsMux.RLock()
+	defer b.settingsMux.RUnlock()
+	if len(b.detectors) == 0 {
+		return
+	}
+	s := traffic.Sample{
+		TS:       p.TS,
+		Host:     normalizeHost(p.Host),
+		Section:  section,
+		Requests: 1,
+		Bytes:    p.ResponseSize,
+	}
+	if p.StatusCode != 0 {
+		s.Responses = 1
+		if p.StatusCode >= 400 {
+			s.Errors = 1
+		}
+	}
+	for _, d := range b.detectors {
+		d.Observe(s)
+	}
+}
+
+// flushDetectors records the pending traffic of every alert detector.
+func (b *Banken) flushDetectors() {
+	b.ad.Flush()
+	b.settingsMux.RLock()
+	defer b.settingsMux.RUnlock()
+	for _, d := range b.detectors {
+		d.Flush()
+	}
 }
 
 func (b *Banken) getAlertState() traffic.Notification {
@@ -2,14 +2,17 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/ropes/banken/pkg/sniff"
 	"github.com/ropes/banken/pkg/traffic"
 
 	log "github.com/sirupsen/logrus"
@@ -37,7 +40,7 @@ func TestRequests(t *testing.T) {
 	l.SetOutput(os.Stderr)
 	b := NewBanken(ctx, 10, 10, "", l)
 
-	ifaces, reqs, err := b.Init(nil, nil, nil)
+	ifaces, reqs, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,3 +94,279 @@ func TestRequests(t *testing.T) {
 		t.Errorf("timeseries span of [%v-%v] was below expected value: %d", start, now, s)
 	}
 }
+
+func TestIntervals(t *testing.T) {
+	b := NewBanken(context.Background(), 10, 10, "tcp port 80", log.New())
+	if err := b.Intervals([]time.Duration{90 * time.Second, time.Hour, 2 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	// Default timespans keep their labels.
+	exp := []interval{{"1m30s", 90 * time.Second}, {"60m", time.Hour}, {"2h", 2 * time.Hour}}
+	if !reflect.DeepEqual(b.intervals, exp) {
+		t.Errorf("intervals = %v, exp: %v", b.intervals, exp)
+	}
+	if err := b.Intervals(nil); err == nil {
+		t.Error("no intervals were accepted")
+	}
+	if err := b.Intervals([]time.Duration{0}); err == nil {
+		t.Error("an empty interval was accepted")
+	}
+}
+
+func TestTopWindow(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	if err := b.TopWindow(2 * time.Hour); err == nil {
+		t.Error("top window beyond the counted timeseries should be rejected")
+	}
+	if err := b.TopWindow(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Init(nil, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lift was busy all morning, the ski run is busy now.
+	now := time.Now()
+	b.rc.IncKey("http://rusutsu.com/lift", 100)
+	b.recent.IncKey("http://rusutsu.com/lift", 100, now.Add(-30*time.Minute))
+	b.rc.IncKey("http://rusutsu.com/ski", 5)
+	b.recent.IncKey("http://rusutsu.com/ski", 5, now)
+
+	for _, exp := range []struct {
+		window time.Duration
+		top    []ReqCount
+	}{
+		{time.Minute, []ReqCount{{URL: "http://rusutsu.com/ski", C: 5}}},
+		{5 * time.Minute, []ReqCount{{URL: "http://rusutsu.com/ski", C: 5}}},
+		{time.Hour, []ReqCount{{URL: "http://rusutsu.com/lift", C: 100}, {URL: "http://rusutsu.com/ski", C: 5}}},
+		{0, []ReqCount{{URL: "http://rusutsu.com/lift", C: 100}, {URL: "http://rusutsu.com/ski", C: 5}}},
+		{time.Minute, []ReqCount{{URL: "http://rusutsu.com/ski", C: 5}}},
+	} {
+		if w := b.currentTopWindow(); w != exp.window {
+			t.Fatalf("top window %s != %s", w, exp.window)
+		}
+		if top := b.topRequests(3); !reflect.DeepEqual(top, exp.top) {
+			t.Errorf("top requests over %s: %v != %v", exp.window, top, exp.top)
+		}
+		b.NextTopWindow()
+	}
+}
+
+func TestChartView(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 120, 10, "tcp port 80", l)
+	if _, _, err := b.Init(nil, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	b.ad.Increment(7, time.Now())
+	b.ad.Flush()
+
+	for _, exp := range []struct {
+		s         string
+		points    int
+		threshold float64
+	}{
+		{"10m", 60, 10},
+		{"1h", 60, 60},
+		{"24h", 24, 3600},
+		{"10m", 60, 10},
+	} {
+		v := b.currentChartView()
+		if v.s != exp.s {
+			t.Fatalf("chart view %s != %s", v.s, exp.s)
+		}
+		counts, threshold := b.chartRequests(v, 100)
+		if len(counts) != exp.points {
+			t.Errorf("%s chart has %d points, expected %d", v.s, len(counts), exp.points)
+		}
+		if threshold != exp.threshold {
+			t.Errorf("%s chart threshold %f != %f", v.s, threshold, exp.threshold)
+		}
+		sum := 0.0
+		for _, c := range counts {
+			sum += c
+		}
+		if sum != 7 {
+			t.Errorf("%s chart counts %v requests, expected 7", v.s, sum)
+		}
+		b.NextChartView()
+	}
+
+	// Narrow charts drop the oldest points, but keep a line to draw.
+	if counts, _ := b.chartRequests(chartViews[0], 10); len(counts) != 10 || counts[9] != 7 {
+		t.Errorf("narrow chart counts %v, expected the 10 latest", counts)
+	}
+	if counts, _ := b.chartRequests(chartViews[0], -3); len(counts) != 2 {
+		t.Errorf("tiny chart counts %v, expected 2 points", counts)
+	}
+}
+
+func TestCountCapacity(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	if err := b.CountCapacity(-1); err == nil {
+		t.Error("negative capacity should be rejected")
+	}
+	if err := b.CountCapacity(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Init(nil, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b.rc.IncKey("http://rusutsu.com/ski", 10)
+	for i := 0; i < 5; i++ {
+		b.rc.IncKey(fmt.Sprintf("http://crawl.example/%d", i), 1)
+	}
+	if n := len(b.rc.Export()); n != 2 {
+		t.Errorf("counted %d sections beyond the capacity", n)
+	}
+	r := b.Report()
+	if len(r.Top) == 0 || r.Top[0].URL != "http://rusutsu.com/ski" || r.Top[0].C != 10 {
+		t.Errorf("heavy hitter was not counted exactly: %+v", r.Top)
+	}
+	if r.TopError != 5 {
+		t.Errorf("report error bound %d != 5", r.TopError)
+	}
+
+	b = NewBanken(ctx, 10, 10, "tcp port 80", l)
+	if err := b.CountCapacity(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.newCounter().(*traffic.RequestCounter); !ok {
+		t.Error("capacity 0 should count every key exactly")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	ski := traffic.Rule{Name: "ski", Metric: traffic.MetricRequests, Scope: traffic.ScopeHost, Key: "rusutsu.com", Window: time.Minute, Threshold: 100}
+	lift := traffic.Rule{Name: "lift", Metric: traffic.MetricRequests, Scope: traffic.ScopeTotal, Window: time.Minute, Threshold: 50}
+	if err := b.AlertRules([]traffic.Rule{ski, lift}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Init(nil, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	skiDetector := b.detectors[0].AlertDetector
+
+	threshold := b.threshold
+	threshold.Threshold = 20
+	ski.Threshold = 200
+	gondola := traffic.Rule{Name: "gondola", Metric: traffic.MetricErrorRatio, Scope: traffic.ScopeTotal, Window: time.Minute, Threshold: 0.5}
+	err := b.Reconfigure(Reconfig{TopN: 3, BPF: "tcp port 8080", Threshold: threshold, Rules: []traffic.Rule{ski, gondola}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := b.currentTopN(); n != 3 {
+		t.Errorf("top n was not reconfigured: %d", n)
+	}
+	if f := b.filter.String(); f != "tcp port 8080" {
+		t.Errorf("BPF was not reconfigured: %q", f)
+	}
+	if r := b.ad.Rule(); r.Threshold != 20 {
+		t.Errorf("alert threshold was not reconfigured: %+v", r)
+	}
+	// Rules measuring the same traffic keep their detector, and its state.
+	if len(b.detectors) != 2 || b.detectors[0].AlertDetector != skiDetector || b.detectors[1].Rule().Name != "gondola" {
+		t.Fatalf("unexpected detectors after reconfiguration: %+v", b.rules)
+	}
+	if r := skiDetector.Rule(); r.Threshold != 200 {
+		t.Errorf("rule was not reconfigured: %+v", r)
+	}
+
+	// Invalid settings are rejected without applying any of them.
+	for _, c := range []Reconfig{
+		{TopN: 0, BPF: "tcp port 8080", Threshold: threshold},
+		{TopN: 5, BPF: "tcp port 8080", Threshold: threshold, Rules: []traffic.Rule{ski, ski}},
+	} {
+		if err := b.Reconfigure(c); err == nil {
+			t.Errorf("invalid settings were applied: %+v", c)
+		}
+	}
+	if n := b.currentTopN(); n != 3 || len(b.detectors) != 2 {
+		t.Errorf("settings changed by a rejected reconfiguration: top %d, %d rules", n, len(b.detectors))
+	}
+}
+
+func TestCaptureFailures(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	_, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run aborts once every interface failed to open.
+	ifaces := []string{"banken-missing0", "banken-missing1"}
+	if err := b.Run(ifaces, packets); err == nil {
+		t.Fatal("capture without any openable interface should fail")
+	}
+	captures := b.Captures()
+	for _, iface := range ifaces {
+		if s := captures[iface]; s.State != sniff.CaptureFailed || s.Err == nil || s.Attempts != 1 || s.Retry != time.Second {
+			t.Errorf("unexpected %s status: %+v", iface, s)
+		}
+	}
+
+	// Failures are tolerated while any interface captures, and listeners
+	// follow the interfaces which appear and disappear.
+	c := &interfaceCaptures{
+		b:         b,
+		status:    make(chan sniff.CaptureStatus),
+		listeners: make(map[string]context.CancelFunc),
+	}
+	c.start("eth0")
+	c.start("tun0")
+	changes := make(chan sniff.InterfaceChange)
+	done := make(chan error)
+	go func() {
+		done <- c.watch([]string{"eth0", "tun0"}, changes)
+	}()
+	// The listeners of the missing interfaces report their own failures.
+	status := c.status
+	waitFor := func(iface string, state sniff.CaptureState) {
+		deadline := time.Now().Add(5 * time.Second)
+		for b.Captures()[iface].State != state && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	status <- sniff.CaptureStatus{Iface: "eth0", State: sniff.CaptureRunning}
+	waitFor("tun0", sniff.CaptureFailed)
+	changes <- sniff.InterfaceChange{Iface: "veth1"}
+	changes <- sniff.InterfaceChange{Iface: "eth0", Removed: true}
+	status <- sniff.CaptureStatus{Iface: "eth0", State: sniff.CaptureRunning}
+	waitFor("veth1", sniff.CaptureFailed)
+	captures = b.Captures()
+	if _, ok := captures["eth0"]; ok {
+		t.Error("status of a removed interface was retained")
+	}
+	if _, ok := c.listeners["veth1"]; !ok {
+		t.Error("no listener started for an added interface")
+	}
+	can()
+	if err := <-done; err != nil {
+		t.Errorf("capture with an open interface failed: %v", err)
+	}
+	tun := sniff.CaptureStatus{Iface: "tun0", State: sniff.CaptureFailed, Err: errors.New("no such device"), Attempts: 1, Retry: time.Second}
+	if s := captureSummary(tun); s != "tun0: failed (no such device) on attempt 1, retrying in 1s" {
+		t.Errorf("unexpected capture summary %q", s)
+	}
+}
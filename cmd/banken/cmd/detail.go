@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic"
+	"github.com/ropes/banken/pkg/view"
+)
+
+// detailView is the timespan a drilled down section's requests are charted
+// over, limited to traffic.MaxWindow.
+var detailView = chartView{"1h", time.Minute, 60}
+
+// show records the sections of the top URLs displayed, so their rows may be
+// drilled down into.
+func (b *Banken) show(reqs []ReqCount) {
+	shown := make([]string, len(reqs))
+	for i, r := range reqs {
+		shown[i] = r.URL
+	}
+	b.drillMux.Lock()
+	b.shown = shown
+	b.drillMux.Unlock()
+}
+
+// Drill details the requests to the section of the top URLs' row, reporting
+// false when the row is not displayed or its section has no detail, eg: the
+// encrypted paths of an HTTPS server. The detail is updated until Undrill.
+func (b *Banken) Drill(row int) bool {
+	b.drillMux.Lock()
+	if row < 0 || row >= len(b.shown) {
+		b.drillMux.Unlock()
+		return false
+	}
+	section := b.shown[row]
+	b.drillMux.Unlock()
+	if _, ok := b.sectionDetail(section); !ok {
+		return false
+	}
+
+	b.drillMux.Lock()
+	b.drilled = section
+	b.drillMux.Unlock()
+	b.refreshUI()
+	return true
+}
+
+// Undrill stops updating the detail of the section drilled down into.
+func (b *Banken) Undrill() {
+	b.drillMux.Lock()
+	b.drilled = ""
+	b.drillMux.Unlock()
+}
+
+// drilledSection is the section drilled down into, empty when none is.
+func (b *Banken) drilledSection() string {
+	b.drillMux.Lock()
+	defer b.drillMux.Unlock()
+	return b.drilled
+}
+
+// sectionDetail provides the detail of the requests to section, charted over
+// the detailView.
+func (b *Banken) sectionDetail(section string) (traffic.SectionDetail, bool) {
+	return b.sections.Detail(section, detailView.span, detailView.num)
+}
+
+// renderDetail updates the detail pane with the section drilled down into.
+func (b *Banken) renderDetail(detail *view.Detail) {
+	section := b.drilledSection()
+	if section == "" {
+		return
+	}
+	d, ok := b.sectionDetail(section)
+	if !ok {
+		// Pruned while idle; nothing was requested within the last hour.
+		d = traffic.SectionDetail{}
+	}
+
+	paths := make([]string, 0)
+	for i, v := range topNRequests(d.Paths, len(d.Paths)) {
+		paths = append(paths, fmt.Sprintf("[%d]: %s -> %d", i+1, v.URL, v.C))
+	}
+	detail.Paths.Title = fmt.Sprintf("Paths under %s -- press 'esc' to go back", section)
+	detail.Paths.Rows = paths
+
+	methods := make([]string, 0)
+	for _, v := range topNRequests(d.Methods, len(d.Methods)) {
+		methods = append(methods, fmt.Sprintf("%s: %d", v.URL, v.C))
+	}
+	detail.Methods.Rows = methods
+
+	clients := make([]string, 0)
+	for i, v := range topNRequests(d.Clients, len(d.Clients)) {
+		clients = append(clients, fmt.Sprintf("[%d]: %s -> %d", i+1, v.URL, v.C))
+	}
+	detail.Clients.Rows = clients
+
+	detail.Chart.Title = fmt.Sprintf("Requests to %s per %s, last %s", section, detailView.span, detailView.s)
+	plot(detail.Chart, chartPoints(d.Requests, detail.Chart.Inner.Dx()-5))
+	view.Render(detail.Paths, detail.Methods, detail.Clients, detail.Chart)
+}
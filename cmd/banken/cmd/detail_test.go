@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gizak/termui/v3/widgets"
+	"github.com/ropes/banken/pkg/view"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDrill(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	if _, _, err := b.Init(nil, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	b.sections.Observe("http://rusutsu.com/ski", "/ski/kona/lift", "GET", "10.0.0.1", now)
+	b.sections.Observe("http://rusutsu.com/ski", "/ski/kona/lift", "GET", "10.0.0.2", now)
+	b.sections.Observe("http://rusutsu.com/ski", "/ski/yuki", "POST", "10.0.0.1", now)
+	b.show([]ReqCount{
+		{URL: "http://rusutsu.com/ski", C: 3},
+		{URL: "https://rusutsu.com/", C: 2},
+	})
+
+	// HTTPS paths are encrypted, so their sections have no detail.
+	for _, row := range []int{-1, 1, 2} {
+		if b.Drill(row) {
+			t.Errorf("drilled down into row %d", row)
+		}
+	}
+	if !b.Drill(0) {
+		t.Fatal("unable to drill down into the ski section")
+	}
+
+	detail := &view.Detail{
+		Paths:   widgets.NewList(),
+		Methods: widgets.NewList(),
+		Clients: widgets.NewList(),
+		Chart:   widgets.NewPlot(),
+	}
+	b.renderDetail(detail)
+	if exp := []string{"[1]: /ski/kona/lift -> 2", "[2]: /ski/yuki -> 1"}; !reflect.DeepEqual(detail.Paths.Rows, exp) {
+		t.Errorf("detailed paths %v != %v", detail.Paths.Rows, exp)
+	}
+	if exp := []string{"GET: 2", "POST: 1"}; !reflect.DeepEqual(detail.Methods.Rows, exp) {
+		t.Errorf("detailed methods %v != %v", detail.Methods.Rows, exp)
+	}
+	if exp := []string{"[1]: 10.0.0.1 -> 2", "[2]: 10.0.0.2 -> 1"}; !reflect.DeepEqual(detail.Clients.Rows, exp) {
+		t.Errorf("detailed clients %v != %v", detail.Clients.Rows, exp)
+	}
+	if data := detail.Chart.Data; len(data) != 1 || data[0][len(data[0])-1] != 3 {
+		t.Errorf("charted requests %v, expected the latest minute's 3", data)
+	}
+
+	// The detail is no longer updated once undrilled.
+	b.Undrill()
+	b.sections.Observe("http://rusutsu.com/ski", "/ski/yuki", "POST", "10.0.0.1", now)
+	b.renderDetail(detail)
+	if exp := []string{"GET: 2", "POST: 1"}; !reflect.DeepEqual(detail.Methods.Rows, exp) {
+		t.Errorf("undrilled methods updated to %v", detail.Methods.Rows)
+	}
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic"
+)
+
+// requestFilter selects the HTTP requests displayed, parsed from the terms
+// typed at the UI's '/' prompt, eg: 'rusutsu.com ~^/ski/ method=GET'.
+// Requests match when their host and path, eg: 'rusutsu.com/ski/kona',
+// contains every plain term and matches every '~' prefixed regular
+// expression, and when their method is any of the comma separated methods.
+type requestFilter struct {
+	s        string
+	contains []string
+	patterns []*regexp.Regexp
+	methods  map[string]bool
+}
+
+// parseRequestFilter parses the terms of a requestFilter.
+func parseRequestFilter(s string) (*requestFilter, error) {
+	f := &requestFilter{s: strings.TrimSpace(s)}
+	for _, term := range strings.Fields(s) {
+		switch {
+		case strings.HasPrefix(strings.ToLower(term), "method="):
+			for _, m := range strings.Split(term[len("method="):], ",") {
+				if m == "" {
+					continue
+				}
+				if f.methods == nil {
+					f.methods = make(map[string]bool)
+				}
+				f.methods[strings.ToUpper(m)] = true
+			}
+			if f.methods == nil {
+				return nil, fmt.Errorf("filter %q has no method", term)
+			}
+		case strings.HasPrefix(term, "~"):
+			re, err := regexp.Compile(term[1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter pattern %q: %w", term[1:], err)
+			}
+			f.patterns = append(f.patterns, re)
+		default:
+			f.contains = append(f.contains, term)
+		}
+	}
+	return f, nil
+}
+
+// match reports whether the request of path on host by method is selected.
+// HTTPS connections have no known path nor method, so they only match
+// filters without a method.
+func (f *requestFilter) match(host, path, method string) bool {
+	if f.methods != nil && !f.methods[method] {
+		return false
+	}
+	target := host + path
+	for _, c := range f.contains {
+		if !strings.Contains(target, c) {
+			return false
+		}
+	}
+	for _, re := range f.patterns {
+		if !re.MatchString(target) {
+			return false
+		}
+	}
+	return true
+}
+
+// String is the filter as typed.
+func (f *requestFilter) String() string {
+	return f.s
+}
+
+// filteredTraffic records the requests matching a filter from when it was
+// applied; the traffic recorded before is only retained in aggregate.
+type filteredTraffic struct {
+	filter *requestFilter
+	since  time.Time
+
+	monitor *traffic.Monitor
+	rc      traffic.Counter
+	recent  *traffic.WindowCounter
+}
+
+// Filter narrows the top URLs, request counts and chart displayed to the
+// requests matching the filter terms s, see requestFilter, from now on. An
+// empty s clears the filter, displaying all traffic again. The report and
+// metrics are not filtered.
+func (b *Banken) Filter(s string) error {
+	if strings.TrimSpace(s) == "" {
+		b.filterMux.Lock()
+		b.filtered = nil
+		b.filterMux.Unlock()
+		b.refreshUI()
+		return nil
+	}
+	f, err := parseRequestFilter(s)
+	if err != nil {
+		return err
+	}
+	ft := &filteredTraffic{
+		filter:  f,
+		since:   b.clock.Time(),
+		monitor: traffic.NewMonitorWithClock(b.clock),
+		rc:      b.newCounter(),
+		recent:  traffic.NewWindowCounter(b.clock, recentKeys),
+	}
+	b.filterMux.Lock()
+	b.filtered = ft
+	b.filterMux.Unlock()
+	b.logger.WithField("filter", f.String()).Info("display filtered")
+	b.refreshUI()
+	return nil
+}
+
+// filtering provides the traffic recorded for the filter applied, nil when
+// there is none.
+func (b *Banken) filtering() *filteredTraffic {
+	b.filterMux.RLock()
+	defer b.filterMux.RUnlock()
+	return b.filtered
+}
+
+// recordFiltered records the request of path on host by method, counted as
+// section u, when it matches the filter applied.
+func (b *Banken) recordFiltered(host, path, method, u string, ts time.Time) {
+	ft := b.filtering()
+	if ft == nil || !ft.filter.match(host, path, method) {
+		return
+	}
+	ft.monitor.Increment(1, ts)
+	ft.rc.IncKey(u, 1)
+	ft.recent.IncKey(u, 1, ts)
+}
+
+// displayedRequests ranks the n most requested URLs displayed, over the top
+// window, of the requests matching the filter applied.
+func (b *Banken) displayedRequests(n int) []ReqCount {
+	ft := b.filtering()
+	if ft == nil {
+		return b.topRequests(n)
+	}
+	if w := b.currentTopWindow(); w > 0 {
+		return topNRequests(ft.recent.Recent(w), n)
+	}
+	return topNRequests(ft.rc.Export(), n)
+}
+
+// displayedSpanCount counts the requests displayed within [start, end].
+func (b *Banken) displayedSpanCount(start, end time.Time) int {
+	if ft := b.filtering(); ft != nil {
+		return ft.monitor.RangeSum(start, end)
+	}
+	return b.ad.GetSpanCount(start, end)
+}
+
+// displayedSpanCounts counts the requests displayed in num spans ending now,
+// oldest first.
+func (b *Banken) displayedSpanCounts(span time.Duration, num int) []float64 {
+	if ft := b.filtering(); ft != nil {
+		return ft.monitor.RecentSums(span, num)
+	}
+	return b.ad.GetSpanCounts(span, num)
+}
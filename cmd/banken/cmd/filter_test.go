@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ropes/banken/pkg/sniff"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRequestFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		host   string
+		path   string
+		method string
+		match  bool
+	}{
+		{"rusutsu", "rusutsu.com", "/ski/kona", "GET", true},
+		{"rusutsu", "niseko.com", "/ski/kona", "GET", false},
+		{"rusutsu.com/ski", "rusutsu.com", "/ski/kona", "GET", true},
+		{"rusutsu.com/ski", "rusutsu.com", "/lift/ski", "GET", false},
+		{"rusutsu kona", "rusutsu.com", "/ski/kona", "GET", true},
+		{"rusutsu yuki", "rusutsu.com", "/ski/kona", "GET", false},
+		{`~^rusutsu\.com/ski/[a-z]+$`, "rusutsu.com", "/ski/kona", "GET", true},
+		{`~^rusutsu\.com/ski/[a-z]+$`, "rusutsu.com", "/ski/kona/2", "GET", false},
+		{"method=get", "rusutsu.com", "/ski/kona", "GET", true},
+		{"method=POST,HEAD", "rusutsu.com", "/ski/kona", "GET", false},
+		{"method=POST,HEAD", "rusutsu.com", "/ski/kona", "HEAD", true},
+		{"rusutsu method=GET", "niseko.com", "/ski/kona", "GET", false},
+		// HTTPS connections have no method.
+		{"method=GET", "rusutsu.com", "/", "", false},
+		{"rusutsu", "rusutsu.com", "/", "", true},
+	} {
+		f, err := parseRequestFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.filter, err)
+		}
+		if m := f.match(tc.host, tc.path, tc.method); m != tc.match {
+			t.Errorf("%q matched %s %s%s: %t", tc.filter, tc.method, tc.host, tc.path, m)
+		}
+	}
+
+	for _, invalid := range []string{"~ski(", "method=", "rusutsu method=,"} {
+		if _, err := parseRequestFilter(invalid); err == nil {
+			t.Errorf("invalid filter %q parsed", invalid)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	if err := b.TopWindow(0); err != nil {
+		t.Fatal(err)
+	}
+	_, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Filter("~ski("); err == nil {
+		t.Error("invalid filter applied")
+	}
+	if err := b.Filter("rusutsu.com method=GET"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	packets <- sniff.HTTPXPacket{TS: now, Host: "rusutsu.com", Path: "/ski/kona", Method: "GET"}
+	packets <- sniff.HTTPXPacket{TS: now, Host: "rusutsu.com", Path: "/ski/kona", Method: "GET"}
+	packets <- sniff.HTTPXPacket{TS: now, Host: "rusutsu.com", Path: "/lift/pass", Method: "POST"}
+	packets <- sniff.HTTPXPacket{TS: now, Host: "niseko.com", Path: "/ski/hirafu", Method: "GET"}
+	close(packets)
+	b.consumers.Wait()
+	b.ad.Flush()
+
+	exp := []ReqCount{{URL: "http://rusutsu.com/ski", C: 2}}
+	if top := b.displayedRequests(10); !reflect.DeepEqual(top, exp) {
+		t.Errorf("filtered top requests %v != %v", top, exp)
+	}
+	if c := b.displayedSpanCount(now.Add(-time.Minute), now.Add(time.Second)); c != 2 {
+		t.Errorf("filtered %d requests in the last minute, expected 2", c)
+	}
+	if counts := b.displayedSpanCounts(10*time.Second, 6); counts[len(counts)-1] != 2 {
+		t.Errorf("filtered chart counts %v, expected 2 latest", counts)
+	}
+	// Reports are not filtered.
+	if top := b.topRequests(10); len(top) != 3 {
+		t.Errorf("unfiltered top requests %v, expected all 3 sections", top)
+	}
+
+	if err := b.Filter(""); err != nil {
+		t.Fatal(err)
+	}
+	if c := b.displayedSpanCount(now.Add(-time.Minute), now.Add(time.Second)); c != 4 {
+		t.Errorf("displayed %d requests once unfiltered, expected 4", c)
+	}
+}
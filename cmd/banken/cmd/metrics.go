@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ropes/banken/pkg/sniff"
+	"github.com/ropes/banken/pkg/traffic"
+)
+
+// ServeMetrics exposes the traffic models and capture health counters in
+// the Prometheus text exposition format on the /metrics path of the
+// listener. The listener is closed when Banken's context is done. Must be
+// called after Init.
+func (b *Banken) ServeMetrics(l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", b.metricsHandler)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-b.ctx.Done()
+		ctx, can := context.WithTimeout(context.Background(), time.Second)
+		defer can()
+		srv.Shutdown(ctx)
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			b.logger.Errorf("metrics listener on %q failed: %v", l.Addr(), err)
+		}
+	}()
+	b.logger.Infof("Serving metrics on http://%s/metrics", l.Addr())
+}
+
+func (b *Banken) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b.writeMetrics(w)
+}
+
+// writeMetrics formats every metric family to w.
+func (b *Banken) writeMetrics(w io.Writer) {
+	mw := &metricWriter{w: w}
+
+	// Sections and hosts are counted by a traffic.TopK, which forgets the
+	// least counted keys beyond its capacity, so their counts may drop and
+	// are exported as gauges rather than counters.
+	mw.family("banken_http_requests", "gauge", "HTTP requests counted per URL section, estimated beyond --count-capacity sections.")
+	mw.counts("section", b.rc.Export())
+	mw.family("banken_http_host_requests", "gauge", "HTTP requests counted per host, estimated beyond --count-capacity hosts.")
+	mw.counts("host", b.hosts.Export())
+	mw.family("banken_http_method_requests_total", "counter", "HTTP requests counted per method.")
+	mw.counts("method", b.methods.Export())
+	mw.family("banken_tls_connections_total", "counter", "TLS connections counted per highest version offered by the client.")
+	mw.counts("version", b.tls.Export())
+
+	mw.family("banken_remote_host_bytes_total", "counter", "TCP payload bytes per remote host and direction.")
+	mw.bandwidth("host", b.remoteBytes.Total())
+	mw.family("banken_server_port_bytes_total", "counter", "TCP payload bytes per server port and direction.")
+	mw.bandwidth("port", b.portBytes.Total())
+	mw.family("banken_http_section_bytes_total", "counter", "HTTP body bytes per URL section, sent requests and received responses.")
+	mw.bandwidth("section", b.sectionBytes.Total())
+
+	mw.family("banken_http_responses", "gauge", "HTTP responses captured per URL section, estimated beyond --count-capacity sections.")
+	mw.counts("section", b.responses.Export())
+	mw.family("banken_http_errors", "gauge", "HTTP 4xx and 5xx responses per URL section, estimated beyond --count-capacity sections.")
+	mw.counts("section", b.errs.Export())
+	mw.family("banken_http_response_latency_seconds", "summary", "HTTP response latency per URL section, quantiles over the trailing 5m.")
+	recent, total := b.latency.Recent(latencyWindow), b.latency.Total()
+	sections := make([]string, 0, len(total))
+	for k := range total {
+		sections = append(sections, k)
+	}
+	sort.Strings(sections)
+	for _, k := range sections {
+		if l, ok := recent[k]; ok {
+			for _, q := range []struct {
+				q string
+				v time.Duration
+			}{{"0.5", l.P50}, {"0.9", l.P90}, {"0.99", l.P99}} {
+				mw.sample(fmt.Sprintf(`{section="%s",quantile="%s"}`, labelEscaper.Replace(k), q.q), q.v.Seconds())
+			}
+		}
+		mw.suffixed("_sum", labels("section", k), total[k].Sum.Seconds())
+		mw.suffixed("_count", labels("section", k), float64(total[k].Count))
+	}
+
+	mw.family("banken_http_requests_span", "gauge", "HTTP requests seen over the trailing timespan.")
+	now := b.clock.Time()
+	for _, i := range b.intervals {
+		mw.sample(labels("span", i.s), float64(b.ad.GetSpanCount(now.Add(-i.t), now)))
+	}
+
+	b.settingsMux.RLock()
+	rules := b.rules
+	b.settingsMux.RUnlock()
+	b.historyMux.Lock()
+	mw.family("banken_alert_state", "gauge", "Request rate alert state, 1 when alerted and 0 when nominal.")
+	mw.sample("", alertValue(b.status[""]))
+	if len(rules) > 0 {
+		mw.family("banken_alert_rule_state", "gauge", "Alert state per configured rule, 1 when alerted and 0 when nominal.")
+		for _, r := range rules {
+			mw.sample(labels("rule", r.Name), alertValue(b.status[r.Name]))
+		}
+	}
+	b.historyMux.Unlock()
+
+	stats := sniff.ReadStats()
+	mw.family("banken_tcp_streams_total", "counter", "TCP streams created by the packet assemblers.")
+	mw.sample("", float64(stats.Streams))
+	mw.family("banken_http_parse_errors_total", "counter", "Reassembled TCP data which failed to parse as HTTP.")
+	mw.sample("", float64(stats.ParseErrors))
+
+	sources := make([]string, 0, len(stats.Sources))
+	for s := range stats.Sources {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+	mw.family("banken_packets_captured_total", "counter", "Packets read per interface or capture file.")
+	for _, s := range sources {
+		mw.sample(labels("source", s), float64(stats.Sources[s].Packets))
+	}
+	mw.family("banken_packets_dropped_total", "counter", "Packets dropped by libpcap per interface.")
+	for _, s := range sources {
+		mw.sample(labels("source", s), float64(stats.Sources[s].Dropped))
+	}
+	mw.family("banken_packets_if_dropped_total", "counter", "Packets dropped by the network interface.")
+	for _, s := range sources {
+		mw.sample(labels("source", s), float64(stats.Sources[s].IfDropped))
+	}
+}
+
+// metricWriter writes metric families in the Prometheus text format,
+// sampling under the most recently declared family name.
+type metricWriter struct {
+	w    io.Writer
+	name string
+}
+
+func (m *metricWriter) family(name, kind, help string) {
+	m.name = name
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (m *metricWriter) sample(labels string, v float64) {
+	m.suffixed("", labels, v)
+}
+
+// counts samples each key of the map under the label, in key order.
+func (m *metricWriter) counts(label string, counts map[string]uint64) {
+	for _, k := range sortedKeys(counts) {
+		m.sample(labels(label, k), float64(counts[k]))
+	}
+}
+
+// bandwidth samples the sent and received bytes of each key of the map under
+// the label, in key order.
+func (m *metricWriter) bandwidth(label string, bytes map[string]traffic.Bandwidth) {
+	keys := make([]string, 0, len(bytes))
+	for k := range bytes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		l := fmt.Sprintf(`{%s="%s",direction=`, label, labelEscaper.Replace(k))
+		m.sample(l+`"sent"}`, float64(bytes[k].Sent))
+		m.sample(l+`"received"}`, float64(bytes[k].Received))
+	}
+}
+
+// suffixed samples a series of the family with a suffixed name, eg: the
+// _sum and _count of a summary.
+func (m *metricWriter) suffixed(suffix, labels string, v float64) {
+	fmt.Fprintf(m.w, "%s%s%s %s\n", m.name, suffix, labels, strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats a single escaped label pair.
+func labels(name, value string) string {
+	return fmt.Sprintf(`{%s="%s"}`, name, labelEscaper.Replace(value))
+}
+
+// alertValue is 1 when the notification is an alert.
+func alertValue(n traffic.Notification) float64 {
+	if n != nil && n.State() == traffic.StateAlert {
+		return 1
+	}
+	return 0
+}
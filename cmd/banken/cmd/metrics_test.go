@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ropes/banken/pkg/sniff"
+	"github.com/ropes/banken/pkg/traffic"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "", l)
+	rule, err := traffic.ParseRule("name=ski,scope=section,key=http://rusutsu.com/ski,threshold=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AlertRules([]traffic.Rule{rule}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AlertRules([]traffic.Rule{rule, rule}); err == nil {
+		t.Error("duplicate rule names should be rejected")
+	}
+	_, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		packets <- sniff.HTTPXPacket{TS: time.Now(), Host: "rusutsu.com", Path: "/ski/kona", Method: "GET", StatusCode: 200, ResponseSize: 10}
+	}
+	packets <- sniff.HTTPXPacket{TS: time.Now(), Host: `inu"\`, Path: "/", Method: "POST"}
+	close(packets)
+	b.consumers.Wait()
+	b.ad.Flush()
+
+	rec := httptest.NewRecorder()
+	b.metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, exp := range []string{
+		"# TYPE banken_http_requests gauge\n",
+		`banken_http_requests{section="http://rusutsu.com/ski"} 3` + "\n",
+		`banken_http_host_requests{host="inu\"\\"} 1` + "\n",
+		"# TYPE banken_http_responses gauge\n",
+		`banken_http_method_requests_total{method="GET"} 3` + "\n",
+		`banken_http_requests_span{span="1m"} 4` + "\n",
+		`banken_http_section_bytes_total{section="http://rusutsu.com/ski",direction="received"} 30` + "\n",
+		"# TYPE banken_remote_host_bytes_total counter\n",
+		"banken_alert_state 0\n",
+		`banken_alert_rule_state{rule="ski"} 0` + "\n",
+		"# TYPE banken_tcp_streams_total counter\n",
+		"# TYPE banken_packets_dropped_total counter\n",
+	} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("metrics missing %q:\n%s", exp, body)
+		}
+	}
+}
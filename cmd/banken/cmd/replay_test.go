@@ -0,0 +1,439 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/ropes/banken/pkg/notify"
+	"github.com/ropes/banken/pkg/traffic"
+	log "github.com/sirupsen/logrus"
+)
+
+// pcapWriter writes packets in the classic libpcap file format, so replay
+// tests can synthesize captures without root or a live network.
+type pcapWriter struct {
+	t   *testing.T
+	f   *os.File
+	buf gopacket.SerializeBuffer
+}
+
+func newPcapWriter(t *testing.T, path string) *pcapWriter {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(layers.LinkTypeEthernet))
+	if _, err := f.Write(hdr); err != nil {
+		t.Fatal(err)
+	}
+	return &pcapWriter{t: t, f: f, buf: gopacket.NewSerializeBuffer()}
+}
+
+// writeTCP serializes a single ethernet/IPv4/TCP segment captured at ts.
+func (w *pcapWriter) writeTCP(ts time.Time, src, dst net.IP, sport, dport uint16, seq uint32, syn, fin bool, payload []byte) {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    src,
+		DstIP:    dst,
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(sport),
+		DstPort: layers.TCPPort(dport),
+		Seq:     seq,
+		SYN:     syn,
+		FIN:     fin,
+		ACK:     !syn,
+		Window:  65535,
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(w.buf, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+		w.t.Fatal(err)
+	}
+	data := w.buf.Bytes()
+
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(data)))
+	if _, err := w.f.Write(append(rec, data...)); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// writeRequest writes a complete client connection carrying one request.
+func (w *pcapWriter) writeRequest(ts time.Time, sport uint16, host, path string) {
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	req := []byte(fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", path, host))
+	w.writeTCP(ts, client, server, sport, 80, 100, true, false, nil)
+	w.writeTCP(ts.Add(time.Millisecond), client, server, sport, 80, 101, false, false, req)
+	w.writeTCP(ts.Add(2*time.Millisecond), client, server, sport, 80, 101+uint32(len(req)), false, true, nil)
+}
+
+// writeExchange writes a connection where the client pipelines the raw
+// requests, and the server answers with the raw responses after latency.
+func (w *pcapWriter) writeExchange(ts time.Time, sport uint16, latency time.Duration, reqs, resps []string) {
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	cseq, sseq := uint32(100), uint32(5000)
+	w.writeTCP(ts, client, server, sport, 80, cseq, true, false, nil)
+	w.writeTCP(ts, server, client, 80, sport, sseq, true, false, nil)
+	cseq, sseq = cseq+1, sseq+1
+	for _, r := range reqs {
+		w.writeTCP(ts, client, server, sport, 80, cseq, false, false, []byte(r))
+		cseq += uint32(len(r))
+	}
+	ts = ts.Add(latency)
+	for _, r := range resps {
+		w.writeTCP(ts, server, client, 80, sport, sseq, false, false, []byte(r))
+		sseq += uint32(len(r))
+	}
+	w.writeTCP(ts, server, client, 80, sport, sseq, false, true, nil)
+	w.writeTCP(ts, client, server, sport, 80, cseq, false, true, nil)
+}
+
+// writeTLS writes a client connection to port 443 opening with the raw
+// handshake records.
+func (w *pcapWriter) writeTLS(ts time.Time, sport uint16, records []byte) {
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 3}
+	w.writeTCP(ts, client, server, sport, 443, 100, true, false, nil)
+	w.writeTCP(ts.Add(time.Millisecond), client, server, sport, 443, 101, false, false, records)
+	w.writeTCP(ts.Add(2*time.Millisecond), client, server, sport, 443, 101+uint32(len(records)), false, true, nil)
+}
+
+// clientHello returns the ClientHello record crypto/tls sends for config.
+func clientHello(t *testing.T, config *tls.Config) []byte {
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, config).Handshake()
+		c.Close()
+	}()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(s, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	if _, err := io.ReadFull(s, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, body...)
+}
+
+func (w *pcapWriter) Close() {
+	if err := w.f.Close(); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// recordingNotifier retains the events it is notified of.
+type recordingNotifier struct {
+	mux    sync.Mutex
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, e notify.Event) error {
+	r.mux.Lock()
+	r.events = append(r.events, e)
+	r.mux.Unlock()
+	return nil
+}
+
+func (r *recordingNotifier) get() []notify.Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]notify.Event(nil), r.events...)
+}
+
+func (r *recordingNotifier) String() string { return "recorder" }
+
+func TestReplayFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banken-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Capture 100 requests spread across 100 seconds of packet time.
+	const reqs = 100
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "requests.pcap")
+	w := newPcapWriter(t, path)
+	for i := 0; i < reqs; i++ {
+		w.writeRequest(start.Add(time.Duration(i)*time.Second), uint16(40000+i), "rusutsu.com", "/ski/kona/yuki.jpg")
+	}
+	w.Close()
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	b.ReplayFile(path, 0)
+	threshold := traffic.Rule{
+		Metric: traffic.MetricRequests, Scope: traffic.ScopeTotal, Window: time.Minute, Threshold: 10,
+		Recover: 20, Interval: 5 * time.Second, Flush: time.Second, Dwell: 10 * time.Second,
+	}
+	if err := b.AlertThreshold(threshold); err == nil {
+		t.Error("recovering above the alert threshold should be rejected")
+	}
+	threshold.Recover = 5
+	if err := b.AlertThreshold(threshold); err != nil {
+		t.Fatal(err)
+	}
+	err = b.AlertRules([]traffic.Rule{
+		{Name: "ski", Metric: traffic.MetricRequests, Scope: traffic.ScopeSection, Key: "http://rusutsu.com/ski", Window: time.Minute, Threshold: 50},
+		{Name: "lift", Metric: traffic.MetricRequests, Scope: traffic.ScopeSection, Key: "http://rusutsu.com/lift", Window: time.Minute, Threshold: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &recordingNotifier{}
+	b.Notifiers(notify.Options{}, events)
+
+	ifaces, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifaces) != 0 {
+		t.Errorf("replay should not listen on interfaces: %v", ifaces)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if err := b.Run(ifaces, packets); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("replay did not complete")
+	}
+
+	if c := b.countMap()["http://rusutsu.com/ski"]; c != reqs {
+		t.Errorf("route count %d != %d", c, reqs)
+	}
+	end := start.Add(reqs * time.Second)
+	if c := b.tsReqSpanCount(start.Add(-time.Minute), end.Add(time.Minute)); c != reqs {
+		t.Errorf("timeseries span count %d != %d", c, reqs)
+	}
+	if now := b.clock.Time(); now.Before(end.Add(-2*time.Second)) || now.After(end) {
+		t.Errorf("clock was not driven by packet timestamps: %v", now)
+	}
+	if status := b.getAlertState(); reflect.TypeOf(status) != reflect.TypeOf(traffic.Alert{}) {
+		t.Errorf("status is not alerted after replay: %v", status)
+	}
+	if r := b.ad.Rule(); r.Window != time.Minute || r.Interval != 5*time.Second || r.Recover != 5 || r.Dwell != 10*time.Second {
+		t.Errorf("alert timing was not applied: %+v", r)
+	}
+	if status := b.detectors[0].GetState(); reflect.TypeOf(status) != reflect.TypeOf(traffic.Alert{}) {
+		t.Errorf("section rule is not alerted after replay: %v", status)
+	}
+	if status := b.detectors[1].GetState(); reflect.TypeOf(status) != reflect.TypeOf(traffic.NominalStatus{}) {
+		t.Errorf("rule of another section alerted: %v", status)
+	}
+
+	// Notifications are recorded asynchronously from the alert detectors.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.Report().Alerts) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.Close()
+	alerted := make(map[string]string)
+	for _, e := range events.get() {
+		alerted[e.Rule] = e.State
+		if e.Rule == "ski" && (e.Key != "http://rusutsu.com/ski" || e.Metric != "requests" || e.Threshold != 50 || e.Value <= 50) {
+			t.Errorf("unexpected ski event details: %+v", e)
+		}
+	}
+	if exp := map[string]string{"": notify.StateAlert, "ski": notify.StateAlert}; !reflect.DeepEqual(alerted, exp) {
+		t.Errorf("notified states %v != %v", alerted, exp)
+	}
+
+	r := b.Report()
+	if !reflect.DeepEqual(r.Hosts, []ReqCount{{URL: "rusutsu.com", C: reqs}}) {
+		t.Errorf("unexpected host totals: %v", r.Hosts)
+	}
+	if !reflect.DeepEqual(r.Methods, []ReqCount{{URL: "GET", C: reqs}}) {
+		t.Errorf("unexpected method totals: %v", r.Methods)
+	}
+	if r.Intervals[len(r.Intervals)-1].C != reqs {
+		t.Errorf("24hr interval count %d != %d", r.Intervals[len(r.Intervals)-1].C, reqs)
+	}
+}
+
+func TestReplayResponses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banken-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "exchanges.pcap")
+	w := newPcapWriter(t, path)
+	// Pipelined requests answered in order, the HEAD response has no body.
+	reqs := []string{
+		"GET /ski/kona HTTP/1.1\r\nHost: rusutsu.com\r\n\r\n",
+		"HEAD /ski/yuki HTTP/1.1\r\nHost: rusutsu.com\r\n\r\n",
+		"POST /lift/pass HTTP/1.1\r\nHost: rusutsu.com\r\nContent-Length: 4\r\n\r\nhihi",
+	}
+	resps := []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+		"HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n",
+	}
+	w.writeExchange(start, 40000, 20*time.Millisecond, reqs, resps)
+	// A request whose response was never captured.
+	w.writeRequest(start.Add(time.Second), 40001, "rusutsu.com", "/lift/gondola")
+	w.Close()
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	b.ReplayFile(path, 0)
+	ifaces, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(ifaces, packets); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]uint64{"http://rusutsu.com/ski": 2, "http://rusutsu.com/lift": 2}
+	if m := b.countMap(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("request counts %v != %v", m, exp)
+	}
+	exp = map[string]uint64{"http://rusutsu.com/ski": 2, "http://rusutsu.com/lift": 1}
+	if m := b.responses.Export(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("response counts %v != %v", m, exp)
+	}
+	exp = map[string]uint64{"http://rusutsu.com/lift": 1}
+	if m := b.errs.Export(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("error counts %v != %v", m, exp)
+	}
+	lat := b.latency.Total()["http://rusutsu.com/ski"]
+	if lat.Count != 2 || lat.Sum != 40*time.Millisecond || lat.Max != 20*time.Millisecond {
+		t.Errorf("unexpected latency summary: %+v", lat)
+	}
+
+	// Flows are accounted from the side which opened the connection.
+	var sent, received int64
+	for _, r := range reqs {
+		sent += int64(len(r))
+	}
+	for _, r := range resps {
+		received += int64(len(r))
+	}
+	gondola := int64(len("GET /lift/gondola HTTP/1.1\r\nHost: rusutsu.com\r\n\r\n"))
+	expBytes := map[string]traffic.Bandwidth{"10.0.0.2": {Sent: sent + gondola, Received: received}}
+	if m := b.remoteBytes.Total(); !reflect.DeepEqual(m, expBytes) {
+		t.Errorf("remote host bytes %v != %v", m, expBytes)
+	}
+	// Ports are keyed by the server's, not the clients' ephemeral ports.
+	expBytes = map[string]traffic.Bandwidth{"80": {Sent: sent + gondola, Received: received}}
+	if m := b.portBytes.Total(); !reflect.DeepEqual(m, expBytes) {
+		t.Errorf("server port bytes %v != %v", m, expBytes)
+	}
+	expBytes = map[string]traffic.Bandwidth{"http://rusutsu.com/ski": {Received: 5}, "http://rusutsu.com/lift": {Sent: 4}}
+	if m := b.sectionBytes.Total(); !reflect.DeepEqual(m, expBytes) {
+		t.Errorf("section bytes %v != %v", m, expBytes)
+	}
+	d, ok := b.sectionDetail("http://rusutsu.com/lift")
+	if !ok {
+		t.Fatal("lift section has no detail")
+	}
+	exp = map[string]uint64{"/lift/pass": 1, "/lift/gondola": 1}
+	if !reflect.DeepEqual(d.Paths, exp) {
+		t.Errorf("lift paths %v != %v", d.Paths, exp)
+	}
+	exp = map[string]uint64{"POST": 1, "GET": 1}
+	if !reflect.DeepEqual(d.Methods, exp) {
+		t.Errorf("lift methods %v != %v", d.Methods, exp)
+	}
+	exp = map[string]uint64{"10.0.0.1": 2}
+	if !reflect.DeepEqual(d.Clients, exp) {
+		t.Errorf("lift clients %v != %v", d.Clients, exp)
+	}
+	if exp := []ByteCount{{Key: "10.0.0.2", Sent: sent + gondola, Received: received}}; !reflect.DeepEqual(b.Report().Talkers.Hosts, exp) {
+		t.Errorf("top talkers %v != %v", b.Report().Talkers.Hosts, exp)
+	}
+}
+
+func TestReplayTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banken-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "tls.pcap")
+	w := newPcapWriter(t, path)
+	hello := clientHello(t, &tls.Config{ServerName: "rusutsu.com", NextProtos: []string{"h2", "http/1.1"}})
+	w.writeTLS(start, 40000, hello)
+	w.writeTLS(start.Add(time.Second), 40001, hello)
+	// Without SNI the connection is counted under the server's address.
+	w.writeTLS(start.Add(2*time.Second), 40002, clientHello(t, &tls.Config{InsecureSkipVerify: true}))
+	w.writeRequest(start.Add(3*time.Second), 40003, "rusutsu.com", "/ski/kona")
+	w.Close()
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80 or tcp port 443", l)
+	b.ReplayFile(path, 0)
+	ifaces, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(ifaces, packets); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]uint64{"https://rusutsu.com/": 2, "https://10.0.0.3/": 1, "http://rusutsu.com/ski": 1}
+	if m := b.countMap(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("request counts %v != %v", m, exp)
+	}
+	exp = map[string]uint64{"rusutsu.com": 3, "10.0.0.3": 1}
+	if m := b.hosts.Export(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("host counts %v != %v", m, exp)
+	}
+	if r := b.Report(); !reflect.DeepEqual(r.TLS, []ReqCount{{URL: "TLS 1.3", C: 3}}) {
+		t.Errorf("unexpected TLS versions: %v", r.TLS)
+	}
+	// HTTPS connections are not HTTP requests.
+	if c := b.tsReqSpanCount(start, start.Add(time.Minute)); c != 1 {
+		t.Errorf("request span count %d != 1", c)
+	}
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Report output formats supported by Report.Write.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Report summarises the traffic observed by Banken for batch consumption.
+type Report struct {
+	End       time.Time       `json:"end"`
+	TopWindow string          `json:"top_window"`
+	Top       []ReqCount      `json:"top"`
+	TopError  uint64          `json:"top_error,omitempty"` // most the Top counts overestimate by
+	Intervals []IntervalCount `json:"intervals"`
+	Hosts     []ReqCount      `json:"hosts"`
+	Methods   []ReqCount      `json:"methods"`
+	TLS       []ReqCount      `json:"tls_versions"`
+	Talkers   Talkers         `json:"talkers"`
+	Latency   []URLLatency    `json:"latency"`
+	Alerts    []string        `json:"alerts"`
+}
+
+// Talkers ranks the top N remote hosts, server ports and sections by the
+// bytes they transferred.
+type Talkers struct {
+	Hosts    []ByteCount `json:"hosts"`
+	Ports    []ByteCount `json:"ports"`
+	Sections []ByteCount `json:"sections"`
+}
+
+// URLLatency holds the response latency percentiles of a top URL over each
+// reported timespan.
+type URLLatency struct {
+	URL   string        `json:"url"`
+	Spans []SpanLatency `json:"spans"`
+}
+
+// SpanLatency summarises response latencies in milliseconds.
+type SpanLatency struct {
+	Span  string  `json:"span"`
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// IntervalCount is the number of requests seen in the Span preceding the
+// report's end.
+type IntervalCount struct {
+	Span string `json:"span"`
+	C    int    `json:"count"`
+}
+
+// Report compiles a summary of the current state of the traffic models.
+// The per-interval counts are relative to the latest time of Banken's
+// clock, which is the last packet seen when replaying a capture.
+func (b *Banken) Report() Report {
+	b.flushDetectors()
+	end := b.clock.Time()
+	n := b.currentTopN()
+	r := Report{
+		End:       end,
+		TopWindow: windowLabel(b.currentTopWindow()),
+		Top:       b.topRequests(n),
+		Intervals: make([]IntervalCount, 0, len(b.intervals)),
+		Latency:   make([]URLLatency, 0),
+		Alerts:    make([]string, 0),
+	}
+	if b.currentTopWindow() == 0 {
+		r.TopError = countError(b.rc)
+	}
+	lats := make(map[string]*URLLatency)
+	for _, i := range b.intervals {
+		r.Intervals = append(r.Intervals, IntervalCount{
+			Span: i.s,
+			C:    b.ad.GetSpanCount(end.Add(-i.t), end),
+		})
+		recent := b.latency.Recent(i.t)
+		for _, v := range r.Top {
+			l, ok := recent[v.URL]
+			if !ok {
+				continue
+			}
+			if lats[v.URL] == nil {
+				lats[v.URL] = &URLLatency{URL: v.URL}
+			}
+			lats[v.URL].Spans = append(lats[v.URL].Spans, SpanLatency{
+				Span:  i.s,
+				Count: l.Count,
+				P50:   millis(l.P50),
+				P90:   millis(l.P90),
+				P99:   millis(l.P99),
+				Max:   millis(l.Max),
+			})
+		}
+	}
+	for _, v := range r.Top {
+		if l, ok := lats[v.URL]; ok {
+			r.Latency = append(r.Latency, *l)
+		}
+	}
+	hosts := b.hosts.Export()
+	r.Hosts = topNRequests(hosts, len(hosts))
+	methods := b.methods.Export()
+	r.Methods = topNRequests(methods, len(methods))
+	versions := b.tls.Export()
+	r.TLS = topNRequests(versions, len(versions))
+	r.Talkers = Talkers{
+		Hosts:    topTalkers(b.remoteBytes.Total(), n),
+		Ports:    topTalkers(b.portBytes.Total(), n),
+		Sections: topTalkers(b.sectionBytes.Total(), n),
+	}
+
+	b.historyMux.Lock()
+	for _, n := range b.history {
+		r.Alerts = append(r.Alerts, n.String())
+	}
+	b.historyMux.Unlock()
+	return r
+}
+
+// Write formats the report to w as plain text, JSON or CSV.
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatText:
+		return r.writeText(w)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case FormatCSV:
+		return r.writeCSV(w)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func (r Report) writeText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("Banken[番犬] HTTP Traffic Report -- %s\n", r.End.Format(time.RFC3339))
+
+	ew.printf("\nTop %d HTTP Requested Paths, %s\n", len(r.Top), r.TopWindow)
+	if r.TopError > 0 {
+		ew.printf("  counts may overestimate by up to %d\n", r.TopError)
+	}
+	for i, v := range r.Top {
+		ew.printf("  [%d]: %s -> %d\n", i+1, v.URL, v.C)
+	}
+	ew.printf("\nHTTP Requests per Timespan\n")
+	for _, v := range r.Intervals {
+		ew.printf("  %s: %d\n", v.Span, v.C)
+	}
+	ew.printf("\nHTTP Requests per Host\n")
+	for _, v := range r.Hosts {
+		ew.printf("  %s: %d\n", v.URL, v.C)
+	}
+	ew.printf("\nHTTP Requests per Method\n")
+	for _, v := range r.Methods {
+		ew.printf("  %s: %d\n", v.URL, v.C)
+	}
+	ew.printf("\nHTTPS Connections per TLS Version\n")
+	for _, v := range r.TLS {
+		ew.printf("  %s: %d\n", v.URL, v.C)
+	}
+	for _, t := range []struct {
+		s string
+		v []ByteCount
+	}{{"Remote Host", r.Talkers.Hosts}, {"Local Port", r.Talkers.Ports}, {"HTTP Section", r.Talkers.Sections}} {
+		ew.printf("\nTop Talkers per %s\n", t.s)
+		for i, v := range t.v {
+			ew.printf("  [%d]: %s -> %s sent, %s received\n", i+1, v.Key, byteSize(v.Sent), byteSize(v.Received))
+		}
+	}
+	ew.printf("\nHTTP Response Latency\n")
+	for _, l := range r.Latency {
+		ew.printf("  %s\n", l.URL)
+		for _, v := range l.Spans {
+			ew.printf("    %s: %d responses, p50 %gms p90 %gms p99 %gms max %gms\n", v.Span, v.Count, v.P50, v.P90, v.P99, v.Max)
+		}
+	}
+	ew.printf("\nHTTP Req Rate Alerts\n")
+	for i, a := range r.Alerts {
+		ew.printf("  [%d] %s\n", i+1, a)
+	}
+	return ew.err
+}
+
+// writeCSV flattens the report into section,key,value records.
+func (r Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{"section", "key", "value"}}
+	for _, v := range r.Top {
+		records = append(records, []string{"top", v.URL, strconv.FormatUint(v.C, 10)})
+	}
+	for _, v := range r.Intervals {
+		records = append(records, []string{"interval", v.Span, strconv.Itoa(v.C)})
+	}
+	for _, v := range r.Hosts {
+		records = append(records, []string{"host", v.URL, strconv.FormatUint(v.C, 10)})
+	}
+	for _, v := range r.Methods {
+		records = append(records, []string{"method", v.URL, strconv.FormatUint(v.C, 10)})
+	}
+	for _, v := range r.TLS {
+		records = append(records, []string{"tls", v.URL, strconv.FormatUint(v.C, 10)})
+	}
+	for _, t := range []struct {
+		s string
+		v []ByteCount
+	}{{"talker_host", r.Talkers.Hosts}, {"talker_port", r.Talkers.Ports}, {"talker_section", r.Talkers.Sections}} {
+		for _, v := range t.v {
+			records = append(records,
+				[]string{t.s, v.Key + " sent", strconv.FormatInt(v.Sent, 10)},
+				[]string{t.s, v.Key + " received", strconv.FormatInt(v.Received, 10)})
+		}
+	}
+	for _, l := range r.Latency {
+		for _, v := range l.Spans {
+			for _, p := range []struct {
+				s string
+				v float64
+			}{{"p50_ms", v.P50}, {"p90_ms", v.P90}, {"p99_ms", v.P99}, {"max_ms", v.Max}} {
+				key := fmt.Sprintf("%s %s %s", l.URL, v.Span, p.s)
+				records = append(records, []string{"latency", key, strconv.FormatFloat(p.v, 'f', -1, 64)})
+			}
+		}
+	}
+	for i, a := range r.Alerts {
+		records = append(records, []string{"alert", strconv.Itoa(i + 1), a})
+	}
+	return cw.WriteAll(records)
+}
+
+// millis converts the duration to fractional milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// errWriter retains the first error of a sequence of writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportWrite(t *testing.T) {
+	r := Report{
+		End:       time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC),
+		TopWindow: "last 5m",
+		Top:       []ReqCount{{URL: "http://rusutsu.com/ski", C: 100}},
+		Intervals: []IntervalCount{{Span: "1m", C: 60}, {Span: "5m", C: 100}},
+		Hosts:     []ReqCount{{URL: "rusutsu.com", C: 100}},
+		Methods:   []ReqCount{{URL: "GET", C: 100}},
+		Latency: []URLLatency{{URL: "http://rusutsu.com/ski", Spans: []SpanLatency{
+			{Span: "1m", Count: 60, P50: 12, P90: 20, P99: 40.5, Max: 41},
+		}}},
+		Alerts: []string{"High traffic generated an alert"},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, FormatText); err != nil {
+			t.Fatal(err)
+		}
+		for _, exp := range []string{"Top 1 HTTP Requested Paths, last 5m", "[1]: http://rusutsu.com/ski -> 100", "5m: 100", "rusutsu.com: 100", "GET: 100", "1m: 60 responses, p50 12ms p90 20ms p99 40.5ms max 41ms", "[1] High traffic"} {
+			if !strings.Contains(buf.String(), exp) {
+				t.Errorf("text report missing %q:\n%s", exp, buf.String())
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, FormatJSON); err != nil {
+			t.Fatal(err)
+		}
+		var out Report
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r, out) {
+			t.Errorf("json report round trip mismatch: %+v != %+v", out, r)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, FormatCSV); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 11 {
+			t.Fatalf("expected header and 10 records: %v", records)
+		}
+		if exp := []string{"latency", "http://rusutsu.com/ski 1m p99_ms", "40.5"}; !reflect.DeepEqual(records[8], exp) {
+			t.Errorf("record %v != %v", records[8], exp)
+		}
+		if exp := []string{"interval", "5m", "100"}; !reflect.DeepEqual(records[3], exp) {
+			t.Errorf("record %v != %v", records[3], exp)
+		}
+	})
+
+	if err := r.Write(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
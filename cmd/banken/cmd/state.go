@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic"
+	log "github.com/sirupsen/logrus"
+)
+
+// stateVersion is incremented when the state file format changes
+// incompatibly, so older state files are ignored rather than misread.
+const stateVersion = 1
+
+// state is the traffic recorded by Banken, saved to the --state-file so it
+// survives restarts.
+type state struct {
+	Version int       `json:"version"`
+	Saved   time.Time `json:"saved"`
+
+	Requests  traffic.Counter         `json:"requests"`
+	Recent    *traffic.WindowCounter  `json:"recent_requests"`
+	Hosts     traffic.Counter         `json:"hosts"`
+	Methods   *traffic.RequestCounter `json:"methods"`
+	Responses traffic.Counter         `json:"responses"`
+	Errors    traffic.Counter         `json:"errors"`
+	TLS       *traffic.RequestCounter `json:"tls"`
+	Latency   *traffic.LatencyTracker `json:"latency"`
+
+	RemoteBytes  *traffic.BandwidthTracker `json:"remote_bytes"`
+	PortBytes    *traffic.BandwidthTracker `json:"port_bytes"`
+	SectionBytes *traffic.BandwidthTracker `json:"section_bytes"`
+
+	// Threshold and Rules are the traffic recorded by the alert detectors,
+	// the latter keyed by rule name.
+	Threshold ruleState            `json:"threshold"`
+	Rules     map[string]ruleState `json:"rules"`
+}
+
+// ruleState is the traffic recorded by an alert detector, with the traffic
+// its rule measured. It is only restored to a rule measuring the same.
+type ruleState struct {
+	Metric   traffic.Metric  `json:"metric"`
+	Scope    traffic.Scope   `json:"scope"`
+	Key      string          `json:"key,omitempty"`
+	Recorded json.RawMessage `json:"recorded"`
+}
+
+// newRuleState captures the traffic recorded by the detector.
+func newRuleState(d *traffic.AlertDetector) (ruleState, error) {
+	r := d.Rule()
+	recorded, err := json.Marshal(d)
+	return ruleState{Metric: r.Metric, Scope: r.Scope, Key: r.Key, Recorded: recorded}, err
+}
+
+// restore replaces the traffic recorded by the detector, when its rule
+// measures the same traffic as the saved rule.
+func (s ruleState) restore(d *traffic.AlertDetector) error {
+	r := d.Rule()
+	if len(s.Recorded) == 0 || s.Metric != r.Metric || s.Scope != r.Scope || s.Key != r.Key {
+		return nil
+	}
+	return json.Unmarshal(s.Recorded, d)
+}
+
+// PersistState configures Banken to restore the traffic recorded from the
+// state file at path on Init, and to save it there every interval and on
+// Close. Recorded timespans too old to be retained are discarded when
+// restored. It is ignored when replaying a capture file. Must be called
+// before Init.
+func (b *Banken) PersistState(path string, interval time.Duration) error {
+	if path != "" && interval <= 0 {
+		return fmt.Errorf("state save interval %s must be positive", interval)
+	}
+	b.stateFile = path
+	b.stateInterval = interval
+	return nil
+}
+
+// persisting reports whether the traffic recorded is saved to a state file.
+func (b *Banken) persisting() bool {
+	return b.stateFile != "" && b.replayFile == ""
+}
+
+// saveState writes the traffic recorded to the state file, replacing it
+// atomically so a crash while saving leaves the previous state intact.
+func (b *Banken) saveState() error {
+	s := state{
+		Version:      stateVersion,
+		Saved:        b.clock.Time(),
+		Requests:     b.rc,
+		Recent:       b.recent,
+		Hosts:        b.hosts,
+		Methods:      b.methods,
+		Responses:    b.responses,
+		Errors:       b.errs,
+		TLS:          b.tls,
+		Latency:      b.latency,
+		RemoteBytes:  b.remoteBytes,
+		PortBytes:    b.portBytes,
+		SectionBytes: b.sectionBytes,
+		Rules:        make(map[string]ruleState),
+	}
+	var err error
+	if s.Threshold, err = newRuleState(b.ad); err != nil {
+		return err
+	}
+	b.settingsMux.RLock()
+	detectors := b.detectors
+	b.settingsMux.RUnlock()
+	for _, d := range detectors {
+		rs, err := newRuleState(d.AlertDetector)
+		if err != nil {
+			return err
+		}
+		s.Rules[d.Rule().Name] = rs
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("unable to encode state: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(b.stateFile), filepath.Base(b.stateFile)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), b.stateFile); err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+	return nil
+}
+
+// restoreState reads the traffic recorded from the state file into the
+// initialized models. A missing state file is not an error, it is created
+// when first saved.
+func (b *Banken) restoreState() error {
+	data, err := ioutil.ReadFile(b.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read state: %w", err)
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("invalid state file %q: %w", b.stateFile, err)
+	}
+	if header.Version != stateVersion {
+		return fmt.Errorf("state file %q has version %d, expected %d", b.stateFile, header.Version, stateVersion)
+	}
+
+	s := state{
+		Requests:     b.rc,
+		Recent:       b.recent,
+		Hosts:        b.hosts,
+		Methods:      b.methods,
+		Responses:    b.responses,
+		Errors:       b.errs,
+		TLS:          b.tls,
+		Latency:      b.latency,
+		RemoteBytes:  b.remoteBytes,
+		PortBytes:    b.portBytes,
+		SectionBytes: b.sectionBytes,
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid state file %q: %w", b.stateFile, err)
+	}
+	if err := s.Threshold.restore(b.ad); err != nil {
+		return fmt.Errorf("invalid state of the alert threshold: %w", err)
+	}
+	for _, d := range b.detectors {
+		name := d.Rule().Name
+		if err := s.Rules[name].restore(d.AlertDetector); err != nil {
+			return fmt.Errorf("invalid state of alert rule %q: %w", name, err)
+		}
+	}
+	b.logger.WithFields(log.Fields{"file": b.stateFile, "saved": s.Saved}).Info("traffic state restored")
+	return nil
+}
+
+// saveStates saves the traffic recorded every stateInterval until Banken's
+// context is done.
+func (b *Banken) saveStates() {
+	t := time.NewTicker(b.stateInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+			if err := b.saveState(); err != nil {
+				b.logger.Warn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPersistState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	ski := traffic.Rule{Name: "ski", Metric: traffic.MetricRequests, Scope: traffic.ScopeHost, Key: "rusutsu.com", Window: time.Minute, Threshold: 100}
+	lift := traffic.Rule{Name: "lift", Metric: traffic.MetricRequests, Scope: traffic.ScopeTotal, Window: time.Minute, Threshold: 50}
+
+	start := func(ctx context.Context, rules ...traffic.Rule) *Banken {
+		b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+		if err := b.PersistState(path, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AlertRules(rules); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := b.Init(nil, nil, nil, nil, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	ctx, can := context.WithCancel(context.Background())
+	b := start(ctx, ski, lift)
+	now := time.Now()
+	b.rc.IncKey("http://rusutsu.com/ski", 3)
+	b.latency.Observe("http://rusutsu.com/ski", 20*time.Millisecond, now)
+	b.remoteBytes.Observe("10.0.0.1", 100, 2000, now)
+	b.ad.Increment(3, now)
+	for _, d := range b.detectors {
+		d.Observe(traffic.Sample{Host: "rusutsu.com", Section: "http://rusutsu.com/ski", Requests: 1, TS: now})
+	}
+	can()
+	b.Close()
+
+	// The restarted ski rule measures other traffic, so starts afresh.
+	ctx, can = context.WithCancel(context.Background())
+	defer can()
+	ski.Key = "niseko.com"
+	b = start(ctx, ski, lift)
+	if c := b.rc.Export()["http://rusutsu.com/ski"]; c != 3 {
+		t.Errorf("restored request count %d != 3", c)
+	}
+	if s := b.latency.Total()["http://rusutsu.com/ski"]; s.Count != 1 {
+		t.Errorf("unexpected restored latency: %+v", s)
+	}
+	if bw := b.remoteBytes.Total()["10.0.0.1"]; bw != (traffic.Bandwidth{Sent: 100, Received: 2000}) {
+		t.Errorf("unexpected restored bandwidth: %+v", bw)
+	}
+	if c := b.ad.GetSpanCount(now.Add(-time.Minute), time.Now()); c != 3 {
+		t.Errorf("restored alert threshold count %d != 3", c)
+	}
+	if c := b.detectors[0].GetSpanCount(now.Add(-time.Minute), time.Now()); c != 0 {
+		t.Errorf("rule measuring other traffic restored count %d", c)
+	}
+	if c := b.detectors[1].GetSpanCount(now.Add(-time.Minute), time.Now()); c != 1 {
+		t.Errorf("restored rule count %d != 1", c)
+	}
+
+	// A corrupt state file is ignored.
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b = start(ctx)
+	if c := len(b.rc.Export()); c != 0 {
+		t.Errorf("counts restored from a corrupt state file: %d", c)
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLAggregation configures how the URLs requested are aggregated into the
+// sections they are counted under, see AggregateURLs.
+type URLAggregation struct {
+	// Depth is the number of path segments sections are truncated to, eg: 2
+	// counts '/api/v1/users' under 'http://host/api/v1'. The last segment of
+	// a path is a file unless followed by a slash, so '/api/v1' is counted
+	// under 'http://host/api'.
+	Depth int
+	// HostDepths override Depth for requests to the hosts, keyed by host.
+	HostDepths map[string]int
+	// Templates collapse the variable segments of matching paths into their
+	// placeholders, eg: '/users/{id}' counts '/users/alice/posts' as
+	// '/users/{id}/posts'. A placeholder matches any single segment, or only
+	// those matching its regular expression, eg: '/orders/{order:[0-9]+}'.
+	// The first matching template applies.
+	Templates []string
+	// CollapseIDs replaces segments which are numeric IDs, UUIDs or hex
+	// hashes, of at least 16 digits, by '{id}', '{uuid}' and '{hash}'.
+	CollapseIDs bool
+}
+
+// DefaultURLAggregation counts requests under the first section of their
+// path, as they always have been.
+func DefaultURLAggregation() URLAggregation {
+	return URLAggregation{Depth: 1}
+}
+
+// defaultURLAggregator applies the DefaultURLAggregation.
+var defaultURLAggregator, _ = newURLAggregator(DefaultURLAggregation())
+
+// urlAggregator aggregates request URLs into sections, see URLAggregation.
+type urlAggregator struct {
+	depth       int
+	hostDepths  map[string]int
+	templates   []urlTemplate
+	collapseIDs bool
+}
+
+// urlTemplate is a parsed URLAggregation template, matched against the
+// leading segments of a path.
+type urlTemplate []templateSegment
+
+// templateSegment is matched literally, or when placeholder is set by any
+// segment matching re, or any segment at all when re is nil.
+type templateSegment struct {
+	literal     string
+	placeholder string
+	re          *regexp.Regexp
+}
+
+var (
+	numericID = regexp.MustCompile(`^[0-9]+$`)
+	uuid      = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexHash   = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// newURLAggregator validates the aggregation and parses its templates.
+func newURLAggregator(c URLAggregation) (*urlAggregator, error) {
+	if c.Depth < 0 {
+		return nil, fmt.Errorf("url depth %d may not be negative", c.Depth)
+	}
+	a := &urlAggregator{
+		depth:       c.Depth,
+		hostDepths:  make(map[string]int, len(c.HostDepths)),
+		collapseIDs: c.CollapseIDs,
+	}
+	for host, d := range c.HostDepths {
+		if host == "" {
+			return nil, fmt.Errorf("url depth %d has no host", d)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("url depth %d of host %q may not be negative", d, host)
+		}
+		a.hostDepths[normalizeHost(host)] = d
+	}
+	for _, t := range c.Templates {
+		tmpl, err := parseURLTemplate(t)
+		if err != nil {
+			return nil, err
+		}
+		a.templates = append(a.templates, tmpl)
+	}
+	return a, nil
+}
+
+// parseURLTemplate parses a path template, eg: '/users/{id}'.
+func parseURLTemplate(s string) (urlTemplate, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("url template %q must start with /", s)
+	}
+	var t urlTemplate
+	for _, seg := range pathSegments(s) {
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			t = append(t, templateSegment{literal: seg})
+			continue
+		}
+		name, expr := seg[1:len(seg)-1], ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			name, expr = name[:i], name[i+1:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("url template %q has an unnamed placeholder", s)
+		}
+		ts := templateSegment{placeholder: "{" + name + "}"}
+		if expr != "" {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("url template %q: %w", s, err)
+			}
+			ts.re = re
+		}
+		t = append(t, ts)
+	}
+	if len(t) == 0 {
+		return nil, fmt.Errorf("url template %q has no segments", s)
+	}
+	return t, nil
+}
+
+// apply replaces the leading segments matched by the template with its
+// placeholders, reporting whether it matched.
+func (t urlTemplate) apply(segs []string) bool {
+	if len(segs) < len(t) {
+		return false
+	}
+	for i, ts := range t {
+		switch {
+		case ts.placeholder == "":
+			if segs[i] != ts.literal {
+				return false
+			}
+		case ts.re != nil:
+			if !ts.re.MatchString(segs[i]) {
+				return false
+			}
+		}
+	}
+	for i, ts := range t {
+		if ts.placeholder != "" {
+			segs[i] = ts.placeholder
+		}
+	}
+	return true
+}
+
+// Section is the URL the request of the percent-encoded path on host, as
+// requested rather than decoded, is counted under. Hosts are compared
+// case-insensitively, and paths are split into segments, with duplicate
+// slashes removed, before each segment is percent-decoded once, so an
+// encoded slash does not split its segment. Variable segments are then
+// collapsed and the path truncated to the host's depth.
+//
+// The last segment is a file unless followed by a slash, so the trailing
+// slash matters: '/ski/' is counted under '/ski', but '/ski' under '/'.
+func (a *urlAggregator) Section(host, path string) string {
+	host = normalizeHost(host)
+	segs := pathSegments(path)
+	for i, seg := range segs {
+		if s, err := url.PathUnescape(seg); err == nil {
+			segs[i] = s
+		}
+	}
+	for _, t := range a.templates {
+		if t.apply(segs) {
+			break
+		}
+	}
+	if a.collapseIDs {
+		for i, seg := range segs {
+			switch {
+			case numericID.MatchString(seg):
+				segs[i] = "{id}"
+			case uuid.MatchString(seg):
+				segs[i] = "{uuid}"
+			case hexHash.MatchString(seg):
+				segs[i] = "{hash}"
+			}
+		}
+	}
+
+	// The last segment is a file, unless followed by a slash.
+	if len(segs) > 0 && !strings.HasSuffix(path, "/") {
+		segs = segs[:len(segs)-1]
+	}
+	depth, ok := a.hostDepths[host]
+	if !ok {
+		depth = a.depth
+	}
+	if len(segs) > depth {
+		segs = segs[:depth]
+	}
+	for i, seg := range segs {
+		if !strings.HasPrefix(seg, "{") {
+			segs[i] = url.PathEscape(seg)
+		}
+	}
+	return "http://" + host + "/" + strings.Join(segs, "/")
+}
+
+// pathSegments splits the path into its non-empty segments, so duplicate
+// slashes are ignored.
+func pathSegments(path string) []string {
+	segs := make([]string, 0)
+	for _, seg := range strings.Split(path, "/") {
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// normalizeHost is the host requests are counted under, hosts being case
+// insensitive.
+func normalizeHost(host string) string {
+	return strings.ToLower(host)
+}
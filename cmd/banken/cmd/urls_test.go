@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ropes/banken/pkg/sniff"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestURLAggregation(t *testing.T) {
+	a, err := newURLAggregator(URLAggregation{
+		Depth:      1,
+		HostDepths: map[string]int{"API.rusutsu.com": 3},
+		Templates: []string{
+			"/api/{version:v[0-9]+}/users/{user}",
+			"/lift/{pass}",
+		},
+		CollapseIDs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		path string
+		exp  string
+	}{
+		// Sections of the default depth.
+		{"rusutsu.com", "/ski/kona/yuki.jpg", "http://rusutsu.com/ski"},
+		// The trailing slash marks the last segment as a section, not a file.
+		{"rusutsu.com", "/ski/", "http://rusutsu.com/ski"},
+		{"rusutsu.com", "/ski", "http://rusutsu.com/"},
+		{"rusutsu.com", "/ski.jpg", "http://rusutsu.com/"},
+		{"rusutsu.com", "//", "http://rusutsu.com/"},
+		// Hosts and paths are normalised.
+		{"RUSUTSU.com", "//ski///kona", "http://rusutsu.com/ski"},
+		{"rusutsu.com", "/sk%69/kona", "http://rusutsu.com/ski"},
+		{"rusutsu.com", "/ski%20run/kona", "http://rusutsu.com/ski%20run"},
+		// Encoded slashes are decoded within their segment.
+		{"rusutsu.com", "/ski%2Fkona/yuki", "http://rusutsu.com/ski%2Fkona"},
+		{"rusutsu.com", "/ski%2Fkona", "http://rusutsu.com/"},
+		// IDs are collapsed.
+		{"rusutsu.com", "/42/kona", "http://rusutsu.com/{id}"},
+		{"rusutsu.com", "/0b4e7a0e-5fe8-4c33-a5b2-1b2e4f6a8c9d/kona", "http://rusutsu.com/{uuid}"},
+		{"rusutsu.com", "/d41d8cd98f00b204e9800998ecf8427e/kona", "http://rusutsu.com/{hash}"},
+		{"rusutsu.com", "/cafe/kona", "http://rusutsu.com/cafe"},
+		// Templates collapse their placeholders.
+		{"rusutsu.com", "/lift/gondola/", "http://rusutsu.com/lift"},
+		// Hosts may count deeper sections.
+		{"api.rusutsu.com", "/api/v1/users", "http://api.rusutsu.com/api/v1"},
+		{"api.rusutsu.com", "/api/v1/users/", "http://api.rusutsu.com/api/v1/users"},
+		{"api.rusutsu.com", "/api/v1/", "http://api.rusutsu.com/api/v1"},
+		{"api.rusutsu.com", "/api/v1", "http://api.rusutsu.com/api"},
+		{"api.rusutsu.com", "/api/v2/orders/1234/items", "http://api.rusutsu.com/api/v2/orders"},
+		{"api.rusutsu.com", "/api/v2/users/alice/posts", "http://api.rusutsu.com/api/{version}/users"},
+		{"api.rusutsu.com", "/api/beta/users/alice/posts", "http://api.rusutsu.com/api/beta/users"},
+	}
+	for _, test := range tests {
+		if out := a.Section(test.host, test.path); out != test.exp {
+			t.Errorf("section of %s%s: %q != %q", test.host, test.path, out, test.exp)
+		}
+	}
+
+	deep, err := newURLAggregator(URLAggregation{
+		Depth:     4,
+		Templates: []string{"/users/{id}", "/orders/{order:[A-Z]+-[0-9]+}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, exp := range map[string]string{
+		"/users/alice/posts/1/":   "http://rusutsu.com/users/{id}/posts/1",
+		"/orders/SKI-12/items/":   "http://rusutsu.com/orders/{order}/items",
+		"/orders/ski-12/items/":   "http://rusutsu.com/orders/ski-12/items",
+		"/users":                  "http://rusutsu.com/",
+		"/a/b/c/d/e/f/g/h/i/j/k/": "http://rusutsu.com/a/b/c/d",
+	} {
+		if out := deep.Section("rusutsu.com", path); out != exp {
+			t.Errorf("deep section of %s: %q != %q", path, out, exp)
+		}
+	}
+
+	// IDs are only collapsed when configured, so sections are unchanged by
+	// default.
+	if out := defaultURLAggregator.Section("rusutsu.com", "/42/kona"); out != "http://rusutsu.com/42" {
+		t.Errorf("default section of /42/kona: %q", out)
+	}
+
+	for _, invalid := range []URLAggregation{
+		{Depth: -1},
+		{Depth: 1, HostDepths: map[string]int{"rusutsu.com": -1}},
+		{Depth: 1, HostDepths: map[string]int{"": 2}},
+		{Depth: 1, Templates: []string{"users/{id}"}},
+		{Depth: 1, Templates: []string{"/users/{}"}},
+		{Depth: 1, Templates: []string{"/users/{id:[0-9}"}},
+		{Depth: 1, Templates: []string{"/"}},
+	} {
+		if _, err := newURLAggregator(invalid); err == nil {
+			t.Errorf("invalid aggregation %+v accepted", invalid)
+		}
+	}
+}
+
+func TestAggregateURLs(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	if err := b.AggregateURLs(URLAggregation{Depth: 2, CollapseIDs: true}); err != nil {
+		t.Fatal(err)
+	}
+	_, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	packets <- sniff.HTTPXPacket{TS: now, Host: "Rusutsu.com", Path: "/api/v1/users", Method: "GET"}
+	packets <- sniff.HTTPXPacket{TS: now, Host: "rusutsu.com", Path: "/api/v2/orders", Method: "GET"}
+	packets <- sniff.HTTPXPacket{TS: now, Host: "rusutsu.com", Path: "/api/v2/orders/", Method: "GET"}
+	packets <- sniff.HTTPXPacket{TS: now, Host: "rusutsu.com", Path: "/users/42/posts", Method: "GET"}
+	close(packets)
+	b.consumers.Wait()
+
+	exp := map[string]uint64{
+		"http://rusutsu.com/api/v1":     1,
+		"http://rusutsu.com/api/v2":     2,
+		"http://rusutsu.com/users/{id}": 1,
+	}
+	if m := b.rc.Export(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("section counts %v != %v", m, exp)
+	}
+	if m := b.hosts.Export(); !reflect.DeepEqual(m, map[string]uint64{"rusutsu.com": 4}) {
+		t.Errorf("host counts %v, expected hosts compared case-insensitively", m)
+	}
+}
+
+func TestAggregateRequestedURLs(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	b := NewBanken(ctx, 10, 10, "tcp port 80", l)
+	_, packets, err := b.Init(nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requests are decoded by net/http as they are when captured, so their
+	// paths are only percent-decoded once.
+	for _, target := range []string{"/ski%2Fkona/yuki", "/100%2525/x", "/ski%20run/kona"} {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: rusutsu.com\r\n\r\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets <- sniff.HTTPXPacket{
+			TS: time.Now(), Host: req.Host, Path: req.URL.Path, RawPath: req.URL.EscapedPath(), Method: req.Method,
+		}
+	}
+	close(packets)
+	b.consumers.Wait()
+
+	exp := map[string]uint64{
+		"http://rusutsu.com/ski%2Fkona": 1,
+		"http://rusutsu.com/100%2525":   1,
+		"http://rusutsu.com/ski%20run":  1,
+	}
+	if m := b.rc.Export(); !reflect.DeepEqual(m, exp) {
+		t.Errorf("section counts %v != %v", m, exp)
+	}
+}
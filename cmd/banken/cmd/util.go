@@ -1,39 +1,102 @@
 package cmd
 
 import (
+	"fmt"
 	"net/url"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/ropes/banken/pkg/sniff"
+	"github.com/ropes/banken/pkg/traffic"
 )
 
 // HTTPURLSlug reduces the path down to only its first element
 // iff the path exists. Maintains the base / for all URLs which
-// do not contain a section.
+// do not contain a section. See URLAggregation to count deeper sections.
 func HTTPURLSlug(domain, path string) string {
-	slug := strings.Split(path[1:], "/")
-	var p string
-	if len(slug) >= 2 {
-		p = slug[0]
-		if p == "" {
-			p = "/"
-		}
-	} else if len(slug) == 1 {
-		p = "/"
-	} else {
-		p = "/"
-	}
+	return (&urlAggregator{depth: 1}).Section(domain, path)
+}
+
+// HTTPSURLSlug is the URL HTTPS connections to the server are counted
+// under. Their paths are encrypted, so only the base / is known.
+func HTTPSURLSlug(server string) string {
 	u := url.URL{
-		Scheme: "http",
-		Host:   domain,
-		Path:   p,
+		Scheme: "https",
+		Host:   normalizeHost(server),
+		Path:   "/",
 	}
 	return u.String()
 }
 
+// ByteCount links a remote host, server port or section to the bytes it
+// transferred.
+type ByteCount struct {
+	Key      string `json:"key"`
+	Sent     int64  `json:"sent"`
+	Received int64  `json:"received"`
+}
+
+// topTalkers ranks the keys by bytes transferred in both directions,
+// returning at most n.
+func topTalkers(m map[string]traffic.Bandwidth, n int) []ByteCount {
+	talkers := make([]ByteCount, 0, len(m))
+	for k, v := range m {
+		talkers = append(talkers, ByteCount{Key: k, Sent: v.Sent, Received: v.Received})
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		ti, tj := talkers[i].Sent+talkers[i].Received, talkers[j].Sent+talkers[j].Received
+		if ti != tj {
+			return ti > tj
+		}
+		return talkers[i].Key < talkers[j].Key
+	})
+	if len(talkers) > n {
+		talkers = talkers[:n]
+	}
+	return talkers
+}
+
+// captureSummary formats an interface's capture status for display, eg:
+// "tun0: failed (no such device) on attempt 3, retrying in 4s".
+func captureSummary(s sniff.CaptureStatus) string {
+	if s.State != sniff.CaptureFailed {
+		return fmt.Sprintf("%s: %s", s.Iface, s.State)
+	}
+	return fmt.Sprintf("%s: %s (%v) on attempt %d, retrying in %s", s.Iface, s.State, s.Err, s.Attempts, s.Retry)
+}
+
+// shortDuration formats a duration without its zero trailing units, eg:
+// "1h" rather than "1h0m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// byteSize formats a byte count with a binary unit, eg: "1.5KiB".
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // ReqCount links URLs to their request occurrence count:C.
 type ReqCount struct {
-	URL string
-	C   uint64
+	URL string `json:"url"`
+	C   uint64 `json:"count"`
 }
 
 func topNRequests(m map[string]uint64, n int) []ReqCount {
@@ -50,3 +113,26 @@ func topNRequests(m map[string]uint64, n int) []ReqCount {
 	}
 	return reqs
 }
+
+// responseSummary formats the error rate and latency percentiles of the
+// responses captured for a URL. Returns an empty string if no responses were
+// captured.
+func responseSummary(responses, errs uint64, lat traffic.LatencySummary) string {
+	if responses == 0 {
+		return ""
+	}
+	errRate := 100 * float64(errs) / float64(responses)
+	if lat.Count == 0 {
+		return fmt.Sprintf(" (%.1f%% errors)", errRate)
+	}
+	return fmt.Sprintf(" (%.1f%% errors, p50 %v p99 %v)", errRate, roundLatency(lat.P50), roundLatency(lat.P99))
+}
+
+// latencyPercentiles formats every percentile of the latency summary.
+func latencyPercentiles(lat traffic.LatencySummary) string {
+	return fmt.Sprintf("p50 %v p90 %v p99 %v max %v", roundLatency(lat.P50), roundLatency(lat.P90), roundLatency(lat.P99), roundLatency(lat.Max))
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
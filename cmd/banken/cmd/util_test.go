@@ -1,6 +1,12 @@
 package cmd
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic"
+)
 
 func TestHTTPSlug(t *testing.T) {
 	domain := "rusutsu.com"
@@ -72,3 +78,43 @@ func TestTopN(t *testing.T) {
 	}
 
 }
+
+func TestResponseSummary(t *testing.T) {
+	tests := []struct {
+		responses, errs uint64
+		lat             traffic.LatencySummary
+		exp             string
+	}{
+		{exp: ""},
+		{responses: 4, errs: 1, lat: traffic.LatencySummary{Count: 4, P50: 12 * time.Millisecond, P99: 40*time.Millisecond + 12*time.Microsecond}, exp: " (25.0% errors, p50 12ms p99 40ms)"},
+		{responses: 3, exp: " (0.0% errors)"},
+	}
+	for _, test := range tests {
+		if out := responseSummary(test.responses, test.errs, test.lat); out != test.exp {
+			t.Errorf("responseSummary(%d, %d, %+v) = %q, exp: %q", test.responses, test.errs, test.lat, out, test.exp)
+		}
+	}
+}
+
+func TestTopTalkers(t *testing.T) {
+	m := map[string]traffic.Bandwidth{
+		"10.0.0.2": {Sent: 100, Received: 1 << 20},
+		"10.0.0.3": {Sent: 2048},
+		"10.0.0.4": {Received: 2048},
+		"10.0.0.5": {Sent: 1},
+	}
+	exp := []ByteCount{
+		{Key: "10.0.0.2", Sent: 100, Received: 1 << 20},
+		{Key: "10.0.0.3", Sent: 2048},
+		{Key: "10.0.0.4", Received: 2048},
+	}
+	if top := topTalkers(m, 3); !reflect.DeepEqual(top, exp) {
+		t.Errorf("topTalkers = %v, exp: %v", top, exp)
+	}
+
+	for n, exp := range map[int64]string{0: "0B", 1023: "1023B", 1536: "1.5KiB", 1 << 20: "1.0MiB", 5 << 30: "5.0GiB"} {
+		if s := byteSize(n); s != exp {
+			t.Errorf("byteSize(%d) = %q, exp: %q", n, s, exp)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagConfig = "config"
+	// envPrefix prefixes the environment variables overriding settings, eg:
+	// BANKEN_ALERT_THRESHOLD overrides --alert-threshold.
+	envPrefix = "banken"
+)
+
+var (
+	configFile string
+	// config holds the settings read from the config file and environment,
+	// keyed by flag name.
+	config = viper.New()
+)
+
+// configDirs are the directories searched for a config.yaml, config.toml or
+// config.json when --config is not given.
+func configDirs() []string {
+	dirs := make([]string, 0, 2)
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "banken"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dir := filepath.Join(home, ".config", "banken")
+		if len(dirs) == 0 || dirs[0] != dir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// readConfig reads the --config file, or the first config file found in
+// configDirs, and enables environment variable overrides. A missing config
+// file is only an error when given by --config.
+func readConfig() error {
+	config.SetEnvPrefix(envPrefix)
+	config.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	config.AutomaticEnv()
+
+	if configFile != "" {
+		config.SetConfigFile(configFile)
+	} else {
+		config.SetConfigName("config")
+		for _, dir := range configDirs() {
+			config.AddConfigPath(dir)
+		}
+	}
+	err := config.ReadInConfig()
+	if errors.As(err, &viper.ConfigFileNotFoundError{}) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read config: %w", err)
+	}
+	return nil
+}
+
+// applyConfig sets each flag not given on the command line from the
+// environment, or else the config file, keyed by the flag's name. Flags
+// given on the command line take precedence over both.
+func applyConfig(fs *pflag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == flagConfig || !config.IsSet(f.Name) {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			values := config.GetStringSlice(f.Name)
+			if s, ok := config.Get(f.Name).(string); ok {
+				values, err = splitSlice(f, s)
+			}
+			if err == nil {
+				err = sv.Replace(values)
+			}
+		} else {
+			err = f.Value.Set(config.GetString(f.Name))
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid config value for %s: %w", f.Name, err)
+		}
+	})
+	return err
+}
+
+// splitSlice splits a list flag's value given as a single string, by an
+// environment variable or a config file scalar, as the flag would on the
+// command line: on commas, except for repeatable flags whose values may
+// contain commas, eg: --alert-rule.
+func splitSlice(f *pflag.Flag, s string) ([]string, error) {
+	if strings.HasSuffix(f.Value.Type(), "Array") {
+		return []string{s}, nil
+	}
+	if s == "" {
+		return nil, nil
+	}
+	return csv.NewReader(strings.NewReader(s)).Read()
+}
+
+// reloadConfig re-reads the config file, then resets each flag not given on
+// the command line to the environment, the config file or else its default.
+func reloadConfig(fs *pflag.FlagSet) error {
+	if err := readConfig(); err != nil {
+		return err
+	}
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == flagConfig {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			err = sv.Replace(defaultSlice(f.DefValue))
+		} else {
+			err = f.Value.Set(f.DefValue)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return applyConfig(fs)
+}
+
+// defaultSlice parses the default value of a list flag, eg: "[1m0s,5m0s]".
+func defaultSlice(def string) []string {
+	def = strings.TrimSuffix(strings.TrimPrefix(def, "["), "]")
+	if def == "" {
+		return nil
+	}
+	return strings.Split(def, ",")
+}
+
+// writeConfig writes the effective value of each flag as YAML, which may be
+// used as a config file.
+func writeConfig(w io.Writer, fs *pflag.FlagSet) error {
+	flags := make([]*pflag.Flag, 0)
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Name != flagConfig && f.Name != "help" {
+			flags = append(flags, f)
+		}
+	})
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	for _, f := range flags {
+		var err error
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			values := sv.GetSlice()
+			if len(values) == 0 {
+				_, err = fmt.Fprintf(w, "%s: []\n", f.Name)
+			} else {
+				_, err = fmt.Fprintf(w, "%s:\n", f.Name)
+				for _, v := range values {
+					if err == nil {
+						_, err = fmt.Fprintf(w, "  - %q\n", v)
+					}
+				}
+			}
+		} else {
+			switch f.Value.Type() {
+			case "bool", "int", "float64":
+				_, err = fmt.Fprintf(w, "%s: %s\n", f.Name, f.Value)
+			default:
+				_, err = fmt.Fprintf(w, "%s: %q\n", f.Name, f.Value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect Banken's configuration.",
+}
+
+var configPrint = &cobra.Command{
+	Use:   "print [command]",
+	Short: "Print the effective configuration of a command, monitor by default.",
+	Long: `Banken 番犬(watchdog) config print shows the settings a command would run with, as YAML which may be used as a config file.
+
+	Settings are read from the --config file, or config.yaml (or .toml, .json) in ~/.config/banken, keyed by flag name, eg: 'alert-threshold: 20'. Environment variables prefixed BANKEN_ override the config file, eg: BANKEN_ALERT_THRESHOLD=20, and flags given on the command line override both.
+	`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		target := monitor
+		if len(args) > 0 {
+			c, _, err := rootCmd.Find(args)
+			if err != nil || c == rootCmd {
+				return fmt.Errorf("unknown command %q", args[0])
+			}
+			target = c
+		}
+		// Merge the root's persistent flags into the target's flags.
+		if err := target.ParseFlags(nil); err != nil {
+			return err
+		}
+		if err := applyConfig(target.Flags()); err != nil {
+			return err
+		}
+		if f := config.ConfigFileUsed(); f != "" {
+			fmt.Printf("# %s\n", f)
+		}
+		return writeConfig(os.Stdout, target.Flags())
+	},
+}
@@ -2,13 +2,23 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/gopacket/layers"
 	"github.com/ropes/banken/cmd/banken/cmd"
+	"github.com/ropes/banken/pkg/notify"
+	"github.com/ropes/banken/pkg/sniff"
+	"github.com/ropes/banken/pkg/traffic"
 	"github.com/ropes/banken/pkg/view"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"golang.org/x/sys/unix"
 )
 
@@ -17,7 +27,43 @@ const (
 	flagLogSink     = "log-sink"
 	flagBPF         = "bpf"
 	flagTopReqs     = "top-n-reqs"
+	flagTopWindow   = "top-window"
 	flagAlertThresh = "alert-threshold"
+	flagReadFile    = "read-file"
+	flagReplaySpeed = "replay-speed"
+	flagDuration    = "duration"
+	flagFormat      = "format"
+	flagMetricsAddr = "metrics-addr"
+	flagAlertRule   = "alert-rule"
+	flagAlertWindow = "alert-window"
+	flagAlertEval   = "alert-interval"
+	flagAlertFlush  = "alert-flush"
+	flagAlertRecov  = "alert-recover"
+	flagAlertDwell  = "alert-dwell"
+	flagAlertMode   = "alert-mode"
+	flagAlertBase   = "alert-baseline"
+	flagAlertDev    = "alert-deviation"
+	flagNotifyHook  = "notify-webhook"
+	flagNotifySlack = "notify-slack"
+	flagNotifyCmd   = "notify-command"
+	flagNotifySys   = "notify-syslog"
+	flagNotifyRetry = "notify-retries"
+	flagNotifyDedup = "notify-dedup"
+	flagLinkType    = "link-type"
+	flagIntervals   = "intervals"
+	flagIface       = "iface"
+	flagIfaceExcl   = "iface-exclude"
+	flagIfaceUp     = "iface-up"
+	flagIfaceFamily = "iface-family"
+	flagIfaceWatch  = "iface-watch"
+	flagStateFile   = "state-file"
+	flagCountCap    = "count-capacity"
+	flagCountErr    = "count-error"
+	flagStateIntvl  = "state-interval"
+	flagURLDepth    = "url-depth"
+	flagURLHost     = "url-host-depth"
+	flagURLTmpl     = "url-template"
+	flagURLIDs      = "url-collapse-ids"
 )
 
 var (
@@ -26,18 +72,211 @@ var (
 	logSink        string
 	alertThreshold int
 	topNReqs       int
+	topWindow      time.Duration
+	readFile       string
+	replaySpeed    float64
+	reportDuration time.Duration
+	reportFormat   string
+	reportSpeed    float64
+	reportTop      time.Duration
+	metricsAddr    string
+	alertRules     []string
+	alertWindow    time.Duration
+	alertInterval  time.Duration
+	alertFlush     time.Duration
+	alertRecover   int
+	alertDwell     time.Duration
+	alertMode      string
+	alertBaseline  time.Duration
+	alertDeviation float64
+	notifyHooks    []string
+	notifySlack    []string
+	notifyCommands []string
+	notifySyslog   bool
+	notifyRetries  int
+	notifyDedup    time.Duration
+	checkLinkTypes []string
+	intervals      []time.Duration
+	ifaceInclude   []string
+	ifaceExclude   []string
+	ifaceUp        bool
+	ifaceFamily    string
+	ifaceWatch     time.Duration
+	stateFile      string
+	countCapacity  int
+	countError     float64
+	stateInterval  time.Duration
+	urlDepth       int
+	urlHostDepths  []string
+	urlTemplates   []string
+	urlCollapseIDs bool
 )
 
 func init() {
 	// Cobra configuration
 	rootCmd.PersistentFlags().StringVarP(&logLevel, flagLogLevel, "l", "info", "log verbosity level")
 	rootCmd.PersistentFlags().StringVarP(&logSink, flagLogSink, "s", "/tmp/banken.log", "logging destination, leave blank to disable")
+	rootCmd.PersistentFlags().StringVar(&configFile, flagConfig, "", "config file of flag settings, default config.yaml in ~/.config/banken")
 
 	monitor.PersistentFlags().StringVarP(&bpf, flagBPF, "b", "tcp port 80", "BPF configuration string")
-	monitor.PersistentFlags().IntVarP(&alertThreshold, flagAlertThresh, "a", 10, "alerting threshold of http requests per 2 minute span ")
+	monitor.PersistentFlags().IntVarP(&alertThreshold, flagAlertThresh, "a", 10, "alerting threshold of http requests per --alert-window span")
+	addAlertFlags(monitor.PersistentFlags())
+	addNotifyFlags(monitor.PersistentFlags())
+	addIfaceFlags(monitor.PersistentFlags())
+	addCountFlags(monitor.PersistentFlags())
+	addURLFlags(monitor.PersistentFlags())
+	monitor.PersistentFlags().StringArrayVar(&alertRules, flagAlertRule, nil, alertRuleUsage)
 	monitor.PersistentFlags().IntVarP(&topNReqs, flagTopReqs, "t", 10, "top number of URL:RequestCounts to display")
+	monitor.PersistentFlags().DurationVar(&topWindow, flagTopWindow, 5*time.Minute, "trailing window top URLs are ranked over, up to 1h, 0 ranks by requests since start")
+	monitor.PersistentFlags().DurationSliceVar(&intervals, flagIntervals, cmd.DefaultIntervals(), "timespans request counts are logged over")
+	monitor.PersistentFlags().StringVarP(&readFile, flagReadFile, "r", "", "replay packets from a .pcap/.pcapng file instead of the local interfaces")
+	monitor.PersistentFlags().Float64Var(&replaySpeed, flagReplaySpeed, 1, "replay rate multiplier of the --read-file capture timing, 0 replays as fast as possible")
+	monitor.PersistentFlags().StringVar(&metricsAddr, flagMetricsAddr, "", "serve Prometheus metrics on this address, eg: ':9100', leave blank to disable")
+	monitor.PersistentFlags().StringVar(&stateFile, flagStateFile, "", "save the traffic recorded to this file and restore it on start, leave blank to disable")
+	monitor.PersistentFlags().DurationVar(&stateInterval, flagStateIntvl, time.Minute, "how often the traffic recorded is saved to the --state-file")
+
+	report.Flags().StringVarP(&bpf, flagBPF, "b", "tcp port 80", "BPF configuration string")
+	report.Flags().IntVarP(&alertThreshold, flagAlertThresh, "a", 10, "alerting threshold of http requests per --alert-window span")
+	addAlertFlags(report.Flags())
+	addNotifyFlags(report.Flags())
+	addIfaceFlags(report.Flags())
+	addCountFlags(report.Flags())
+	addURLFlags(report.Flags())
+	report.Flags().StringArrayVar(&alertRules, flagAlertRule, nil, alertRuleUsage)
+	report.Flags().IntVarP(&topNReqs, flagTopReqs, "t", 10, "top number of URL:RequestCounts to report")
+	report.Flags().DurationVar(&reportTop, flagTopWindow, 0, "trailing window top URLs are ranked over, up to 1h, 0 ranks by requests since start")
+	report.Flags().DurationSliceVar(&intervals, flagIntervals, cmd.DefaultIntervals(), "timespans request counts and latencies are reported over")
+	report.Flags().StringVarP(&readFile, flagReadFile, "r", "", "summarise packets from a .pcap/.pcapng file instead of the local interfaces")
+	report.Flags().Float64Var(&reportSpeed, flagReplaySpeed, 0, "replay rate multiplier of the --read-file capture timing, 0 replays as fast as possible")
+	report.Flags().DurationVarP(&reportDuration, flagDuration, "d", time.Minute, "length of the live capture window when no --read-file is given")
+	report.Flags().StringVarP(&reportFormat, flagFormat, "f", cmd.FormatText, "report output format: text, json or csv")
+
+	bpfCheck.Flags().StringVarP(&bpf, flagBPF, "b", "tcp port 80", "BPF configuration string, when no expression argument is given")
+	bpfCheck.Flags().StringVarP(&readFile, flagReadFile, "r", "", "compile for the link type of this .pcap/.pcapng file")
+	bpfCheck.Flags().StringArrayVar(&checkLinkTypes, flagLinkType, nil, fmt.Sprintf("compile for this link type, repeatable, one of: %s (default all)", strings.Join(sniff.LinkTypes(), ", ")))
+}
+
+// addAlertFlags registers the mode, window, evaluation and hysteresis flags
+// of the --alert-threshold rule.
+func addAlertFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&alertMode, flagAlertMode, string(traffic.ModeThreshold), "alert when requests exceed the --alert-threshold, or 'anomaly' to alert when requests deviate above the learnt baseline")
+	fs.DurationVar(&alertBaseline, flagAlertBase, 24*time.Hour, "history of --alert-window spans the anomaly mode baseline is learnt from")
+	fs.Float64Var(&alertDeviation, flagAlertDev, 3, "standard deviations above the baseline at which the anomaly mode alerts")
+	fs.DurationVar(&alertWindow, flagAlertWindow, 2*time.Minute, "trailing timespan the --alert-threshold request count is measured over")
+	fs.DurationVar(&alertInterval, flagAlertEval, 2*time.Second, "how often the alert threshold is evaluated")
+	fs.DurationVar(&alertFlush, flagAlertFlush, 2*time.Second, "how often counted requests are recorded for alerting")
+	fs.IntVar(&alertRecover, flagAlertRecov, 0, "request count per --alert-window below which an alert recovers, 0 recovers below --alert-threshold")
+	fs.DurationVar(&alertDwell, flagAlertDwell, 0, "minimum time between alert state changes, so alerts do not flap")
+}
+
+// addNotifyFlags registers the external alert notification flags.
+func addNotifyFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&notifyHooks, flagNotifyHook, nil, "POST alert state changes as JSON to this URL, repeatable")
+	fs.StringArrayVar(&notifySlack, flagNotifySlack, nil, "post alert messages to this Slack compatible incoming webhook URL, repeatable")
+	fs.StringArrayVar(&notifyCommands, flagNotifyCmd, nil, "run this shell command on alert state changes with BANKEN_RULE, BANKEN_STATE, BANKEN_MESSAGE and BANKEN_TIME set, repeatable")
+	fs.BoolVar(&notifySyslog, flagNotifySys, false, "write alert state changes to the local syslog")
+	fs.IntVar(&notifyRetries, flagNotifyRetry, 3, "retries of a failed notification, with exponential backoff from 1s")
+	fs.DurationVar(&notifyDedup, flagNotifyDedup, 5*time.Minute, "suppress repeated notifications of a rule's state within this duration")
+}
+
+// addIfaceFlags registers the flags selecting the interfaces to capture from.
+func addIfaceFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&ifaceInclude, flagIface, nil, "capture from interfaces matching this glob pattern, eg: 'eth*', repeatable, all interfaces when unset")
+	fs.StringArrayVar(&ifaceExclude, flagIfaceExcl, nil, "do not capture from interfaces matching this glob pattern, eg: 'lo', repeatable")
+	fs.BoolVar(&ifaceUp, flagIfaceUp, false, "only capture from interfaces which are up and running, rather than retrying down interfaces until they come up")
+	fs.StringVar(&ifaceFamily, flagIfaceFamily, sniff.FamilyAny, "only capture from interfaces with an 'ipv4' or 'ipv6' address, leave blank for any")
+	fs.DurationVar(&ifaceWatch, flagIfaceWatch, 5*time.Second, "how often to look for interfaces appearing or disappearing, 0 captures from the interfaces found at startup only")
+}
+
+// addCountFlags registers the flags bounding the memory of request counts.
+func addCountFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&countCapacity, flagCountCap, cmd.DefaultCountCapacity, "sections and hosts counted, the most requested approximately in bounded memory, 0 counts every one exactly")
+	fs.Float64Var(&countError, flagCountErr, 0, "fraction of requests counts may overestimate by, eg: 0.001, overriding --count-capacity")
+}
+
+// configureCounting applies the --count flags to Banken.
+func configureCounting(b *cmd.Banken) error {
+	capacity := countCapacity
+	if countError != 0 {
+		if countError < 0 || countError >= 1 {
+			return fmt.Errorf("count error %g must be between 0 and 1", countError)
+		}
+		capacity = traffic.TopKCapacity(countError)
+	}
+	return b.CountCapacity(capacity)
+}
+
+// addURLFlags registers the flags aggregating request URLs into sections.
+func addURLFlags(fs *pflag.FlagSet) {
+	d := cmd.DefaultURLAggregation()
+	fs.IntVar(&urlDepth, flagURLDepth, d.Depth, "path segments URLs are counted to, eg: 2 counts /api/v1/users under /api/v1")
+	fs.StringArrayVar(&urlHostDepths, flagURLHost, nil, "--url-depth of a host, as host=depth, repeatable, eg: api.rusutsu.com=3")
+	fs.StringArrayVar(&urlTemplates, flagURLTmpl, nil, "collapse matching path segments into placeholders, repeatable, eg: '/users/{id}' or '/orders/{order:[A-Z]+-[0-9]+}'")
+	fs.BoolVar(&urlCollapseIDs, flagURLIDs, d.CollapseIDs, "collapse numeric IDs, UUIDs and hex hash path segments into {id}, {uuid} and {hash}")
+}
+
+// configureURLs applies the --url flags to Banken.
+func configureURLs(b *cmd.Banken) error {
+	hostDepths := make(map[string]int, len(urlHostDepths))
+	for _, hd := range urlHostDepths {
+		i := strings.LastIndex(hd, "=")
+		if i < 0 {
+			return fmt.Errorf("url host depth %q must be host=depth", hd)
+		}
+		d, err := strconv.Atoi(hd[i+1:])
+		if err != nil {
+			return fmt.Errorf("url host depth %q must be host=depth: %w", hd, err)
+		}
+		hostDepths[hd[:i]] = d
+	}
+	return b.AggregateURLs(cmd.URLAggregation{
+		Depth:       urlDepth,
+		HostDepths:  hostDepths,
+		Templates:   urlTemplates,
+		CollapseIDs: urlCollapseIDs,
+	})
+}
+
+// configureInterfaces applies the --iface flags to Banken.
+func configureInterfaces(b *cmd.Banken) error {
+	return b.Interfaces(sniff.InterfaceFilter{
+		Include: ifaceInclude,
+		Exclude: ifaceExclude,
+		Up:      ifaceUp,
+		Family:  ifaceFamily,
+	}, ifaceWatch)
+}
+
+// configureNotifiers builds the external alert notifiers from the --notify
+// flags.
+func configureNotifiers(b *cmd.Banken) error {
+	var notifiers []notify.Notifier
+	for _, u := range notifyHooks {
+		notifiers = append(notifiers, notify.NewWebhook(u))
+	}
+	for _, u := range notifySlack {
+		notifiers = append(notifiers, notify.NewSlackWebhook(u))
+	}
+	for _, c := range notifyCommands {
+		notifiers = append(notifiers, notify.NewCommand(c))
+	}
+	if notifySyslog {
+		s, err := notify.NewSyslog("banken")
+		if err != nil {
+			return fmt.Errorf("unable to connect to syslog: %w", err)
+		}
+		notifiers = append(notifiers, s)
+	}
+	b.Notifiers(notify.Options{
+		Retries: notifyRetries,
+		Backoff: time.Second,
+		Dedup:   notifyDedup,
+	}, notifiers...)
+	return nil
 }
 
+const alertRuleUsage = "additional named alert rule, repeatable, eg: 'name=ski,metric=requests,scope=section,key=http://rusutsu.com/ski,window=1m,threshold=100,recover=80'"
+
 var rootCmd = &cobra.Command{
 	Use:   "banken",
 	Short: "Banken 番犬(watchdog) HTTP traffic monitor for unix systems",
@@ -45,6 +284,12 @@ var rootCmd = &cobra.Command{
 	
 	Monitors HTTP traffic for unix systems, utilizing libpcap to read network traffic from local interfaces and parse HTTP requests(currently).
 	`,
+	PersistentPreRunE: func(cobraCmd *cobra.Command, args []string) error {
+		if err := readConfig(); err != nil {
+			return err
+		}
+		return applyConfig(cobraCmd.Flags())
+	},
 }
 
 var monitor = &cobra.Command{
@@ -52,16 +297,50 @@ var monitor = &cobra.Command{
 	Short: "Monitor http traffic request destinations, counts, and notify when requests exceed alert threshold.",
 	Long: `Banken 番犬(watchdog) monitors HTTP network traffic from local interfaces and analyses request sources and throughput. 
 	
-	Terminal UI provides statistics on traffic counts over time, and top -t (default 10) URLs requested, to the first /section/. Alerts when the HTTP traffic rate surpasses the --alert-threshold per 2 minute timespan.
+	Terminal UI provides statistics on traffic counts over time, and top -t (default 10) URLs requested, to the first /section/. Alerts when the HTTP traffic rate surpasses the --alert-threshold per --alert-window timespan (default 2 minutes), evaluated every --alert-interval. The alert recovers once the rate drops below --alert-recover, and --alert-dwell holds each state for a minimum time so alerts do not flap when traffic hovers around the limit.
+
+	The top URLs are ranked by their requests over the trailing --top-window (default 5 minutes), so they show what is busy now rather than since start; press 'w' to cycle between the last minute, 5 minutes, hour and since start.
+
+	A chart plots the requests per span, with the --alert-threshold scaled to a span drawn as a red reference line; press 'v' to cycle between the last 10 minutes per 10 seconds, the last hour per minute and the last day per hour.
+
+	Press tab to select a row of the top URLs and enter to drill down into its section: a detail pane lists the full paths requested under it, their methods and client addresses, and charts the section's requests per minute over the last hour. Press esc to go back.
+
+	Press '/' to filter the top URLs, request counts and chart to the requests matching the terms typed: host and path substrings, eg: 'rusutsu.com/ski', regular expressions prefixed by '~', and method=GET,POST. Matching requests are recorded from when the filter is applied, which the title bar shows until esc clears it. The report and metrics are not filtered.
+
+	For traffic with a daily rhythm --alert-mode anomaly learns a baseline from the preceding --alert-baseline of windows, an exponentially weighted moving average and standard deviation, and alerts when requests rise more than --alert-deviation standard deviations above it. --alert-threshold is then the minimum request count which may alert. Longer histories are only retained in coarser buckets, eg: hours, which are sampled whole with their deviation scaled to the window.
+
+	Additional rules are configured with --alert-rule, each alerting independently when its metric (requests count, error-ratio, or response bytes per second) over its window exceeds the threshold, for total traffic or a single host or section. An alerted rule recovers once the metric drops below its recover value, which defaults to the threshold.
+
+	Alert state changes can page someone when the terminal isn't watched: --notify-webhook posts JSON to a URL, --notify-slack posts to a Slack incoming webhook, --notify-command runs a shell command with the alert in its environment, and --notify-syslog writes to syslog. Failed deliveries are retried --notify-retries times with backoff, and repeats of a rule's state within --notify-dedup are suppressed, so a flapping rule notifies once.
+
+	When both directions of a connection are captured, responses are paired with their requests and the top URLs also show the rate of 4xx/5xx responses and the average response latency.
+
+	Requests are counted per section and host in bounded memory, so traffic to millions of distinct URLs, eg: a crawler, cannot exhaust it: the --count-capacity most requested keys are counted, and their counts overestimate by at most the total requests divided by the capacity. --count-error sets the capacity from the fraction of requests counts may overestimate by, and --count-capacity 0 counts every key exactly.
+
+	The bytes of every TCP flow matching the BPF are totalled in each direction per remote host, server port and HTTP section; the remote hosts which transferred the most over the last 5 minutes are displayed as top talkers.
 
 	HTTP request URL paths are truncated to their first section. eg: 'http://man7.org/linux/man-pages/man1/intro.1.html' is truncated and counted as 'http://man7.org/linux'. A URL to file on first path variable gets counted as a root request. eg: 'http://man7.org/style.css' will be counted to increment 'http://man7.org/'.
 
+	--url-depth counts deeper sections, eg: 2 counts '/api/v1/users' under '/api/v1', and --url-host-depth sets it per host. Variable path segments are collapsed before counting: numeric IDs, UUIDs and hex hashes into {id}, {uuid} and {hash} with --url-collapse-ids, and the placeholders of --url-template paths, eg: '/users/{id}' or '/orders/{order:[A-Z]+-[0-9]+}'. Hosts are compared case-insensitively, and each path segment is percent-decoded, so '%2F' does not split a segment, with duplicate slashes ignored. The trailing slash matters, as the last segment is a file unless followed by one: '/api/v1/' is counted under '/api/v1' but '/api/v1' under '/api'.
+
 	If enabled by --log-sink and --log-level, logs are written periodically recording all of the information rendered in the terminal UI. Set --log-sink to empty string, to flush logs into
 	/dev/null.
 
-	Using Berkley Packet Filtering; by default only port 80 is monitored for HTTP packets. However that can be configured by supplying a different BPF via --bpf.
+	Using Berkley Packet Filtering; by default only port 80 is monitored for HTTP packets. However that can be configured by supplying a different BPF via --bpf, eg: 'tcp port 80 or tcp port 443' also counts HTTPS connections per TLS server name (SNI) as 'https://<server>/'.
+
+	Interfaces to capture from are selected with --iface and --iface-exclude glob patterns, eg: --iface 'eth*' --iface-exclude lo; --iface-up skips interfaces which are down, and --iface-family restricts them to those with an ipv4 or ipv6 address. Every --iface-watch the interfaces are listed again, so captures start on interfaces which appear later, eg: containers or VPN tunnels, and stop on those which disappear.
+
+	Interfaces which cannot be opened, eg: a tunnel which is down, are shown as failed in the capture status panel and retried with backoff until they come back. Banken only exits when no interface can be opened.
 
-	Press 'q' to exit.
+	Captures taken elsewhere can be analysed by replaying them with --read-file. Statistics and alerts are then computed on the packet timestamps, replayed at the original rate by default; --replay-speed 10 replays ten times faster and 0 as fast as possible.
+
+	Flags may also be set by a config file, --config or ~/.config/banken/config.yaml, keyed by flag name, and overridden by BANKEN_ prefixed environment variables, eg: BANKEN_ALERT_THRESHOLD=20, whose lists are comma separated as on the command line, eg: BANKEN_INTERVALS=1m,1h; 'banken config print' shows the effective settings.
+
+	With --state-file the request counts and timeseries are saved every --state-interval and on exit, then restored on start, so a restart does not lose the recorded traffic or the history anomaly alerts learn from. Timespans older than the timeseries retain are discarded when restored.
+
+	Sending SIGHUP reloads the config file, reopening the --log-sink, eg: after logrotate, and applying changes of --log-level, --top-n-reqs, --top-window, --bpf and the alert flags and rules without losing the traffic recorded.
+
+	The panels are laid out again when the terminal is resized, shrinking the top panels to keep the alerts list visible; terminals smaller than 60x20 show a notice to enlarge them. Press 'q' to exit.
 	`,
 	Run: func(cobraCmd *cobra.Command, args []string) {
 		logger := logSetup()
@@ -69,36 +348,289 @@ var monitor = &cobra.Command{
 		// Catch shutdown signals
 		runCtx, can := context.WithCancel(context.Background())
 		defer can()
-		catchCancelSignal(can, unix.SIGINT, unix.SIGHUP, unix.SIGTERM, unix.SIGQUIT)
+		catchCancelSignal(can, unix.SIGINT, unix.SIGTERM, unix.SIGQUIT)
 
 		banken := cmd.NewBanken(runCtx, alertThreshold, topNReqs, bpf, logger)
+		if readFile != "" {
+			banken.ReplayFile(readFile, replaySpeed)
+		}
+		if err := configureRules(banken); err != nil {
+			logger.Fatal(err)
+		}
+		if err := banken.Intervals(intervals); err != nil {
+			logger.Fatal(err)
+		}
+		if err := banken.TopWindow(topWindow); err != nil {
+			logger.Fatal(err)
+		}
+		if err := configureNotifiers(banken); err != nil {
+			logger.Fatal(err)
+		}
+		if err := configureInterfaces(banken); err != nil {
+			logger.Fatal(err)
+		}
+		if err := configureCounting(banken); err != nil {
+			logger.Fatal(err)
+		}
+		if err := configureURLs(banken); err != nil {
+			logger.Fatal(err)
+		}
+		if err := banken.PersistState(stateFile, stateInterval); err != nil {
+			logger.Fatal(err)
+		}
+		// Reject an invalid filter while the terminal can still show why.
+		if err := banken.CheckBPF(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			logger.Fatal(err)
+		}
+		defer banken.Close()
+
+		// Bind the metrics listener before the UI takes over the terminal.
+		var metricsListener net.Listener
+		if metricsAddr != "" {
+			var err error
+			metricsListener, err = net.Listen("tcp", metricsAddr)
+			if err != nil {
+				logger.Fatalf("unable to listen for metrics on %q: %v", metricsAddr, err)
+			}
+		}
 
 		// Initialize View and Banken data models
-		topN, reqCnts, alerts := view.Init(runCtx, topNReqs)
-		ifaces, packets, err := banken.Init(topN, reqCnts, alerts)
+		topN, reqCnts, talkers, captures, alerts, chart, detail := view.Init(runCtx, topNReqs, cmd.TalkersN)
+		ifaces, packets, err := banken.Init(topN, reqCnts, talkers, captures, alerts, chart, detail)
 		if err != nil {
 			can()
 			logger.Fatal(err)
 		}
 
+		if metricsListener != nil {
+			banken.ServeMetrics(metricsListener)
+		}
+
+		// SIGHUP reloads the configuration, keeping the traffic recorded.
+		catchReloadSignal(runCtx, func() {
+			if err := reload(cobraCmd, banken, logger); err != nil {
+				logger.Errorf("configuration reload failed: %v", err)
+			}
+		}, unix.SIGHUP)
+
 		go func() {
-			view.Run(can, topN, reqCnts, alerts)
+			keys := map[string]func(){
+				"w": banken.NextTopWindow,
+				"v": banken.NextChartView,
+			}
+			view.Run(can, keys, banken, banken, topN, reqCnts, talkers, captures, alerts, chart, detail)
 		}()
-		banken.Run(ifaces, packets)
+		if err := banken.Run(ifaces, packets); err != nil {
+			// Capture failed, give the terminal back to report why.
+			can()
+			view.Close()
+			fmt.Fprintln(os.Stderr, err)
+			logger.Fatal(err)
+		}
+		// Replays finish before the user quits, keep displaying the results.
+		<-runCtx.Done()
 	},
 }
 
+var report = &cobra.Command{
+	Use:   "report",
+	Short: "Summarise http traffic from a capture file or a live window without the terminal UI.",
+	Long: `Banken 番犬(watchdog) report runs the same analysis as monitor without a terminal UI, then prints a summary of the traffic to stdout.
+
+	Traffic is read from a capture file given by --read-file, or from the local interfaces for the --duration window. The summary lists the top -t URLs requested, over the trailing --top-window or else the whole capture, request counts per timespan, requests per host and method, and the history of alerts raised by the --alert-threshold.
+
+	--format selects plain text, json or csv output for consumption by scripts and cron jobs.
+	`,
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		switch reportFormat {
+		case cmd.FormatText, cmd.FormatJSON, cmd.FormatCSV:
+		default:
+			return fmt.Errorf("unknown report format %q", reportFormat)
+		}
+		logger := logSetup()
+
+		// Catch shutdown signals, ending a live capture window early.
+		runCtx, can := context.WithCancel(context.Background())
+		defer can()
+		catchCancelSignal(can, unix.SIGINT, unix.SIGHUP, unix.SIGTERM, unix.SIGQUIT)
+		if readFile == "" {
+			runCtx, can = context.WithTimeout(runCtx, reportDuration)
+			defer can()
+		}
+
+		banken := cmd.NewBanken(runCtx, alertThreshold, topNReqs, bpf, logger)
+		if readFile != "" {
+			banken.ReplayFile(readFile, reportSpeed)
+		}
+		if err := configureRules(banken); err != nil {
+			return err
+		}
+		if err := banken.Intervals(intervals); err != nil {
+			return err
+		}
+		if err := banken.TopWindow(reportTop); err != nil {
+			return err
+		}
+		if err := configureNotifiers(banken); err != nil {
+			return err
+		}
+		if err := configureInterfaces(banken); err != nil {
+			return err
+		}
+		if err := configureCounting(banken); err != nil {
+			return err
+		}
+		if err := configureURLs(banken); err != nil {
+			return err
+		}
+		if err := banken.CheckBPF(); err != nil {
+			return err
+		}
+		defer banken.Close()
+		ifaces, packets, err := banken.Init(nil, nil, nil, nil, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := banken.Run(ifaces, packets); err != nil {
+			return err
+		}
+
+		return banken.Report().Write(os.Stdout, reportFormat)
+	},
+}
+
+var bpfCheck = &cobra.Command{
+	Use:   "bpf-check [expression]",
+	Short: "Validate a BPF expression and print the compiled program.",
+	Long: `Banken 番犬(watchdog) bpf-check compiles a BPF expression, given as arguments or by --bpf, as libpcap would when capturing, then prints the program for each link type in the format of 'tcpdump -dd'.
+
+	The expression is compiled for the link types of the interfaces commonly captured from, or those given by --link-type, or the link type of the --read-file capture. It is invalid when it compiles for none of them; link types it fails to compile for are listed with the reason.
+	`,
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		expr := bpf
+		if len(args) > 0 {
+			expr = strings.Join(args, " ")
+		}
+		var lts []layers.LinkType
+		for _, name := range checkLinkTypes {
+			lt, err := sniff.ParseLinkType(name)
+			if err != nil {
+				return err
+			}
+			lts = append(lts, lt)
+		}
+		if readFile != "" {
+			lt, err := sniff.FileLinkType(readFile)
+			if err != nil {
+				return err
+			}
+			lts = append(lts, lt)
+		}
+
+		programs, err := sniff.ValidateFilter(expr, cmd.SnapLen, lts...)
+		if err != nil {
+			return err
+		}
+		for _, p := range programs {
+			if p.Err != nil {
+				fmt.Printf("// %s: %v\n", p.LinkType, p.Err)
+				continue
+			}
+			fmt.Printf("// %s\n", p.LinkType)
+			if err := sniff.WriteProgram(os.Stdout, p.Instructions); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// configureRules applies the --alert-threshold flags and parses the
+// --alert-rule flags into Banken's alert rules.
+func configureRules(b *cmd.Banken) error {
+	threshold, rules, err := alertSettings()
+	if err != nil {
+		return err
+	}
+	if err := b.AlertThreshold(threshold); err != nil {
+		return err
+	}
+	return b.AlertRules(rules)
+}
+
+// alertSettings builds the --alert-threshold rule and parses the
+// --alert-rule flags.
+func alertSettings() (traffic.Rule, []traffic.Rule, error) {
+	threshold := traffic.Rule{
+		Mode:      traffic.Mode(alertMode),
+		Metric:    traffic.MetricRequests,
+		Scope:     traffic.ScopeTotal,
+		Window:    alertWindow,
+		Threshold: float64(alertThreshold),
+		Recover:   float64(alertRecover),
+		Interval:  alertInterval,
+		Flush:     alertFlush,
+		Dwell:     alertDwell,
+		Baseline:  alertBaseline,
+		Deviation: alertDeviation,
+	}
+	rules := make([]traffic.Rule, 0, len(alertRules))
+	for _, s := range alertRules {
+		r, err := traffic.ParseRule(s)
+		if err != nil {
+			return threshold, nil, fmt.Errorf("invalid --%s: %w", flagAlertRule, err)
+		}
+		rules = append(rules, r)
+	}
+	return threshold, rules, nil
+}
+
+// reload re-reads the config file into the command's flags, then applies
+// the settings which may change while monitoring: the log sink and level,
+// top-n, top window, BPF and alert rules.
+func reload(cobraCmd *cobra.Command, b *cmd.Banken, logger *log.Logger) error {
+	if err := reloadConfig(cobraCmd.Flags()); err != nil {
+		return err
+	}
+	if err := logOutput(logger); err != nil {
+		return err
+	}
+	threshold, rules, err := alertSettings()
+	if err != nil {
+		return err
+	}
+	return b.Reconfigure(cmd.Reconfig{
+		TopN:      topNReqs,
+		TopWindow: topWindow,
+		BPF:       bpf,
+		Threshold: threshold,
+		Rules:     rules,
+	})
+}
+
 func logSetup() *log.Logger {
 	// Initialize Logging
-	logLevelVal, err := log.ParseLevel(logLevel)
 	logger := log.New()
+	if err := logOutput(logger); err != nil {
+		logger.Fatal(err)
+	}
+	return logger
+}
+
+// logFile is the --log-sink file being logged to, closed when the sink is
+// reopened.
+var logFile *os.File
+
+// logOutput sets the logger's level and opens its --log-sink, eg: after
+// the log file was rotated.
+func logOutput(logger *log.Logger) error {
+	logLevelVal, err := log.ParseLevel(logLevel)
 	if err != nil {
-		logger.Fatalf("error parsing loglevel configuration: %v", err)
+		return fmt.Errorf("error parsing loglevel configuration: %w", err)
 	}
-	logger.SetLevel(logLevelVal)
+	var lf *os.File
 	if logSink != "" {
-		var lf *os.File
-		var err error
 		// stdout is used by termui, so can't log there
 		if logSink == "stderr" {
 			// stderr can be redirected with 2>>/tmp/logfile
@@ -106,18 +638,22 @@ func logSetup() *log.Logger {
 		} else {
 			lf, err = os.OpenFile(logSink, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 			if err != nil {
-				logger.Fatalf("unable to open %q for logging", logSink)
+				return fmt.Errorf("unable to open %q for logging", logSink)
 			}
 		}
-		logger.SetOutput(lf)
 	} else {
-		dn, err := os.OpenFile(os.DevNull, os.O_APPEND|os.O_WRONLY, 0644)
+		lf, err = os.OpenFile(os.DevNull, os.O_APPEND|os.O_WRONLY, 0644)
 		if err != nil {
-			logger.Fatalf("unable to open %q for logging", os.DevNull)
+			return fmt.Errorf("unable to open %q for logging", os.DevNull)
 		}
-		logger.SetOutput(dn)
 	}
-	return logger
+	logger.SetLevel(logLevelVal)
+	logger.SetOutput(lf)
+	if logFile != nil && logFile != os.Stderr {
+		logFile.Close()
+	}
+	logFile = lf
+	return nil
 }
 
 func catchCancelSignal(can context.CancelFunc, sig ...os.Signal) {
@@ -129,7 +665,31 @@ func catchCancelSignal(can context.CancelFunc, sig ...os.Signal) {
 	}()
 }
 
+// catchReloadSignal calls reload on each of the signals until the context
+// is closed.
+func catchReloadSignal(ctx context.Context, reload func(), sig ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c:
+				reload()
+			}
+		}
+	}()
+}
+
 func main() {
 	rootCmd.AddCommand(monitor)
-	rootCmd.Execute()
+	rootCmd.AddCommand(report)
+	rootCmd.AddCommand(bpfCheck)
+	configCmd.AddCommand(configPrint)
+	rootCmd.AddCommand(configCmd)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
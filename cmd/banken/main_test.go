@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestTopWindowDefaults(t *testing.T) {
+	if err := monitor.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	if topWindow != 5*time.Minute {
+		t.Errorf("monitor --%s defaults to %v, expected 5m", flagTopWindow, topWindow)
+	}
+	if err := report.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	if reportTop != 0 {
+		t.Errorf("report --%s defaults to %v, expected 0", flagTopWindow, reportTop)
+	}
+	if topWindow != 5*time.Minute {
+		t.Errorf("report flags reset monitor --%s to %v", flagTopWindow, topWindow)
+	}
+}
+
+func TestApplyConfigEnvSlices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banken-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile = filepath.Join(dir, "config.yaml")
+	defer func() { configFile = "" }()
+	if err := ioutil.WriteFile(configFile, []byte("url-template:\n  - /users/{id}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"BANKEN_INTERVALS":  "1m,10m",
+		"BANKEN_ALERT_RULE": "name=ski,threshold=10",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	if err := readConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	var durations []time.Duration
+	var rules, templates []string
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.DurationSliceVar(&durations, flagIntervals, nil, "")
+	fs.StringArrayVar(&rules, flagAlertRule, nil, "")
+	fs.StringArrayVar(&templates, flagURLTmpl, nil, "")
+	if err := applyConfig(fs); err != nil {
+		t.Fatal(err)
+	}
+	// Environment lists are split on commas, as on the command line, except
+	// for repeatable flags.
+	if exp := []time.Duration{time.Minute, 10 * time.Minute}; !reflect.DeepEqual(durations, exp) {
+		t.Errorf("intervals %v != %v", durations, exp)
+	}
+	if exp := []string{"name=ski,threshold=10"}; !reflect.DeepEqual(rules, exp) {
+		t.Errorf("alert rules %q != %q", rules, exp)
+	}
+	if exp := []string{"/users/{id}"}; !reflect.DeepEqual(templates, exp) {
+		t.Errorf("url templates %q != %q", templates, exp)
+	}
+}
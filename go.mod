@@ -7,7 +7,7 @@ require (
 	github.com/google/gopacket v1.1.17
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.6.2
 	golang.org/x/sys v0.0.0-20200217220822-9197077df867
 )
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+var _ (Notifier) = (*Webhook)(nil)
+var _ (Notifier) = (*Command)(nil)
+var _ (Notifier) = (*Syslog)(nil)
+
+// Webhook POSTs Events as JSON to a URL. The URL's path and query often
+// hold a secret token, eg: Slack's, so only its scheme and host are
+// described by String and errors.
+type Webhook struct {
+	url    string
+	slack  bool
+	client *http.Client
+}
+
+// NewWebhook posts the JSON encoded Event to rawURL.
+func NewWebhook(rawURL string) *Webhook {
+	return &Webhook{url: rawURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// NewSlackWebhook posts the Event's message to a Slack compatible incoming
+// webhook url.
+func NewSlackWebhook(url string) *Webhook {
+	w := NewWebhook(url)
+	w.slack = true
+	return w
+}
+
+// Notify posts the Event, failing on non 2xx responses.
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	var payload interface{} = e
+	if w.slack {
+		payload = struct {
+			Text string `json:"text"`
+		}{Text: e.Message}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", w, unwrapURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("post to %s: %w", w, unwrapURL(err))
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *Webhook) String() string {
+	target := "(invalid url)"
+	if u, err := url.Parse(w.url); err == nil {
+		target = u.Scheme + "://" + u.Host
+	}
+	if w.slack {
+		return fmt.Sprintf("slack webhook %s", target)
+	}
+	return fmt.Sprintf("webhook %s", target)
+}
+
+// unwrapURL strips the *url.Error from err, which would print the URL.
+func unwrapURL(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return uerr.Err
+	}
+	return err
+}
+
+// Command runs a local shell command for each Event. The Event is passed in
+// the BANKEN_RULE, BANKEN_STATE, BANKEN_MESSAGE, BANKEN_TIME, BANKEN_METRIC,
+// BANKEN_KEY, BANKEN_VALUE and BANKEN_THRESHOLD environment variables.
+type Command struct {
+	command string
+}
+
+// NewCommand runs command with `sh -c`.
+func NewCommand(command string) *Command {
+	return &Command{command: command}
+}
+
+// Notify runs the command, failing when it exits non-zero.
+func (c *Command) Notify(ctx context.Context, e Event) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.command)
+	cmd.Env = append(os.Environ(),
+		"BANKEN_RULE="+e.Rule,
+		"BANKEN_STATE="+e.State,
+		"BANKEN_MESSAGE="+e.Message,
+		"BANKEN_TIME="+e.Time.Format(time.RFC3339),
+		"BANKEN_METRIC="+e.Metric,
+		"BANKEN_KEY="+e.Key,
+		"BANKEN_VALUE="+strconv.FormatFloat(e.Value, 'f', -1, 64),
+		"BANKEN_THRESHOLD="+strconv.FormatFloat(e.Threshold, 'f', -1, 64),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (c *Command) String() string {
+	return fmt.Sprintf("command %q", c.command)
+}
+
+// Syslog writes Events to the local syslog daemon, alerts at warning
+// priority and recoveries at notice.
+type Syslog struct {
+	w *syslog.Writer
+}
+
+// NewSyslog connects to the local syslog daemon, tagging messages with tag.
+func NewSyslog(tag string) (*Syslog, error) {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{w: w}, nil
+}
+
+// Notify writes the Event's message.
+func (s *Syslog) Notify(ctx context.Context, e Event) error {
+	if e.State == StateAlert {
+		return s.w.Warning(e.Message)
+	}
+	return s.w.Notice(e.Message)
+}
+
+func (s *Syslog) String() string {
+	return "syslog"
+}
@@ -0,0 +1,171 @@
+// Package notify delivers alert state changes to external systems, so
+// Banken can page someone when the terminal isn't being watched.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Alert states of an Event.
+const (
+	StateAlert   = "alert"
+	StateNominal = "nominal"
+)
+
+// Event is a change of an alert rule's state.
+type Event struct {
+	// Rule is the name of the alert rule, empty for the --alert-threshold.
+	Rule    string    `json:"rule,omitempty"`
+	State   string    `json:"state"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+
+	// Metric the rule measures of the host or section Key, and the Value
+	// observed against the rule's Threshold.
+	Metric    string  `json:"metric,omitempty"`
+	Key       string  `json:"key,omitempty"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Notifier delivers an Event to an external system.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+	// String names the notifier's destination in logs.
+	String() string
+}
+
+// Options configure the delivery of Events by a Dispatcher.
+type Options struct {
+	// Retries is the number of times a failed delivery is retried.
+	Retries int
+	// Backoff is the delay before the first retry, doubling on each
+	// subsequent retry.
+	Backoff time.Duration
+	// Dedup suppresses an Event when the rule last sent the same State for
+	// the same Key within this duration, so a flapping rule notifies once.
+	Dedup time.Duration
+}
+
+// queueSize is the number of Events buffered per Notifier before new
+// Events are dropped.
+const queueSize = 64
+
+// Dispatcher fans Events out to its Notifiers. Each Notifier delivers from
+// its own queue, so a slow destination does not delay the others.
+type Dispatcher struct {
+	ctx    context.Context
+	logger *log.Logger
+	opts   Options
+
+	mux    sync.Mutex
+	closed bool
+	sent   map[dedupKey]time.Time // latest Event sent per rule, key and state
+	queues []chan Event
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher starts delivering Events to the notifiers until the context
+// is closed or Close is called.
+func NewDispatcher(ctx context.Context, logger *log.Logger, opts Options, notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{
+		ctx:    ctx,
+		logger: logger,
+		opts:   opts,
+		sent:   make(map[dedupKey]time.Time),
+	}
+	for _, n := range notifiers {
+		q := make(chan Event, queueSize)
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go d.deliver(n, q)
+	}
+	return d
+}
+
+// dedupKey identifies the Events that duplicate each other.
+type dedupKey struct {
+	rule, key, state string
+}
+
+// Send queues the Event for delivery to every Notifier, unless the rule sent
+// the same state within the Dedup duration.
+func (d *Dispatcher) Send(e Event) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.closed {
+		return
+	}
+	k := dedupKey{rule: e.Rule, key: e.Key, state: e.State}
+	if prev, ok := d.sent[k]; ok && e.Time.Sub(prev) < d.opts.Dedup {
+		d.logger.Debugf("notify: suppressed duplicate %s of rule %q", e.State, e.Rule)
+		return
+	}
+	d.sent[k] = e.Time
+	for _, q := range d.queues {
+		select {
+		case q <- e:
+		default:
+			d.logger.Warnf("notify: queue full, dropped %s of rule %q", e.State, e.Rule)
+		}
+	}
+}
+
+// Close stops accepting Events and waits for the queued Events to be
+// delivered, or abandoned when the context is closed.
+func (d *Dispatcher) Close() {
+	d.mux.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q)
+		}
+	}
+	d.mux.Unlock()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(n Notifier, queue chan Event) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case e, ok := <-queue:
+			if !ok {
+				return
+			}
+			if err := d.retry(n, e); err != nil {
+				d.logger.Errorf("notify: %s failed: %v", n, err)
+			}
+		}
+	}
+}
+
+// retry attempts delivery with exponential backoff between attempts.
+func (d *Dispatcher) retry(n Notifier, e Event) error {
+	backoff := d.opts.Backoff
+	var err error
+	for attempt := 0; attempt <= d.opts.Retries; attempt++ {
+		if attempt > 0 {
+			t := time.NewTimer(backoff)
+			select {
+			case <-d.ctx.Done():
+				t.Stop()
+				return d.ctx.Err()
+			case <-t.C:
+			}
+			backoff *= 2
+		}
+		if err = n.Notify(d.ctx, e); err == nil {
+			return nil
+		}
+		d.logger.Warnf("notify: %s attempt %d: %v", n, attempt+1, err)
+	}
+	return fmt.Errorf("gave up after %d attempts: %w", d.opts.Retries+1, err)
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func testLogger() *log.Logger {
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	return l
+}
+
+func TestDispatcherWebhooks(t *testing.T) {
+	var mux sync.Mutex
+	var generic []Event
+	var slack []string
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch r.URL.Path {
+		case "/generic":
+			// Fail the first deliveries to exercise retries.
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var e Event
+			if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+				t.Error(err)
+			}
+			generic = append(generic, e)
+		case "/slack":
+			var m map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				t.Error(err)
+			}
+			slack = append(slack, m["text"])
+		}
+	}))
+	defer srv.Close()
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	d := NewDispatcher(ctx, testLogger(), Options{Retries: 3, Backoff: time.Millisecond, Dedup: time.Minute},
+		NewWebhook(srv.URL+"/generic"), NewSlackWebhook(srv.URL+"/slack"))
+
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	d.Send(Event{Rule: "ski", State: StateAlert, Message: "ski is busy", Time: start})
+	d.Send(Event{Rule: "ski", State: StateNominal, Message: "ski is quiet", Time: start.Add(time.Second)})
+	// A flapping rule's repeated states within the dedup window are
+	// suppressed, while other keys and later repeats are not.
+	d.Send(Event{Rule: "ski", State: StateAlert, Message: "ski is busy again", Time: start.Add(2 * time.Second)})
+	d.Send(Event{Rule: "ski", State: StateNominal, Message: "ski is quiet again", Time: start.Add(3 * time.Second)})
+	d.Send(Event{Rule: "ski", Key: "lift", State: StateAlert, Message: "lift is busy", Time: start.Add(4 * time.Second)})
+	d.Send(Event{Rule: "ski", State: StateAlert, Message: "ski is busy later", Time: start.Add(2 * time.Minute)})
+	d.Close()
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(generic) != 4 || generic[0].Rule != "ski" || generic[0].State != StateAlert || !generic[0].Time.Equal(start) || generic[1].State != StateNominal {
+		t.Errorf("unexpected webhook events: %+v", generic)
+	}
+	if len(slack) != 4 || slack[0] != "ski is busy" || slack[1] != "ski is quiet" || slack[2] != "lift is busy" || slack[3] != "ski is busy later" {
+		t.Errorf("unexpected slack messages: %v", slack)
+	}
+
+	// Sending after Close is ignored.
+	d.Send(Event{Rule: "ski", State: StateAlert, Time: start.Add(time.Hour)})
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(context.Background(), testLogger(), Options{Retries: 2, Backoff: time.Millisecond}, NewWebhook(srv.URL))
+	d.Send(Event{State: StateAlert, Time: time.Now()})
+	d.Close()
+	if attempts != 3 {
+		t.Errorf("attempts %d != 3", attempts)
+	}
+}
+
+func TestWebhookRedacted(t *testing.T) {
+	secret := "/services/T000/B000/XXXXXXXX"
+	w := NewSlackWebhook("http://127.0.0.1:1" + secret)
+	if s := w.String(); strings.Contains(s, secret) || s != "slack webhook http://127.0.0.1:1" {
+		t.Errorf("webhook described as %q", s)
+	}
+	err := w.Notify(context.Background(), Event{State: StateAlert, Time: time.Now()})
+	if err == nil {
+		t.Fatal("post to a closed port succeeded")
+	}
+	if strings.Contains(err.Error(), secret) {
+		t.Errorf("webhook error reveals its path: %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banken-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	c := NewCommand(`echo "$BANKEN_RULE $BANKEN_STATE $BANKEN_TIME $BANKEN_KEY $BANKEN_VALUE/$BANKEN_THRESHOLD $BANKEN_MESSAGE" > ` + out)
+	e := Event{
+		Rule: "ski", State: StateAlert, Message: "ski is busy", Time: time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC),
+		Metric: "requests", Key: "http://rusutsu.com/ski", Value: 120, Threshold: 100.5,
+	}
+	if err := c.Notify(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimSpace(string(b)); s != "ski alert 2020-02-20T12:00:00Z http://rusutsu.com/ski 120/100.5 ski is busy" {
+		t.Errorf("unexpected command environment: %q", s)
+	}
+
+	if err := NewCommand("exit 3").Notify(context.Background(), e); err == nil {
+		t.Error("failing command should error")
+	}
+}
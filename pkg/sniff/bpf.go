@@ -0,0 +1,162 @@
+package sniff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// linkTypes names the link types of the interfaces commonly captured from,
+// which BPF expressions are validated against.
+var linkTypes = map[string]layers.LinkType{
+	"ethernet":  layers.LinkTypeEthernet,
+	"linux-sll": layers.LinkTypeLinuxSLL,
+	"raw":       layers.LinkTypeRaw,
+	"null":      layers.LinkTypeNull,
+}
+
+// LinkTypes lists the names of the link types accepted by ParseLinkType.
+func LinkTypes() []string {
+	names := make([]string, 0, len(linkTypes))
+	for name := range linkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseLinkType provides the link type of a name listed by LinkTypes, eg:
+// 'ethernet' for network cards or 'raw' for VPN tunnels.
+func ParseLinkType(name string) (layers.LinkType, error) {
+	lt, ok := linkTypes[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown link type %q, one of: %s", name, strings.Join(LinkTypes(), ", "))
+	}
+	return lt, nil
+}
+
+// FilterProgram is a BPF expression compiled for a link type, or the error
+// compiling it.
+type FilterProgram struct {
+	LinkType     layers.LinkType
+	Instructions []pcap.BPFInstruction
+	Err          error
+}
+
+// CompileFilter compiles the BPF expression for each link type, as it would
+// be when applied to a capture of that link type. Every link type listed by
+// LinkTypes is compiled for when none are given.
+func CompileFilter(expr string, snaplen int, lts ...layers.LinkType) []FilterProgram {
+	if len(lts) == 0 {
+		for _, name := range LinkTypes() {
+			lts = append(lts, linkTypes[name])
+		}
+	}
+	programs := make([]FilterProgram, 0, len(lts))
+	for _, lt := range lts {
+		insns, err := pcap.CompileBPFFilter(lt, snaplen, expr)
+		programs = append(programs, FilterProgram{LinkType: lt, Instructions: insns, Err: err})
+	}
+	return programs
+}
+
+// ValidateFilter compiles the BPF expression for each link type, see
+// CompileFilter. It returns an error when the expression compiles for none
+// of them, and otherwise the programs so failures limited to some link
+// types, eg: 'ether host' primitives on tunnels, may be reported.
+func ValidateFilter(expr string, snaplen int, lts ...layers.LinkType) ([]FilterProgram, error) {
+	programs := CompileFilter(expr, snaplen, lts...)
+	msgs := make([]string, 0, len(programs))
+	for _, p := range programs {
+		if p.Err == nil {
+			return programs, nil
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %v", p.LinkType, p.Err))
+	}
+	return programs, fmt.Errorf("invalid BPF %q; %s", expr, strings.Join(msgs, "; "))
+}
+
+// WriteProgram writes the compiled instructions as C array initialisers, in
+// the format of 'tcpdump -dd'.
+func WriteProgram(w io.Writer, insns []pcap.BPFInstruction) error {
+	for _, i := range insns {
+		if _, err := fmt.Fprintf(w, "{ 0x%x, %d, %d, 0x%08x },\n", i.Code, i.Jt, i.Jf, i.K); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileLinkType provides the link type of the packets in a capture file.
+func FileLinkType(path string) (layers.LinkType, error) {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Close()
+	return handle.LinkType(), nil
+}
+
+// Filter is a BPF expression shared by live captures, which may be changed
+// while they run, eg: when configuration is reloaded.
+type Filter struct {
+	mux     sync.Mutex
+	expr    string
+	handles map[*pcap.Handle]string // interface of each capture
+}
+
+// NewFilter initializes a Filter of the BPF expression.
+func NewFilter(expr string) *Filter {
+	return &Filter{expr: expr, handles: make(map[*pcap.Handle]string)}
+}
+
+// String returns the BPF expression applied to new captures.
+func (f *Filter) String() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.expr
+}
+
+// Set applies the BPF expression to every running capture, and to the
+// captures opened afterwards. Captures the expression fails to apply to
+// keep their previous filter, and are reported in the error.
+func (f *Filter) Set(expr string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.expr = expr
+	msgs := make([]string, 0)
+	for h, iface := range f.handles {
+		if err := h.SetBPFFilter(expr); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", iface, err))
+		}
+	}
+	if len(msgs) > 0 {
+		sort.Strings(msgs)
+		return fmt.Errorf("unable to apply BPF %q; %s", expr, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// attach applies the expression to the interface's capture handle, which
+// then follows changes to the expression until detached.
+func (f *Filter) attach(iface string, h *pcap.Handle) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := h.SetBPFFilter(f.expr); err != nil {
+		return fmt.Errorf("invalid BPF %q: %w", f.expr, err)
+	}
+	f.handles[h] = iface
+	return nil
+}
+
+// detach stops applying changes of the expression to the handle.
+func (f *Filter) detach(h *pcap.Handle) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	delete(f.handles, h)
+}
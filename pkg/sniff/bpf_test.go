@@ -0,0 +1,90 @@
+package sniff
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func TestParseLinkType(t *testing.T) {
+	if lt, err := ParseLinkType("Ethernet"); err != nil || lt != layers.LinkTypeEthernet {
+		t.Errorf("unexpected link type %s: %v", lt, err)
+	}
+	if _, err := ParseLinkType("token-ring"); err == nil {
+		t.Error("unknown link type was parsed")
+	}
+}
+
+func TestWriteProgram(t *testing.T) {
+	// 'tcpdump -dd ip' on ethernet.
+	insns := []pcap.BPFInstruction{
+		{Code: 0x28, K: 12},
+		{Code: 0x15, Jf: 1, K: 0x800},
+		{Code: 0x6, K: 262144},
+		{Code: 0x6},
+	}
+	var buf bytes.Buffer
+	if err := WriteProgram(&buf, insns); err != nil {
+		t.Fatal(err)
+	}
+	exp := `{ 0x28, 0, 0, 0x0000000c },
+{ 0x15, 0, 1, 0x00000800 },
+{ 0x6, 0, 0, 0x00040000 },
+{ 0x6, 0, 0, 0x00000000 },
+`
+	if buf.String() != exp {
+		t.Errorf("program:\n%s\n!=\n%s", buf.String(), exp)
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	for _, tc := range []struct {
+		expr   string
+		lts    []layers.LinkType
+		err    []string // substrings of the error, nil when valid
+		failed []layers.LinkType
+	}{
+		{expr: "tcp port 80", lts: []layers.LinkType{layers.LinkTypeEthernet, layers.LinkTypeRaw}},
+		{
+			expr:   "tcp port 80 and (host 10.0.0.1",
+			lts:    []layers.LinkType{layers.LinkTypeEthernet},
+			err:    []string{`invalid BPF "tcp port 80 and (host 10.0.0.1"`, "Ethernet: "},
+			failed: []layers.LinkType{layers.LinkTypeEthernet},
+		},
+		{
+			expr:   "tcp port",
+			lts:    []layers.LinkType{layers.LinkTypeEthernet, layers.LinkTypeRaw},
+			err:    []string{`invalid BPF "tcp port"`, "Ethernet: ", "; Raw: "},
+			failed: []layers.LinkType{layers.LinkTypeEthernet, layers.LinkTypeRaw},
+		},
+		// Valid for some link types, the failures are only reported.
+		{
+			expr:   "ether host 00:11:22:33:44:55",
+			lts:    []layers.LinkType{layers.LinkTypeEthernet, layers.LinkTypeRaw},
+			failed: []layers.LinkType{layers.LinkTypeRaw},
+		},
+	} {
+		programs, err := ValidateFilter(tc.expr, 1600, tc.lts...)
+		if (err != nil) != (tc.err != nil) {
+			t.Errorf("%q: unexpected error %v", tc.expr, err)
+		}
+		for _, s := range tc.err {
+			if err != nil && !strings.Contains(err.Error(), s) {
+				t.Errorf("%q: error %q does not contain %q", tc.expr, err, s)
+			}
+		}
+		var failed []layers.LinkType
+		for _, p := range programs {
+			if p.Err != nil {
+				failed = append(failed, p.LinkType)
+			}
+		}
+		if len(programs) != len(tc.lts) || !reflect.DeepEqual(failed, tc.failed) {
+			t.Errorf("%q: failed to compile for %v, expected %v", tc.expr, failed, tc.failed)
+		}
+	}
+}
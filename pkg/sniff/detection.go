@@ -1,20 +1,166 @@
 // Package sniff provides functionality to interact with networking interfaces.
 //
-// Functionality which ties directly to the 
+// Functionality which ties directly to the
 package sniff
 
-import "net"
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"time"
+)
 
-// DetectInterfaces provides list of network interface handles.
-// Returned names can be bound to for intercepting traffic.
-func DetectInterfaces() ([]string, error) {
+// Address families an InterfaceFilter may require.
+const (
+	FamilyAny  = ""
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// InterfaceFilter selects the interfaces to capture from. The zero value
+// selects every interface.
+type InterfaceFilter struct {
+	// Include are glob patterns, eg: 'eth*', of the interface names to
+	// capture from. Every interface is included when empty.
+	Include []string
+	// Exclude are glob patterns of interface names not to capture from,
+	// taking precedence over Include.
+	Exclude []string
+	// Up requires interfaces to be up and running.
+	Up bool
+	// Family requires interfaces to have an address of the family, one of
+	// FamilyAny, FamilyIPv4 or FamilyIPv6.
+	Family string
+}
+
+// Validate reports whether the patterns and family are well formed.
+func (f InterfaceFilter) Validate() error {
+	for _, p := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q: %w", p, err)
+		}
+	}
+	switch f.Family {
+	case FamilyAny, FamilyIPv4, FamilyIPv6:
+	default:
+		return fmt.Errorf("unknown address family %q", f.Family)
+	}
+	return nil
+}
+
+// Matches reports whether the interface is selected by the filter.
+func (f InterfaceFilter) Matches(i net.Interface) bool {
+	if !matchAny(f.Include, i.Name, true) || matchAny(f.Exclude, i.Name, false) {
+		return false
+	}
+	if f.Up && (i.Flags&net.FlagUp == 0 || i.Flags&net.FlagRunning == 0) {
+		return false
+	}
+	if f.Family == FamilyAny {
+		return true
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && (n.IP.To4() != nil) == (f.Family == FamilyIPv4) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether the name matches one of the patterns, or empty
+// when there are no patterns.
+func matchAny(patterns []string, name string, empty bool) bool {
+	if len(patterns) == 0 {
+		return empty
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectInterfaces provides list of network interface handles selected by
+// the filter. Returned names can be bound to for intercepting traffic.
+func DetectInterfaces(filter InterfaceFilter) ([]string, error) {
 	output := make([]string, 0)
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
 	for _, i := range ifaces {
-		output = append(output, i.Name)
+		if filter.Matches(i) {
+			output = append(output, i.Name)
+		}
 	}
 	return output, nil
 }
+
+// InterfaceChange reports an interface selected by a filter appeared, or
+// disappeared when Removed.
+type InterfaceChange struct {
+	Iface   string
+	Removed bool
+}
+
+// WatchInterfaces polls the interfaces selected by the filter every
+// interval, reporting changes from the known interfaces, eg: containers or
+// VPN tunnels starting and stopping. It returns when the context is closed.
+func WatchInterfaces(ctx context.Context, filter InterfaceFilter, known []string, interval time.Duration, changes chan<- InterfaceChange) {
+	current := make(map[string]bool)
+	for _, iface := range known {
+		current[iface] = true
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		ifaces, err := DetectInterfaces(filter)
+		if err != nil {
+			continue
+		}
+		found := make(map[string]bool)
+		for _, iface := range ifaces {
+			found[iface] = true
+		}
+		for _, c := range diffInterfaces(current, found) {
+			select {
+			case <-ctx.Done():
+				return
+			case changes <- c:
+			}
+		}
+		current = found
+	}
+}
+
+// diffInterfaces lists the interfaces added to and removed from current, in
+// name order.
+func diffInterfaces(current, found map[string]bool) []InterfaceChange {
+	changes := make([]InterfaceChange, 0)
+	for iface := range found {
+		if !current[iface] {
+			changes = append(changes, InterfaceChange{Iface: iface})
+		}
+	}
+	for iface := range current {
+		if !found[iface] {
+			changes = append(changes, InterfaceChange{Iface: iface, Removed: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Iface < changes[j].Iface
+	})
+	return changes
+}
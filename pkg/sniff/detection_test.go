@@ -0,0 +1,56 @@
+package sniff
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestInterfaceFilter(t *testing.T) {
+	up := net.FlagUp | net.FlagRunning
+	tests := []struct {
+		filter InterfaceFilter
+		iface  net.Interface
+		match  bool
+	}{
+		{InterfaceFilter{}, net.Interface{Name: "lo"}, true},
+		{InterfaceFilter{Include: []string{"eth*"}}, net.Interface{Name: "eth0"}, true},
+		{InterfaceFilter{Include: []string{"eth*"}}, net.Interface{Name: "tun0"}, false},
+		{InterfaceFilter{Include: []string{"eth*", "tun?"}}, net.Interface{Name: "tun0"}, true},
+		{InterfaceFilter{Include: []string{"eth*"}, Exclude: []string{"eth1"}}, net.Interface{Name: "eth1"}, false},
+		{InterfaceFilter{Exclude: []string{"lo"}}, net.Interface{Name: "eth0"}, true},
+		{InterfaceFilter{Up: true}, net.Interface{Name: "eth0", Flags: up}, true},
+		{InterfaceFilter{Up: true}, net.Interface{Name: "eth0", Flags: net.FlagUp}, false},
+	}
+	for _, tt := range tests {
+		if m := tt.filter.Matches(tt.iface); m != tt.match {
+			t.Errorf("%+v matching %s: %t != %t", tt.filter, tt.iface.Name, m, tt.match)
+		}
+	}
+
+	if err := (InterfaceFilter{Include: []string{"eth["}}).Validate(); err == nil {
+		t.Error("malformed pattern passed validation")
+	}
+	if err := (InterfaceFilter{Family: "ipx"}).Validate(); err == nil {
+		t.Error("unknown family passed validation")
+	}
+	if err := (InterfaceFilter{Include: []string{"eth*"}, Family: FamilyIPv6}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiffInterfaces(t *testing.T) {
+	current := map[string]bool{"eth0": true, "tun0": true}
+	found := map[string]bool{"eth0": true, "docker0": true, "veth1": true}
+	exp := []InterfaceChange{
+		{Iface: "docker0"},
+		{Iface: "tun0", Removed: true},
+		{Iface: "veth1"},
+	}
+	if c := diffInterfaces(current, found); !reflect.DeepEqual(c, exp) {
+		t.Errorf("changes %+v != %+v", c, exp)
+	}
+	if c := diffInterfaces(found, found); len(c) != 0 {
+		t.Errorf("unchanged interfaces reported: %+v", c)
+	}
+}
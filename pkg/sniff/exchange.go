@@ -0,0 +1,180 @@
+package sniff
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// connKey identifies one direction of a TCP connection.
+type connKey struct {
+	net, transport gopacket.Flow
+}
+
+// exchangeResponse is the part of a response paired with its request.
+type exchangeResponse struct {
+	ts     time.Time
+	status int
+	size   int64
+}
+
+// httpConn pairs the requests and responses read from the two directions of
+// a TCP connection. HTTP/1.x answers requests in the order they were sent,
+// so pipelined requests are matched to responses first in, first out.
+//
+// Either direction may be decoded first, so unmatched requests and
+// responses are both queued until their counterpart arrives. Requests are
+// emitted without a response when the server's direction has not been
+// seen, or once they have waited for it longer than wait.
+type httpConn struct {
+	key    connKey
+	output chan HTTPXPacket
+	wait   time.Duration
+	refs   int // streams of the connection still being read, guarded by the factory
+
+	mux       sync.Mutex
+	requests  []HTTPXPacket
+	head      uint64 // sequence number of requests[0]
+	responses []exchangeResponse
+	expired   []string      // methods of the requests emitted before their response
+	arrived   chan struct{} // signaled when a request is queued
+	duplex    bool          // both directions of the connection were seen
+	closed    bool          // the connection was flushed
+	expiring  sync.WaitGroup
+}
+
+// request emits the request paired with its response if it was already
+// decoded, otherwise queues it for up to wait. The request is emitted
+// immediately if the server's direction of the connection is unseen.
+func (c *httpConn) request(hp HTTPXPacket) {
+	c.mux.Lock()
+	if len(c.responses) > 0 {
+		resp := c.responses[0]
+		c.responses = c.responses[1:]
+		c.mux.Unlock()
+		c.emit(pair(hp, resp))
+		return
+	}
+	if !c.duplex {
+		c.expired = append(c.expired, hp.Method)
+		c.mux.Unlock()
+		c.emit(hp)
+		return
+	}
+	seq := c.head + uint64(len(c.requests))
+	c.requests = append(c.requests, hp)
+	c.mux.Unlock()
+	time.AfterFunc(c.wait, func() { c.expire(seq) })
+	select {
+	case c.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// expire emits the request of sequence number seq, and those queued before
+// it, if it is still awaiting its response.
+func (c *httpConn) expire(seq uint64) {
+	c.mux.Lock()
+	if c.closed || seq < c.head {
+		c.mux.Unlock()
+		return
+	}
+	n := int(seq-c.head) + 1
+	reqs := c.requests[:n:n]
+	c.requests = c.requests[n:]
+	c.head += uint64(n)
+	for _, hp := range reqs {
+		c.expired = append(c.expired, hp.Method)
+	}
+	c.expiring.Add(1)
+	c.mux.Unlock()
+	defer c.expiring.Done()
+	for _, hp := range reqs {
+		c.emit(hp)
+	}
+}
+
+// response pairs the response with the oldest unanswered request. Responses
+// to requests already emitted are discarded.
+func (c *httpConn) response(resp exchangeResponse) {
+	c.mux.Lock()
+	if len(c.expired) > 0 {
+		c.expired = c.expired[1:]
+		c.mux.Unlock()
+		return
+	}
+	if len(c.requests) > 0 {
+		hp := c.requests[0]
+		c.requests = c.requests[1:]
+		c.head++
+		c.mux.Unlock()
+		c.emit(pair(hp, resp))
+		return
+	}
+	c.responses = append(c.responses, resp)
+	c.mux.Unlock()
+}
+
+// nextRequest describes the request the next response will answer, waiting
+// up to wait for it to be decoded. Returns nil if no request arrived, or
+// the client's direction of the connection was never captured.
+func (c *httpConn) nextRequest(wait time.Duration) *http.Request {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		c.mux.Lock()
+		if len(c.expired) > 0 {
+			req := &http.Request{Method: c.expired[0]}
+			c.mux.Unlock()
+			return req
+		}
+		if len(c.requests) > 0 {
+			req := &http.Request{Method: c.requests[0].Method}
+			c.mux.Unlock()
+			return req
+		}
+		duplex := c.duplex
+		c.mux.Unlock()
+		if !duplex {
+			return nil
+		}
+		select {
+		case <-c.arrived:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// flush emits the requests which never received a response, once those
+// expiring have been emitted.
+func (c *httpConn) flush() {
+	c.mux.Lock()
+	reqs := c.requests
+	c.requests = nil
+	c.responses = nil
+	c.expired = nil
+	c.closed = true
+	c.mux.Unlock()
+	c.expiring.Wait()
+	for _, hp := range reqs {
+		c.emit(hp)
+	}
+}
+
+func (c *httpConn) emit(hp HTTPXPacket) {
+	if c.output != nil {
+		c.output <- hp
+	}
+}
+
+func pair(hp HTTPXPacket, resp exchangeResponse) HTTPXPacket {
+	hp.StatusCode = resp.status
+	hp.ResponseSize = resp.size
+	if resp.ts.After(hp.TS) {
+		hp.Latency = resp.ts.Sub(hp.TS)
+	}
+	return hp
+}
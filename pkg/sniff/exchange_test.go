@@ -0,0 +1,78 @@
+package sniff
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRequestOnlyStream(t *testing.T) {
+	out := make(chan HTTPXPacket, 1)
+	f := &httpStreamFactory{ctx: context.Background(), output: Output{HTTP: out}, logger: log.New()}
+	ip := &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80}
+	s := f.New(ip.NetworkFlow(), tcp.TransportFlow())
+
+	ts := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	reassembled := make(chan struct{})
+	go func() {
+		s.Reassembled([]tcpassembly.Reassembly{{
+			Bytes: []byte("GET /ski/kona HTTP/1.1\r\nHost: rusutsu.com\r\n\r\n"),
+			Seen:  ts,
+		}})
+		close(reassembled)
+	}()
+	// The server's direction was never seen, so no response is awaited.
+	select {
+	case hp := <-out:
+		if hp.Path != "/ski/kona" || !hp.TS.Equal(ts) || hp.StatusCode != 0 {
+			t.Errorf("unexpected request %+v", hp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request was not emitted before its stream closed")
+	}
+	<-reassembled
+	s.ReassemblyComplete()
+	f.streams.Wait()
+}
+
+func TestResponseWait(t *testing.T) {
+	out := make(chan HTTPXPacket, 3)
+	c := &httpConn{output: out, wait: 10 * time.Millisecond, duplex: true, arrived: make(chan struct{}, 1)}
+	ts := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+
+	c.request(HTTPXPacket{TS: ts, Path: "/slow", Method: "HEAD"})
+	select {
+	case hp := <-out:
+		if hp.Path != "/slow" || hp.StatusCode != 0 {
+			t.Errorf("unexpected expired request %+v", hp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unanswered request was not emitted")
+	}
+	// The late response answers the expired request, not the next one.
+	if req := c.nextRequest(0); req == nil || req.Method != "HEAD" {
+		t.Errorf("next response answers %v, expected the expired HEAD", req)
+	}
+	c.request(HTTPXPacket{TS: ts, Path: "/fast", Method: "GET"})
+	c.response(exchangeResponse{ts: ts.Add(time.Second), status: 504})
+	c.response(exchangeResponse{ts: ts.Add(time.Second), status: 200, size: 10})
+	if hp := <-out; hp.Path != "/fast" || hp.StatusCode != 200 || hp.Latency != time.Second {
+		t.Errorf("unexpected exchange %+v", hp)
+	}
+
+	c.request(HTTPXPacket{TS: ts, Path: "/flushed", Method: "GET"})
+	c.flush()
+	if hp := <-out; hp.Path != "/flushed" {
+		t.Errorf("unexpected flushed request %+v", hp)
+	}
+	time.Sleep(2 * c.wait)
+	if len(out) != 0 {
+		t.Error("flushed request emitted twice")
+	}
+}
@@ -0,0 +1,180 @@
+package sniff
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// FlowBytes reports the TCP payload bytes a flow carried in each direction
+// since the flow's previous report. Every TCP flow matching the capture's
+// BPF is reported, whether or not it carries HTTP.
+type FlowBytes struct {
+	TS         time.Time
+	LocalAddr  string
+	LocalPort  string
+	RemoteAddr string
+	RemotePort string
+	// ServerPort is the port of the side which accepted the connection,
+	// either LocalPort or RemotePort.
+	ServerPort string
+	// Sent is the bytes from the local to the remote host, Received the
+	// bytes from the remote to the local host.
+	Sent     int64
+	Received int64
+}
+
+// flowReport is how often, in capture time, the bytes of active flows are
+// reported.
+const flowReport = 5 * time.Second
+
+// flowIdle is how long a flow may carry no bytes before it is forgotten.
+const flowIdle = 2 * time.Minute
+
+// flowMeter totals the payload bytes of each TCP flow between reports.
+type flowMeter struct {
+	ctx    context.Context
+	output chan FlowBytes
+	// local holds the capturing host's addresses. When empty the side which
+	// opened the connection is assumed local.
+	local map[string]bool
+
+	flows    map[connKey]*flowCount // keyed in the local to remote direction
+	latest   time.Time              // capture time of the latest segment
+	reported time.Time
+}
+
+type flowCount struct {
+	sent, received int64
+	seen           time.Time
+	serverPort     string
+}
+
+func newFlowMeter(ctx context.Context, output chan FlowBytes, local []net.IP) *flowMeter {
+	m := &flowMeter{
+		ctx:    ctx,
+		output: output,
+		local:  make(map[string]bool),
+		flows:  make(map[connKey]*flowCount),
+	}
+	for _, ip := range local {
+		m.local[ip.String()] = true
+	}
+	return m
+}
+
+// localAddrs returns the addresses assigned to the named interface.
+func localAddrs(iface string) []net.IP {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return nil
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			ips = append(ips, n.IP)
+		}
+	}
+	return ips
+}
+
+// observe counts the segment's payload against its flow, then reports the
+// flows if a report is due at ts.
+func (m *flowMeter) observe(netFlow gopacket.Flow, tcp *layers.TCP, ts time.Time) {
+	if m.output == nil {
+		return
+	}
+	m.latest = ts
+	transport, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(tcp.SrcPort), layers.NewTCPPortEndpoint(tcp.DstPort))
+	key := connKey{netFlow, transport}
+	n := int64(len(tcp.Payload))
+	if c, ok := m.flows[key]; ok {
+		c.sent += n
+		c.seen = ts
+	} else if c, ok := m.flows[connKey{key.net.Reverse(), key.transport.Reverse()}]; ok {
+		c.received += n
+		c.seen = ts
+	} else if m.isLocal(netFlow, tcp) {
+		m.flows[key] = &flowCount{sent: n, seen: ts, serverPort: serverPort(tcp)}
+	} else {
+		m.flows[connKey{key.net.Reverse(), key.transport.Reverse()}] = &flowCount{received: n, seen: ts, serverPort: serverPort(tcp)}
+	}
+
+	if m.reported.IsZero() {
+		m.reported = ts
+	} else if ts.Sub(m.reported) >= flowReport {
+		m.report(ts)
+	}
+}
+
+// isLocal reports whether the segment was sent by the local side of its
+// flow: the capturing host's address, else the connection's opener, else
+// the higher, typically ephemeral, port.
+func (m *flowMeter) isLocal(netFlow gopacket.Flow, tcp *layers.TCP) bool {
+	src, dst := netFlow.Endpoints()
+	if srcLocal, dstLocal := m.local[src.String()], m.local[dst.String()]; srcLocal != dstLocal {
+		return srcLocal
+	}
+	if tcp.SYN {
+		return !tcp.ACK
+	}
+	return tcp.SrcPort > tcp.DstPort
+}
+
+// serverPort is the port of the side of the segment's connection which
+// accepted it: the destination of a SYN, the source of a SYN-ACK, else the
+// lower, typically well-known, port.
+func serverPort(tcp *layers.TCP) string {
+	port := tcp.DstPort
+	if tcp.SYN && tcp.ACK || !tcp.SYN && tcp.SrcPort < tcp.DstPort {
+		port = tcp.SrcPort
+	}
+	return strconv.Itoa(int(port))
+}
+
+// flush reports the bytes counted since the previous report.
+func (m *flowMeter) flush() {
+	if m.output != nil && !m.latest.IsZero() {
+		m.report(m.latest)
+	}
+}
+
+// report emits the bytes counted for each flow since the previous report,
+// and forgets idle flows.
+func (m *flowMeter) report(ts time.Time) {
+	m.reported = ts
+	for key, c := range m.flows {
+		if c.sent == 0 && c.received == 0 {
+			if ts.Sub(c.seen) > flowIdle {
+				delete(m.flows, key)
+			}
+			continue
+		}
+		src, dst := key.net.Endpoints()
+		sport, dport := key.transport.Endpoints()
+		fb := FlowBytes{
+			TS:         c.seen,
+			LocalAddr:  src.String(),
+			LocalPort:  sport.String(),
+			RemoteAddr: dst.String(),
+			RemotePort: dport.String(),
+			ServerPort: c.serverPort,
+			Sent:       c.sent,
+			Received:   c.received,
+		}
+		c.sent, c.received = 0, 0
+		select {
+		case <-m.ctx.Done():
+			return
+		case m.output <- fb:
+		}
+	}
+}
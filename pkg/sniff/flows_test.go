@@ -0,0 +1,69 @@
+package sniff
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestFlowMeter(t *testing.T) {
+	out := make(chan FlowBytes, 10)
+	server := net.IP{10, 0, 0, 2}
+	// The capturing host is the server, though the client opened the flow.
+	m := newFlowMeter(context.Background(), out, []net.IP{server})
+
+	client := net.IP{10, 0, 0, 1}
+	segment := func(ts time.Time, src, dst net.IP, sport, dport uint16, payload int) {
+		ip := &layers.IPv4{SrcIP: src, DstIP: dst}
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(sport), DstPort: layers.TCPPort(dport), SYN: payload == 0}
+		tcp.Payload = make([]byte, payload)
+		m.observe(ip.NetworkFlow(), tcp, ts)
+	}
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	segment(start, client, server, 40000, 80, 0)
+	segment(start.Add(time.Second), client, server, 40000, 80, 100)
+	segment(start.Add(2*time.Second), server, client, 80, 40000, 1000)
+	if len(out) != 0 {
+		t.Fatalf("flows reported before %s", flowReport)
+	}
+	segment(start.Add(flowReport), server, client, 80, 40000, 10)
+
+	exp := FlowBytes{
+		TS:        start.Add(flowReport),
+		LocalAddr: "10.0.0.2", LocalPort: "80", RemoteAddr: "10.0.0.1", RemotePort: "40000", ServerPort: "80",
+		Sent: 1010, Received: 100,
+	}
+	if fb := <-out; fb != exp {
+		t.Errorf("flow %+v != %+v", fb, exp)
+	}
+
+	// Quiet flows are not reported, then forgotten once idle.
+	m.report(start.Add(flowReport + time.Second))
+	if len(out) != 0 || len(m.flows) != 1 {
+		t.Errorf("quiet flow reported or forgotten early: %d reports, %d flows", len(out), len(m.flows))
+	}
+	m.report(start.Add(flowReport + flowIdle + time.Second))
+	if len(m.flows) != 0 {
+		t.Error("idle flow was not forgotten")
+	}
+
+	// Without local addresses the opener of the connection is local.
+	m = newFlowMeter(context.Background(), out, nil)
+	segment(start, client, server, 40000, 80, 0)
+	segment(start, server, client, 80, 40000, 10)
+	m.flush()
+	if fb := <-out; fb.LocalAddr != "10.0.0.1" || fb.LocalPort != "40000" || fb.ServerPort != "80" || fb.Received != 10 {
+		t.Errorf("unexpected flow orientation: %+v", fb)
+	}
+
+	// Flows captured without their handshake are served from the lower port.
+	m = newFlowMeter(context.Background(), out, []net.IP{client})
+	segment(start, server, client, 8080, 50000, 10)
+	m.flush()
+	if fb := <-out; fb.LocalPort != "50000" || fb.ServerPort != "8080" {
+		t.Errorf("unexpected server port: %+v", fb)
+	}
+}
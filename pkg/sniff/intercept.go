@@ -3,8 +3,13 @@ package sniff
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -29,37 +34,139 @@ import (
 // httpStreamFactory implements tcpassembly.StreamFactory
 type httpStreamFactory struct {
 	ctx    context.Context
-	output chan HTTPXPacket
+	output Output
 	logger *log.Logger
+
+	// streams tracks the running stream readers so offline captures can
+	// wait for every reconstructed request to be emitted.
+	streams sync.WaitGroup
+
+	// conns pairs the two directions of each TCP connection.
+	connsMux sync.Mutex
+	conns    map[connKey]*httpConn
 }
 
 func (h *httpStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
 	hstream := &httpXStream{
 		ctx:       h.ctx,
-		output:    h.output,
+		tls:       h.output.TLS,
 		net:       net,
 		transport: transport,
-		r:         tcpreader.NewReaderStream(),
+		r:         timedReaderStream{ReaderStream: tcpreader.NewReaderStream()},
 		logger:    h.logger,
+		conn:      h.openConn(net, transport),
 	}
-	go hstream.run() // Important... we must guarantee that data from the reader stream is read.
+	atomic.AddUint64(&captureStats.streams, 1)
+	h.streams.Add(1)
+	go func() {
+		defer h.streams.Done()
+		defer h.closeConn(hstream.conn)
+		hstream.run() // Important... we must guarantee that data from the reader stream is read.
+	}()
 
 	// ReaderStream implements tcpassembly.Stream, so we can return a pointer to it.
 	return &hstream.r
 }
 
+// openConn returns the connection the stream belongs to, creating it for the
+// first direction seen.
+func (h *httpStreamFactory) openConn(net, transport gopacket.Flow) *httpConn {
+	h.connsMux.Lock()
+	defer h.connsMux.Unlock()
+	if h.conns == nil {
+		h.conns = make(map[connKey]*httpConn)
+	}
+	if c, ok := h.conns[connKey{net.Reverse(), transport.Reverse()}]; ok {
+		c.refs++
+		c.mux.Lock()
+		c.duplex = true
+		c.mux.Unlock()
+		return c
+	}
+	key := connKey{net, transport}
+	c := &httpConn{key: key, output: h.output.HTTP, wait: responseWait, refs: 1, arrived: make(chan struct{}, 1)}
+	h.conns[key] = c
+	return c
+}
+
+// closeConn releases a stream's hold on its connection. Once both directions
+// have ended, requests which never saw a response are emitted.
+func (h *httpStreamFactory) closeConn(c *httpConn) {
+	h.connsMux.Lock()
+	c.refs--
+	done := c.refs == 0
+	if done && h.conns[c.key] == c {
+		delete(h.conns, c.key)
+	}
+	h.connsMux.Unlock()
+	if done {
+		c.flush()
+	}
+}
+
+// timedReaderStream records the capture timestamp of the bytes most recently
+// handed to the reader, so parsed requests carry packet time rather than
+// the time they were decoded.
+type timedReaderStream struct {
+	tcpreader.ReaderStream
+	seen int64
+}
+
+// Reassembled stores the capture time before blocking on the reader.
+func (t *timedReaderStream) Reassembled(reassembly []tcpassembly.Reassembly) {
+	if len(reassembly) > 0 {
+		atomic.StoreInt64(&t.seen, reassembly[0].Seen.UnixNano())
+	}
+	t.ReaderStream.Reassembled(reassembly)
+}
+
+// Seen returns the capture timestamp of the last reassembled bytes.
+func (t *timedReaderStream) Seen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.seen))
+}
+
 // httpStream will handle the actual decoding of http requests.
 type httpXStream struct {
 	ctx       context.Context
 	net       gopacket.Flow
 	transport gopacket.Flow
-	r         tcpreader.ReaderStream
+	r         timedReaderStream
 	logger    *log.Logger
-	output    chan HTTPXPacket
+	tls       chan TLSHello
+	conn      *httpConn
 }
 
+// run detects whether the stream carries a TLS handshake or which half of
+// the HTTP exchange, then decodes it until EOF.
 func (h *httpXStream) run() {
 	buf := bufio.NewReader(&h.r)
+	prefix, err := buf.Peek(len(responsePrefix))
+	switch {
+	case err == nil && isTLSHandshake(prefix):
+		h.readTLS(buf)
+	case err == nil && string(prefix) == responsePrefix:
+		h.readResponses(buf)
+	default:
+		h.readRequests(buf)
+	}
+	if h.ctx.Err() == nil {
+		// We must read until we see an EOF... very important!
+		tcpreader.DiscardBytesToEOF(buf)
+	}
+}
+
+// responsePrefix begins every HTTP/1.x status line sent by servers.
+const responsePrefix = "HTTP/"
+
+// requestWait bounds how long a response waits for the request it answers
+// to be decoded from the other direction of the connection.
+const requestWait = 100 * time.Millisecond
+
+// responseWait bounds how long a request waits for its response before it
+// is emitted without one.
+const responseWait = 5 * time.Second
+
+func (h *httpXStream) readRequests(buf *bufio.Reader) {
 	for {
 		select {
 		case <-h.ctx.Done():
@@ -69,33 +176,66 @@ func (h *httpXStream) run() {
 			req, err := http.ReadRequest(buf)
 			if err == io.EOF {
 				h.logger.Trace("EOF signaled")
-				// We must read until we see an EOF... very important!
 				return
 			} else if err != nil {
 				// Common error case from HTTPS packets communication.
-				var errStr string
-				if len(err.Error()) > 30 {
-					errStr = err.Error()[:30]
-				} else {
-					errStr = err.Error()
-				}
-				h.logger.WithFields(log.Fields{"net": h.net, "transport": h.transport, "err": errStr}).
-					Tracef("http.ReadRequest error reading packet")
+				h.parseError("http.ReadRequest", err)
 			} else if req != nil {
 				// HTTP data was read into request
 				// Create HTTPXPacket to return to processors.
 				hp := HTTPXPacket{
-					TS:       time.Now(),
+					TS:       h.r.Seen(),
 					Protocol: "http",
 					Host:     req.Host,
 					Path:     req.URL.Path,
+					RawPath:  req.URL.EscapedPath(),
 					Method:   req.Method,
 					Port:     h.transport.String(),
 					Net:      h.net.String(),
+					Client:   h.net.Src().String(),
 				}
-				if h.output != nil {
-					h.output <- hp
+				// Skip the body so the next pipelined request can be read.
+				size, _ := tcpreader.DiscardBytesToFirstError(req.Body)
+				req.Body.Close()
+				hp.RequestSize = int64(size)
+				h.conn.request(hp)
+			} else {
+				h.logger.Trace("http packet read failed")
+			}
+		}
+	}
+}
+
+func (h *httpXStream) readResponses(buf *bufio.Reader) {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		default:
+			if _, err := buf.Peek(1); err != nil {
+				h.logger.Trace("EOF signaled")
+				return
+			}
+			// The request is needed to know whether a body follows, eg: HEAD.
+			resp, err := http.ReadResponse(buf, h.conn.nextRequest(requestWait))
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				h.logger.Trace("EOF signaled")
+				return
+			} else if err != nil {
+				h.parseError("http.ReadResponse", err)
+			} else if resp != nil {
+				ts := h.r.Seen()
+				size, _ := tcpreader.DiscardBytesToFirstError(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode < 200 {
+					// Informational responses precede the final response.
+					continue
 				}
+				h.conn.response(exchangeResponse{
+					ts:     ts,
+					status: resp.StatusCode,
+					size:   int64(size),
+				})
 			} else {
 				h.logger.Trace("http packet read failed")
 			}
@@ -103,34 +243,210 @@ func (h *httpXStream) run() {
 	}
 }
 
+// readTLS emits the ClientHello opening a TLS connection. The remainder of
+// the connection is encrypted and discarded.
+func (h *httpXStream) readTLS(buf *bufio.Reader) {
+	hello, ok, err := readClientHello(buf)
+	if err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			h.parseError("tls.ClientHello", err)
+		}
+		return
+	}
+	if !ok || h.tls == nil {
+		// The server's half of the handshake.
+		return
+	}
+	th := TLSHello{
+		TS:         h.r.Seen(),
+		ServerName: hello.serverName,
+		ALPN:       hello.alpn,
+		Version:    tlsVersionName(hello.version),
+		Addr:       h.net.Dst().String(),
+		Port:       h.transport.String(),
+		Net:        h.net.String(),
+	}
+	select {
+	case <-h.ctx.Done():
+	case h.tls <- th:
+	}
+}
+
+func (h *httpXStream) parseError(op string, err error) {
+	atomic.AddUint64(&captureStats.parseErrors, 1)
+	var errStr string
+	if len(err.Error()) > 30 {
+		errStr = err.Error()[:30]
+	} else {
+		errStr = err.Error()
+	}
+	h.logger.WithFields(log.Fields{"net": h.net, "transport": h.transport, "err": errStr}).
+		Tracef("%s error reading packet", op)
+}
+
 // HTTPXPacket provides information to categorize HTTP requests.
+//
+// Path is the percent-decoded request path, and RawPath the path as it was
+// requested, still encoded, eg: '/ski%2Fkona' for Path '/ski/kona'.
+// Client is the address which sent the request. RequestSize is the size of
+// the request body. When the response to the
+// request was captured on the same connection, StatusCode, ResponseSize and
+// Latency describe it. Requests whose response was not seen have a zero
+// StatusCode.
 type HTTPXPacket struct {
-	TS       time.Time
-	Protocol string
-	Host     string
-	Path     string
-	Method   string
-	Port     string
-	Net      string
+	TS          time.Time
+	Protocol    string
+	Host        string
+	Path        string
+	RawPath     string
+	Method      string
+	Port        string
+	Net         string
+	Client      string
+	RequestSize int64
+
+	StatusCode   int
+	ResponseSize int64
+	Latency      time.Duration
+}
+
+// EscapedPath is the path as it was requested, or when unknown Path
+// encoded.
+func (hp HTTPXPacket) EscapedPath() string {
+	if hp.RawPath != "" {
+		return hp.RawPath
+	}
+	return (&url.URL{Path: hp.Path}).EscapedPath()
+}
+
+// Output receives the records reconstructed from captured traffic. Records
+// for a nil channel are not emitted.
+type Output struct {
+	// HTTP receives each HTTP request, paired with its response.
+	HTTP chan HTTPXPacket
+	// TLS receives the ClientHello of each TLS connection, eg: HTTPS.
+	TLS chan TLSHello
+	// Flows periodically receives the bytes carried by each TCP flow.
+	Flows chan FlowBytes
+}
+
+// CaptureState is the state of the capture on an interface.
+type CaptureState string
+
+// Capture states reported by InterfaceListener.
+const (
+	// CaptureRunning reports packets are being read from the interface.
+	CaptureRunning CaptureState = "capturing"
+	// CaptureFailed reports the interface could not be opened, or stopped
+	// delivering packets. The capture is retried after the status' Retry.
+	CaptureFailed CaptureState = "failed"
+)
+
+// CaptureStatus reports a change of the capture state of an interface.
+type CaptureStatus struct {
+	TS    time.Time
+	Iface string
+	State CaptureState
+	// Err is the reason the capture failed.
+	Err error
+	// Retry is the delay before the failed capture is attempted again.
+	Retry time.Duration
+	// Attempts is the number of consecutive failed attempts.
+	Attempts int
 }
 
+// Capture retry backoff, doubling from the initial delay to the maximum.
+const (
+	captureRetryInitial = time.Second
+	captureRetryMax     = time.Minute
+)
+
 // InterfaceListener establishes a libpcap listener and BPF matching
 // for capturing and reconstructing packets.
-func InterfaceListener(ctx context.Context, stream chan HTTPXPacket, iface, bpfFilter string, snaplen int, logger *log.Logger) {
-	// Return reconstructed packet data via channel.
-	var handle *pcap.Handle
-	var err error
-
-	// Set up pcap packet capture
-	logger.Infof("Starting capture on interface %q", iface)
-	handle, err = pcap.OpenLive(iface, int32(snaplen), true, pcap.BlockForever)
+//
+// Changes of the capture state are sent to status. Failures to open the
+// interface, eg: while it is down, are retried with exponential backoff
+// until the context is closed.
+func InterfaceListener(ctx context.Context, stream Output, iface string, filter *Filter, snaplen int, status chan<- CaptureStatus, logger *log.Logger) {
+	retry := captureRetryInitial
+	attempts := 0
+	for {
+		// Set up pcap packet capture
+		logger.Infof("Starting capture on interface %q", iface)
+		handle, err := openInterface(iface, filter, snaplen)
+		if err == nil {
+			attempts = 0
+			retry = captureRetryInitial
+			sendStatus(ctx, status, CaptureStatus{TS: time.Now(), Iface: iface, State: CaptureRunning})
+
+			logger.Debugf("reading in packets from %s", iface)
+			assemble(ctx, iface, handle, stream, localAddrs(iface), nil, logger)
+			filter.detach(handle)
+			handle.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			err = fmt.Errorf("capture on %q stopped", iface)
+		}
+
+		attempts++
+		logger.WithFields(log.Fields{"iface": iface, "attempt": attempts, "retry": retry}).Warnf("capture failed: %v", err)
+		sendStatus(ctx, status, CaptureStatus{
+			TS:       time.Now(),
+			Iface:    iface,
+			State:    CaptureFailed,
+			Err:      err,
+			Retry:    retry,
+			Attempts: attempts,
+		})
+		t := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		if retry *= 2; retry > captureRetryMax {
+			retry = captureRetryMax
+		}
+	}
+}
+
+// openInterface opens a live capture of the interface, attached to the
+// filter.
+func openInterface(iface string, filter *Filter, snaplen int) (*pcap.Handle, error) {
+	handle, err := pcap.OpenLive(iface, int32(snaplen), true, pcap.BlockForever)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
+	}
+	if err := filter.attach(iface, handle); err != nil {
+		handle.Close()
+		return nil, err
 	}
+	return handle, nil
+}
 
-	if err := handle.SetBPFFilter(bpfFilter); err != nil {
-		logger.Fatal(err)
+func sendStatus(ctx context.Context, status chan<- CaptureStatus, s CaptureStatus) {
+	if status == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case status <- s:
 	}
+}
+
+// assemble reads packets from the pcap handle and passes them to the TCP
+// assembler until the context is closed or the packet source is exhausted.
+// Capture counters are recorded under the source name, and flow bytes are
+// attributed to the local addresses when given.
+//
+// If pace is set it is called with each packet's capture timestamp before
+// the packet is assembled, and connections are then flushed on packet time
+// rather than wall time.
+func assemble(ctx context.Context, source string, handle *pcap.Handle, stream Output, local []net.IP, pace func(time.Time), logger *log.Logger) {
+	stats := loadSourceStats(source)
+	meter := newFlowMeter(ctx, stream.Flows, local)
 
 	// Configure stream producer
 	streamFactory := &httpStreamFactory{
@@ -141,27 +457,61 @@ func InterfaceListener(ctx context.Context, stream chan HTTPXPacket, iface, bpfF
 	streamPool := tcpassembly.NewStreamPool(streamFactory)
 	assembler := tcpassembly.NewAssembler(streamPool)
 
-	logger.Debugf("reading in packets from %s", iface)
 	// Read in packets, pass to assembler.
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 	packets := packetSource.Packets()
-	ticker := time.Tick(time.Minute)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	statsTicker := time.NewTicker(5 * time.Second)
+	defer statsTicker.Stop()
+	var lastFlush time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case packet := <-packets:
+		case packet, ok := <-packets:
+			if !ok {
+				// Offline packet source exhausted, close out every stream
+				// and wait for their requests to be read.
+				assembler.FlushAll()
+				streamFactory.streams.Wait()
+				meter.flush()
+				stats.updateDropped(handle)
+				return
+			}
+			atomic.AddUint64(&stats.packets, 1)
+			ts := packet.Metadata().Timestamp
+			if pace != nil {
+				pace(ts)
+				if lastFlush.IsZero() {
+					lastFlush = ts
+				} else if ts.Sub(lastFlush) >= time.Minute {
+					// Every minute of packet time, flush connections that haven't seen activity in the past 2 minutes.
+					assembler.FlushOlderThan(ts.Add(time.Minute * -2))
+					lastFlush = ts
+				}
+			}
 			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeTCP {
 				logger.Tracef("Unreadable packet: %#v", packet.String())
 				continue
 			}
 			tcp := packet.TransportLayer().(*layers.TCP)
-			assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, packet.Metadata().Timestamp)
+			meter.observe(packet.NetworkLayer().NetworkFlow(), tcp, ts)
+			assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, ts)
 			//logger.Infof("%v", packet.String())
 
-		case <-ticker:
-			// Every minute, flush connections that haven't seen activity in the past 2 minutes.
-			assembler.FlushOlderThan(time.Now().Add(time.Minute * -2))
+		case <-ticker.C:
+			if pace == nil {
+				// Every minute, flush connections that haven't seen activity in the past 2 minutes.
+				assembler.FlushOlderThan(time.Now().Add(time.Minute * -2))
+			}
+
+		case <-statsTicker.C:
+			stats.updateDropped(handle)
+			if pace == nil {
+				// Report flows which have since gone quiet.
+				meter.report(time.Now())
+			}
 		}
 	}
 }
@@ -0,0 +1,36 @@
+package sniff
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestInterfaceListenerRetries(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	l := log.New()
+	l.SetOutput(ioutil.Discard)
+	status := make(chan CaptureStatus)
+	done := make(chan struct{})
+	go func() {
+		InterfaceListener(ctx, Output{}, "banken-missing0", NewFilter("tcp port 80"), 1600, status, l)
+		close(done)
+	}()
+
+	// Failures are reported rather than fatal, and retried with backoff.
+	for i, retry := range []time.Duration{time.Second, 2 * time.Second} {
+		s := <-status
+		if s.Iface != "banken-missing0" || s.State != CaptureFailed || s.Err == nil || s.Attempts != i+1 || s.Retry != retry {
+			t.Errorf("unexpected status %+v", s)
+		}
+	}
+	can()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("listener did not stop with its context")
+	}
+}
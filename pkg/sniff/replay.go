@@ -0,0 +1,80 @@
+package sniff
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+	log "github.com/sirupsen/logrus"
+)
+
+// Advancer is informed of the capture timestamp of each replayed packet,
+// allowing consumers to run on packet time instead of wall time.
+type Advancer interface {
+	Advance(t time.Time)
+}
+
+// FileListener replays a .pcap or .pcapng capture file through the same
+// TCP reassembly and HTTP parsing as InterfaceListener. It returns once
+// every packet in the file has been read and its requests emitted, or the
+// context is closed.
+//
+// speed scales the replay rate relative to the original capture: 1 replays
+// at the recorded rate, 10 ten times faster, and 0 (or less) replays as
+// fast as packets can be read. Each packet timestamp is passed to clock
+// before the packet is assembled.
+func FileListener(ctx context.Context, stream Output, path, bpfFilter string, speed float64, clock Advancer, logger *log.Logger) error {
+	logger.Infof("Replaying capture file %q", path)
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	if bpfFilter != "" {
+		if err := handle.SetBPFFilter(bpfFilter); err != nil {
+			return err
+		}
+	}
+
+	p := &pacer{ctx: ctx, speed: speed}
+	assemble(ctx, path, handle, stream, nil, func(ts time.Time) {
+		p.wait(ts)
+		if clock != nil {
+			clock.Advance(ts)
+		}
+	}, logger)
+	return ctx.Err()
+}
+
+// pacer delays replayed packets to reproduce the capture's timing scaled
+// by speed.
+type pacer struct {
+	ctx   context.Context
+	speed float64
+
+	first time.Time // timestamp of the first packet replayed
+	start time.Time // wall time the first packet was replayed
+}
+
+func (p *pacer) wait(ts time.Time) {
+	if p.speed <= 0 {
+		return
+	}
+	if p.first.IsZero() {
+		p.first = ts
+		p.start = time.Now()
+		return
+	}
+	offset := time.Duration(float64(ts.Sub(p.first)) / p.speed)
+	delay := time.Until(p.start.Add(offset))
+	if delay <= 0 {
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-p.ctx.Done():
+	case <-t.C:
+	}
+}
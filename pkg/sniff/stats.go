@@ -0,0 +1,98 @@
+package sniff
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// captureStats are the package wide health counters of every listener.
+var captureStats = struct {
+	streams     uint64
+	parseErrors uint64
+	sources     sync.Map // source name -> *sourceStats
+}{}
+
+type sourceStats struct {
+	packets   uint64
+	dropped   uint64
+	ifDropped uint64
+
+	// The counters libpcap last reported for the source's current handle,
+	// as each handle reopened after a failure counts from zero.
+	mux           sync.Mutex
+	handle        *pcap.Handle
+	lastDropped   uint64
+	lastIfDropped uint64
+}
+
+// Stats is a snapshot of the packet capture health counters.
+type Stats struct {
+	// Streams is the number of TCP streams created by the assemblers.
+	Streams uint64
+	// ParseErrors is the number of failed attempts to parse HTTP from a
+	// reassembled stream.
+	ParseErrors uint64
+	// Sources holds the counters of each interface or capture file read.
+	Sources map[string]SourceStats
+}
+
+// SourceStats counts the packets read from an interface or capture file.
+// Dropped counts are reported by libpcap for live interfaces.
+type SourceStats struct {
+	Packets   uint64
+	Dropped   uint64
+	IfDropped uint64
+}
+
+// ReadStats returns the current capture health counters.
+func ReadStats() Stats {
+	s := Stats{
+		Streams:     atomic.LoadUint64(&captureStats.streams),
+		ParseErrors: atomic.LoadUint64(&captureStats.parseErrors),
+		Sources:     make(map[string]SourceStats),
+	}
+	captureStats.sources.Range(func(key, value interface{}) bool {
+		src := value.(*sourceStats)
+		s.Sources[key.(string)] = SourceStats{
+			Packets:   atomic.LoadUint64(&src.packets),
+			Dropped:   atomic.LoadUint64(&src.dropped),
+			IfDropped: atomic.LoadUint64(&src.ifDropped),
+		}
+		return true
+	})
+	return s
+}
+
+func loadSourceStats(source string) *sourceStats {
+	s, _ := captureStats.sources.LoadOrStore(source, new(sourceStats))
+	return s.(*sourceStats)
+}
+
+// updateDropped records libpcap's drop counters of the handle.
+func (s *sourceStats) updateDropped(handle *pcap.Handle) {
+	ps, err := handle.Stats()
+	if err != nil {
+		return
+	}
+	s.addDropped(handle, uint64(ps.PacketsDropped), uint64(ps.PacketsIfDropped))
+}
+
+// addDropped adds the packets dropped since the handle's previous counters,
+// so the drops of the source's earlier handles are kept.
+func (s *sourceStats) addDropped(handle *pcap.Handle, dropped, ifDropped uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if handle != s.handle {
+		s.handle, s.lastDropped, s.lastIfDropped = handle, 0, 0
+	}
+	if dropped > s.lastDropped {
+		atomic.AddUint64(&s.dropped, dropped-s.lastDropped)
+		s.lastDropped = dropped
+	}
+	if ifDropped > s.lastIfDropped {
+		atomic.AddUint64(&s.ifDropped, ifDropped-s.lastIfDropped)
+		s.lastIfDropped = ifDropped
+	}
+}
@@ -0,0 +1,20 @@
+package sniff
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/pcap"
+)
+
+func TestDroppedAcrossHandles(t *testing.T) {
+	s := new(sourceStats)
+	first, second := new(pcap.Handle), new(pcap.Handle)
+	s.addDropped(first, 5, 1)
+	s.addDropped(first, 8, 1)
+	// The interface was reopened, its new handle counts from zero.
+	s.addDropped(second, 2, 0)
+	s.addDropped(second, 3, 2)
+	if s.dropped != 11 || s.ifDropped != 3 {
+		t.Errorf("dropped %d and %d by the interface, expected 11 and 3", s.dropped, s.ifDropped)
+	}
+}
@@ -0,0 +1,254 @@
+package sniff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TLSHello describes the ClientHello opening a TLS connection, eg: HTTPS.
+// Paths are encrypted, but the server name the client asked for is not.
+type TLSHello struct {
+	TS time.Time
+	// ServerName is the SNI extension's host name, empty when the client
+	// did not send one.
+	ServerName string
+	// ALPN lists the application protocols offered, eg: "h2", "http/1.1".
+	ALPN []string
+	// Version is the highest TLS version offered, eg: "TLS 1.3".
+	Version string
+	// Addr is the server's network address.
+	Addr string
+	Port string
+	Net  string
+}
+
+// Server is the name the connection is accounted under, the server name
+// when the client sent one, otherwise the server's address.
+func (h TLSHello) Server() string {
+	if h.ServerName != "" {
+		return h.ServerName
+	}
+	return h.Addr
+}
+
+// TLS record and handshake constants of RFC 8446 and RFC 6066.
+const (
+	tlsRecordHandshake  = 0x16
+	tlsClientHello      = 0x01
+	tlsRecordHeaderLen  = 5
+	tlsMaxHandshakeSize = 1 << 16
+
+	extServerName        = 0x0000
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+)
+
+var errTLSMalformed = errors.New("malformed TLS ClientHello")
+
+// isTLSHandshake reports whether the record header begins a TLS handshake.
+func isTLSHandshake(header []byte) bool {
+	return len(header) >= 3 && header[0] == tlsRecordHandshake && header[1] == 3 && header[2] <= 4
+}
+
+// clientHello is the subset of a ClientHello banken records.
+type clientHello struct {
+	serverName string
+	alpn       []string
+	version    uint16
+}
+
+// readClientHello reads TLS records from r until a complete handshake message
+// is available, then parses it if it is a ClientHello. ok is false for other
+// handshake messages, eg: the ServerHello of the other direction.
+func readClientHello(r *bufio.Reader) (hello clientHello, ok bool, err error) {
+	var msg []byte
+	for {
+		header := make([]byte, tlsRecordHeaderLen)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return hello, false, err
+		}
+		if !isTLSHandshake(header) {
+			return hello, false, errTLSMalformed
+		}
+		fragment := make([]byte, binary.BigEndian.Uint16(header[3:]))
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return hello, false, err
+		}
+		msg = append(msg, fragment...)
+		// Handshake messages may be fragmented across records.
+		if len(msg) >= 4 {
+			size := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if size > tlsMaxHandshakeSize {
+				return hello, false, errTLSMalformed
+			}
+			if len(msg) >= 4+size {
+				if msg[0] != tlsClientHello {
+					return hello, false, nil
+				}
+				hello, err = parseClientHello(msg[4 : 4+size])
+				return hello, err == nil, err
+			}
+		}
+	}
+}
+
+// parseClientHello decodes the body of a ClientHello handshake message.
+func parseClientHello(b []byte) (clientHello, error) {
+	var hello clientHello
+	s := tlsReader(b)
+	version, ok := s.uint16()
+	if !ok || !s.skip(32) { // random
+		return hello, errTLSMalformed
+	}
+	hello.version = version
+	if _, ok := s.vector8(); !ok { // session id
+		return hello, errTLSMalformed
+	}
+	if _, ok := s.vector16(); !ok { // cipher suites
+		return hello, errTLSMalformed
+	}
+	if _, ok := s.vector8(); !ok { // compression methods
+		return hello, errTLSMalformed
+	}
+	if len(s) == 0 {
+		// Extensions are optional.
+		return hello, nil
+	}
+	exts, ok := s.vector16()
+	if !ok {
+		return hello, errTLSMalformed
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.uint16()
+		if !ok {
+			return hello, errTLSMalformed
+		}
+		data, ok := exts.vector16()
+		if !ok {
+			return hello, errTLSMalformed
+		}
+		switch typ {
+		case extServerName:
+			names, ok := data.vector16()
+			for ok && len(names) > 0 {
+				var nameType uint8
+				var name tlsReader
+				if nameType, ok = names.uint8(); !ok {
+					break
+				}
+				if name, ok = names.vector16(); ok && nameType == 0 {
+					hello.serverName = string(name)
+				}
+			}
+			if !ok {
+				return hello, errTLSMalformed
+			}
+		case extALPN:
+			protos, ok := data.vector16()
+			for ok && len(protos) > 0 {
+				var proto tlsReader
+				if proto, ok = protos.vector8(); ok {
+					hello.alpn = append(hello.alpn, string(proto))
+				}
+			}
+			if !ok {
+				return hello, errTLSMalformed
+			}
+		case extSupportedVersions:
+			versions, ok := data.vector8()
+			for ok && len(versions) > 0 {
+				var v uint16
+				if v, ok = versions.uint16(); ok && !isGREASE(v) && v > hello.version {
+					hello.version = v
+				}
+			}
+			if !ok {
+				return hello, errTLSMalformed
+			}
+		}
+	}
+	return hello, nil
+}
+
+// isGREASE reports whether v is a reserved value clients send to exercise
+// server extensibility, RFC 8701.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// tlsVersionName formats the protocol version of a ClientHello.
+func tlsVersionName(v uint16) string {
+	switch v {
+	case 0x0300:
+		return "SSL 3.0"
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
+
+// tlsReader consumes the big-endian integers and length prefixed vectors
+// of TLS messages.
+type tlsReader []byte
+
+func (s *tlsReader) uint8() (uint8, bool) {
+	if len(*s) < 1 {
+		return 0, false
+	}
+	v := (*s)[0]
+	*s = (*s)[1:]
+	return v, true
+}
+
+func (s *tlsReader) uint16() (uint16, bool) {
+	if len(*s) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*s)
+	*s = (*s)[2:]
+	return v, true
+}
+
+func (s *tlsReader) skip(n int) bool {
+	if len(*s) < n {
+		return false
+	}
+	*s = (*s)[n:]
+	return true
+}
+
+func (s *tlsReader) vector(n int) (tlsReader, bool) {
+	if len(*s) < n {
+		return nil, false
+	}
+	v := (*s)[:n]
+	*s = (*s)[n:]
+	return v, true
+}
+
+func (s *tlsReader) vector8() (tlsReader, bool) {
+	n, ok := s.uint8()
+	if !ok {
+		return nil, false
+	}
+	return s.vector(int(n))
+}
+
+func (s *tlsReader) vector16() (tlsReader, bool) {
+	n, ok := s.uint16()
+	if !ok {
+		return nil, false
+	}
+	return s.vector(int(n))
+}
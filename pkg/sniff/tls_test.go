@@ -0,0 +1,105 @@
+package sniff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// recordClientHello returns the ClientHello handshake message crypto/tls
+// sends for config, without its record header.
+func recordClientHello(t *testing.T, config *tls.Config) []byte {
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, config).Handshake()
+		c.Close()
+	}()
+	header := make([]byte, tlsRecordHeaderLen)
+	if _, err := io.ReadFull(s, header); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	if _, err := io.ReadFull(s, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// records frames the handshake message into TLS records of at most size
+// bytes.
+func records(msg []byte, size int) []byte {
+	var b []byte
+	for len(msg) > 0 {
+		n := size
+		if len(msg) < n {
+			n = len(msg)
+		}
+		b = append(b, tlsRecordHandshake, 3, 1, byte(n>>8), byte(n))
+		b = append(b, msg[:n]...)
+		msg = msg[n:]
+	}
+	return b
+}
+
+func TestReadClientHello(t *testing.T) {
+	msg := recordClientHello(t, &tls.Config{ServerName: "rusutsu.com", NextProtos: []string{"h2", "http/1.1"}})
+	exp := clientHello{serverName: "rusutsu.com", alpn: []string{"h2", "http/1.1"}, version: 0x0304}
+
+	for _, size := range []int{len(msg), 100, 3} {
+		hello, ok, err := readClientHello(bufio.NewReader(bytes.NewReader(records(msg, size))))
+		if err != nil || !ok {
+			t.Fatalf("record size %d: ok %v err %v", size, ok, err)
+		}
+		if !reflect.DeepEqual(hello, exp) {
+			t.Errorf("record size %d: %+v != %+v", size, hello, exp)
+		}
+	}
+
+	if !isTLSHandshake(records(msg, 100)) || isTLSHandshake([]byte("HTTP/")) || isTLSHandshake([]byte("GET /")) {
+		t.Error("TLS handshakes are not distinguished from HTTP")
+	}
+
+	// A ServerHello is skipped.
+	serverHello := append([]byte{0x02}, msg[1:]...)
+	if _, ok, err := readClientHello(bufio.NewReader(bytes.NewReader(records(serverHello, 100)))); ok || err != nil {
+		t.Errorf("ServerHello was parsed: ok %v err %v", ok, err)
+	}
+
+	// A truncated capture ends the read.
+	if _, _, err := readClientHello(bufio.NewReader(bytes.NewReader(records(msg, 100)[:60]))); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated ClientHello err %v", err)
+	}
+
+	// Vectors overrunning the message are rejected.
+	corrupt := append([]byte(nil), msg[:4+40]...)
+	corrupt[1], corrupt[2], corrupt[3] = 0, 0, 40
+	if _, _, err := readClientHello(bufio.NewReader(bytes.NewReader(records(corrupt, len(corrupt))))); err != errTLSMalformed {
+		t.Errorf("corrupt ClientHello err %v", err)
+	}
+}
+
+func TestTLSVersions(t *testing.T) {
+	hello := recordClientHello(t, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	h, err := parseClientHello(hello[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.serverName != "" || tlsVersionName(h.version) != "TLS 1.2" {
+		t.Errorf("unexpected ClientHello %+v", h)
+	}
+
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGREASE(v) {
+			t.Errorf("%#04x is GREASE", v)
+		}
+	}
+	if isGREASE(0x0304) || isGREASE(0x0a1a) {
+		t.Error("versions mistaken for GREASE")
+	}
+}
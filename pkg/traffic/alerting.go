@@ -2,90 +2,94 @@ package traffic
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-var _ (Notification) = (*Alert)(nil)
-var _ (Notification) = (*NominalStatus)(nil)
-var _ (Notification) = (*NilStatus)(nil)
-
-// Notification of AlertDetector state back to caller.
-type Notification interface {
-	String() string
-}
-
-// Alert indicates that HTTP request rate surpassed the limit.
-type Alert struct {
-	hits int
-	ts   time.Time
-}
-
-// Alert formats state of alert to caller.
-func (a Alert) String() string {
-	return fmt.Sprintf("High traffic generated an alert --- hits = %d, triggered at %s", a.hits, a.ts.Format(time.RFC3339))
-}
-
-// NominalStatus indicates normal HTTP request rate conditions.
-type NominalStatus struct {
-	ts time.Time
-}
-
-// String formats state information to watcher.
-func (s NominalStatus) String() string {
-	return fmt.Sprintf("Traffic within nominal parameters - time: %s", s.ts.Format(time.RFC3339))
-}
-
-// NilStatus informs caller that AlertDetector state has exited operation.
-type NilStatus struct{}
-
-func (e NilStatus) String() string {
-	return fmt.Sprintf("state execution has ended: %v", time.Now())
-}
-
 // StateFunc provides clean transitions between
 // code execution paths.
 type StateFunc func(*AlertDetector) StateFunc
 
 // AlertDetector provides notification when traffic
-// breaks nominal throughput limits.
+// breaks nominal throughput limits of its Rule.
 type AlertDetector struct {
 	ctx           context.Context
+	clock         Clock
+	ruleMux       sync.RWMutex
+	rule          Rule
 	monitor       *Monitor
-	upperLimit    int
-	testSpan      time.Duration
-	testTicker    *time.Ticker
+	responses     *Monitor // denominator of error ratio rules
+	testTicker    Ticker
 	checkInterval time.Duration
 	notify        chan Notification
 
-	localInc *uint64
-	flush    *time.Ticker
+	localInc  *uint64
+	localResp *uint64
+	flush     Ticker
+
+	// since is when the state machine last transitioned, read only by the
+	// state goroutine.
+	since time.Time
+	// first is the UnixNano time traffic was first recorded, accessed
+	// atomically.
+	first *int64
 
 	startState StateFunc
 	reqState   chan struct{}
 	getState   chan Notification
+
+	// retest and reflush carry the evaluation and flush intervals of a
+	// reconfigured rule to the goroutines owning the tickers.
+	retest  chan time.Duration
+	reflush chan time.Duration
 }
 
 // NewAlertDetector initializes alerting of events when
 func NewAlertDetector(ctx context.Context, now time.Time, alertThreshold int, notification chan Notification) *AlertDetector {
-	m := NewMonitor()
-	zero := uint64(0)
-	testTick := time.NewTicker(2 * time.Second)
+	return NewAlertDetectorWithClock(ctx, NewWallClock(), alertThreshold, notification)
+}
+
+// NewAlertDetectorWithClock initializes the AlertDetector with the Clock
+// used to timestamp request counts and schedule threshold tests.
+func NewAlertDetectorWithClock(ctx context.Context, clock Clock, alertThreshold int, notification chan Notification) *AlertDetector {
+	return NewRuleDetector(ctx, clock, Rule{
+		Metric:    MetricRequests,
+		Scope:     ScopeTotal,
+		Window:    2 * time.Minute,
+		Threshold: float64(alertThreshold),
+	}, notification)
+}
+
+// NewRuleDetector initializes an AlertDetector notifying when the traffic
+// measured by rule breaks its threshold. The rule is expected to be valid.
+func NewRuleDetector(ctx context.Context, clock Clock, rule Rule, notification chan Notification) *AlertDetector {
+	rule = rule.withDefaults()
+	zero, zeroResp := uint64(0), uint64(0)
+	testTick := clock.NewTicker(rule.Interval)
 
 	ad := &AlertDetector{
 		ctx:        ctx,
-		upperLimit: alertThreshold,
-		testSpan:   2 * time.Minute,
+		clock:      clock,
+		rule:       rule,
 		testTicker: testTick,
-		monitor:    m,
+		monitor:    NewMonitorWithClock(clock),
+		responses:  NewMonitorWithClock(clock),
 		localInc:   &zero,
-		flush:      time.NewTicker(2 * time.Second),
+		localResp:  &zeroResp,
+		flush:      clock.NewTicker(rule.Flush),
+		since:      clock.Time(),
+		first:      new(int64),
 
 		notify:     notification,
 		startState: Nominal,
 		getState:   make(chan Notification, 1),
 		reqState:   make(chan struct{}, 1),
+		retest:     make(chan time.Duration, 1),
+		reflush:    make(chan time.Duration, 1),
 	}
 	go ad.flushIncrements()
 	go ad.runState()
@@ -94,23 +98,34 @@ func NewAlertDetector(ctx context.Context, now time.Time, alertThreshold int, no
 
 // newTestAlertDetector used to configure state for testing.
 func newTestAlertDetector(ctx context.Context, alertThreshold int, notification chan Notification, state StateFunc, timeSpan time.Duration) *AlertDetector {
-	m := NewMonitor()
-	zero := uint64(0)
-	testTick := time.NewTicker(2 * time.Second)
+	clock := NewWallClock()
+	zero, zeroResp := uint64(0), uint64(0)
+	testTick := clock.NewTicker(2 * time.Second)
 
 	ad := &AlertDetector{
-		ctx:        ctx,
-		upperLimit: alertThreshold,
-		testSpan:   timeSpan,
+		ctx:   ctx,
+		clock: clock,
+		rule: Rule{
+			Metric:    MetricRequests,
+			Scope:     ScopeTotal,
+			Window:    timeSpan,
+			Threshold: float64(alertThreshold),
+		}.withDefaults(),
 		testTicker: testTick,
-		monitor:    m,
+		monitor:    NewMonitorWithClock(clock),
+		responses:  NewMonitorWithClock(clock),
 		localInc:   &zero,
-		flush:      time.NewTicker(2 * time.Second),
+		localResp:  &zeroResp,
+		flush:      clock.NewTicker(2 * time.Second),
+		since:      clock.Time(),
+		first:      new(int64),
 
 		notify:     notification,
 		startState: state,
 		reqState:   make(chan struct{}, 1),
 		getState:   make(chan Notification, 1),
+		retest:     make(chan time.Duration, 1),
+		reflush:    make(chan time.Duration, 1),
 	}
 	go ad.flushIncrements()
 	go ad.runState()
@@ -123,6 +138,66 @@ func (a *AlertDetector) Increment(inc int, now time.Time) {
 	atomic.AddUint64(a.localInc, uint64(inc))
 }
 
+// Observe aggregates the Sample's measurement of the detector's Rule,
+// ignoring Samples outside of the rule's scope.
+func (a *AlertDetector) Observe(s Sample) {
+	r := a.Rule()
+	if !r.matches(s) {
+		return
+	}
+	switch r.Metric {
+	case MetricErrorRatio:
+		atomic.AddUint64(a.localInc, uint64(s.Errors))
+		atomic.AddUint64(a.localResp, uint64(s.Responses))
+	case MetricBytes:
+		atomic.AddUint64(a.localInc, uint64(s.Bytes))
+	default:
+		atomic.AddUint64(a.localInc, uint64(s.Requests))
+	}
+}
+
+// Rule returns the alerting rule tested by the detector.
+func (a *AlertDetector) Rule() Rule {
+	a.ruleMux.RLock()
+	defer a.ruleMux.RUnlock()
+	return a.rule
+}
+
+// Reconfigure replaces the detector's rule, eg: to change its window or
+// threshold, keeping the traffic already recorded and the alert state. The
+// rule must be named and measure the same as the current rule.
+func (a *AlertDetector) Reconfigure(r Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	r = r.withDefaults()
+	a.ruleMux.Lock()
+	old := a.rule
+	if r.Name != old.Name || r.Metric != old.Metric || r.Scope != old.Scope || r.Key != old.Key {
+		a.ruleMux.Unlock()
+		return fmt.Errorf("rule %q: the metric, scope and key measured cannot be reconfigured", old.Name)
+	}
+	a.rule = r
+	a.ruleMux.Unlock()
+
+	if r.Interval != old.Interval {
+		a.signal(a.retest, r.Interval)
+	}
+	if r.Flush != old.Flush {
+		a.signal(a.reflush, r.Flush)
+	}
+	return nil
+}
+
+// signal sends the interval to the goroutine owning a ticker, unless the
+// context is closed.
+func (a *AlertDetector) signal(c chan time.Duration, d time.Duration) {
+	select {
+	case <-a.ctx.Done():
+	case c <- d:
+	}
+}
+
 // GetState informs caller of AlertDetector's current operation state.
 // Channels are used to request and return Alert state to protect
 // external mutation of the state value itself.
@@ -138,69 +213,227 @@ func (a *AlertDetector) GetSpanCount(start, end time.Time) int {
 	return a.monitor.RangeSum(start, end)
 }
 
+// GetSpanCounts provides the occurrence counts of num consecutive spans
+// ending now, ordered oldest first, eg: for charting traffic over time.
+func (a *AlertDetector) GetSpanCounts(span time.Duration, num int) []float64 {
+	return a.monitor.RecentSums(span, num)
+}
+
+// Flush records the pending increments into the monitor immediately rather
+// than waiting for the next flush tick.
+func (a *AlertDetector) Flush() {
+	a.flushAt(a.clock.Time())
+}
+
+func (a *AlertDetector) flushAt(now time.Time) {
+	// Extract the current value, and zero the localInc variable.
+	inc := atomic.SwapUint64(a.localInc, uint64(0))
+	if inc > 0 {
+		atomic.CompareAndSwapInt64(a.first, 0, now.UnixNano())
+		a.monitor.Increment(int(inc), now)
+	}
+	resp := atomic.SwapUint64(a.localResp, uint64(0))
+	if resp > 0 {
+		a.responses.Increment(int(resp), now)
+	}
+}
+
+// firstRecorded is the time traffic was first recorded by the detector.
+func (a *AlertDetector) firstRecorded() time.Time {
+	if n := atomic.LoadInt64(a.first); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// evaluation is a measurement of the rule's Metric and the limits it is
+// tested against.
+type evaluation struct {
+	value    float64
+	trigger  float64 // alert above
+	recover  float64 // recover below
+	baseline baseline
+}
+
+// evaluate measures the rule's Metric. Anomaly rules alert above the
+// deviation band of their baseline, and never before the baseline is
+// learnt.
+func (a *AlertDetector) evaluate() evaluation {
+	r := a.Rule()
+	e := evaluation{
+		value:   a.value(r),
+		trigger: r.Threshold,
+		recover: r.recoverAt(),
+	}
+	if r.Mode != ModeAnomaly {
+		return e
+	}
+	b, ok := a.baseline(r)
+	if !ok {
+		e.trigger, e.recover = math.Inf(1), math.Inf(1)
+		return e
+	}
+	e.baseline = b
+	e.trigger = math.Max(b.mean+r.Deviation*b.std, r.Threshold)
+	e.recover = e.trigger
+	return e
+}
+
+// value measures the rule's Metric over its trailing window.
+func (a *AlertDetector) value(r Rule) float64 {
+	v := float64(a.monitor.RecentSum(r.Window))
+	switch r.Metric {
+	case MetricErrorRatio:
+		resp := a.responses.RecentSum(r.Window)
+		if resp == 0 {
+			return 0
+		}
+		return v / float64(resp)
+	case MetricBytes:
+		return v / r.Window.Seconds()
+	default:
+		return v
+	}
+}
+
+// dwelled reports whether the state machine has remained in its current
+// state for the rule's minimum dwell time.
+func (a *AlertDetector) dwelled(now time.Time) bool {
+	return now.Sub(a.since) >= a.Rule().Dwell
+}
+
+// transition records the time the state machine changed state.
+func (a *AlertDetector) transition(now time.Time) {
+	a.since = now
+}
+
+// status describes the evaluation of the rule for notifications.
+func (a *AlertDetector) status(ts time.Time, e evaluation) status {
+	return status{
+		ts:        ts,
+		rule:      a.Rule(),
+		value:     e.value,
+		threshold: e.trigger,
+		baseline:  e.baseline,
+	}
+}
+
+func (a *AlertDetector) alert(ts time.Time, e evaluation) Alert {
+	return Alert{status: a.status(ts, e)}
+}
+
+func (a *AlertDetector) nominal(ts time.Time, e evaluation) NominalStatus {
+	return NominalStatus{status: a.status(ts, e)}
+}
+
 func (a *AlertDetector) flushIncrements() {
+	defer func() { a.flush.Stop() }()
 	for {
 		select {
 		case <-a.ctx.Done():
 			// Context closed, exit incrementing
 			return
-		case now := <-a.flush.C:
-			// Extract the current value, and zero the localInc variable.
-			inc := atomic.SwapUint64(a.localInc, uint64(0))
-			if inc > 0 {
-				a.monitor.Increment(int(inc), now)
-			}
+		case now := <-a.flush.C():
+			a.flushAt(now)
+		case d := <-a.reflush:
+			a.flush.Stop()
+			a.flush = a.clock.NewTicker(d)
 		}
 	}
 }
 
+// retestEvery replaces the ticker scheduling evaluation of the rule, called
+// by the state goroutine.
+func (a *AlertDetector) retestEvery(d time.Duration) {
+	a.testTicker.Stop()
+	a.testTicker = a.clock.NewTicker(d)
+}
+
 // runState operates the alert state transition logic.
 func (a *AlertDetector) runState() {
+	defer func() { a.testTicker.Stop() }()
 	state := a.startState
 	for state != nil {
 		state = state(a)
 	}
 }
 
-// Nominal state tests the monitor time span's request count
-// against the upperLimit alerting threshold.
-// iff threshold is broken, switch to Alerting state and notify
-// output.
+// Nominal state tests the rule's metric over its window
+// against the alerting threshold, or the baseline of anomaly rules.
+// iff threshold is broken after the rule's dwell time, switch to
+// Alerting state and notify output.
 func Nominal(a *AlertDetector) StateFunc {
 	for {
 		select {
 		case <-a.ctx.Done():
 			return nil
 		case <-a.reqState:
-			a.getState <- NominalStatus{ts: time.Now()}
-		case now := <-a.testTicker.C:
-			v := a.monitor.RecentSum(a.testSpan)
-			if v > a.upperLimit { // Alerting threshold triggered
-				a.notify <- Alert{ts: now, hits: v}
+			a.getState <- a.nominal(a.clock.Time(), a.evaluate())
+		case d := <-a.retest:
+			a.retestEvery(d)
+		case now := <-a.testTicker.C():
+			e := a.evaluate()
+			if e.value > e.trigger && a.dwelled(now) { // Alerting threshold triggered
+				a.transition(now)
+				a.notify <- a.alert(now, e)
 				return Alerted
 			}
 		}
 	}
 }
 
-// Alerted state periodically notifies the output that number of
-// requests still exceeds the allowed upperLimit.
-// iff monitored timespan request count drops below the uppperLimit
-// the state returns to Nominal and notifies output.
+// Alerted state periodically notifies the output that the rule's
+// metric still exceeds the allowed threshold.
+// iff the metric drops below the rule's recovery value after the
+// rule's dwell time, the state returns to Nominal and notifies output.
 func Alerted(a *AlertDetector) StateFunc {
 	for {
 		select {
 		case <-a.ctx.Done():
 			return nil
 		case <-a.reqState:
-			v := a.monitor.RecentSum(a.testSpan)
-			a.getState <- Alert{ts: time.Now(), hits: v}
-		case now := <-a.testTicker.C:
-			v := a.monitor.RecentSum(a.testSpan)
-			if v < a.upperLimit {
-				a.notify <- NominalStatus{ts: now}
+			a.getState <- a.alert(a.clock.Time(), a.evaluate())
+		case d := <-a.retest:
+			a.retestEvery(d)
+		case now := <-a.testTicker.C():
+			if e := a.evaluate(); e.value < e.recover && a.dwelled(now) {
+				a.transition(now)
+				a.notify <- a.nominal(now, e)
 				return Nominal
 			}
 		}
 	}
 }
+
+// detectorJSON is the JSON encoding of the traffic an AlertDetector
+// recorded.
+type detectorJSON struct {
+	First     time.Time `json:"first,omitempty"`
+	Monitor   *Monitor  `json:"monitor"`
+	Responses *Monitor  `json:"responses"`
+}
+
+// MarshalJSON encodes the traffic recorded by the detector, including
+// pending increments, so the history anomaly rules learn from survives
+// restarts.
+func (a *AlertDetector) MarshalJSON() ([]byte, error) {
+	a.Flush()
+	return json.Marshal(detectorJSON{
+		First:     a.firstRecorded(),
+		Monitor:   a.monitor,
+		Responses: a.responses,
+	})
+}
+
+// UnmarshalJSON replaces the traffic recorded by the detector with that
+// encoded by MarshalJSON.
+func (a *AlertDetector) UnmarshalJSON(data []byte) error {
+	j := detectorJSON{Monitor: a.monitor, Responses: a.responses}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if !j.First.IsZero() {
+		atomic.StoreInt64(a.first, j.First.UnixNano())
+	}
+	return nil
+}
@@ -85,3 +85,194 @@ func TestExitAlertStatus(t *testing.T) {
 		t.Errorf("notification should be a NominalStatus: %v", expNotification)
 	}
 }
+
+func TestRuleDetectors(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	clock := NewPacketClock()
+	clock.Advance(start)
+	notify := make(chan Notification, 2)
+
+	ski := NewRuleDetector(ctx, clock, Rule{
+		Name: "ski", Metric: MetricRequests, Scope: ScopeSection, Key: "http://rusutsu.com/ski",
+		Window: time.Minute, Threshold: 5,
+	}, notify)
+	errs := NewRuleDetector(ctx, clock, Rule{
+		Name: "errors", Metric: MetricErrorRatio, Scope: ScopeHost, Key: "rusutsu.com",
+		Window: time.Minute, Threshold: 0.5, Recover: 0.2,
+	}, notify)
+
+	observe := func(section string, n, errors int) {
+		for i := 0; i < n; i++ {
+			s := Sample{TS: clock.Time(), Host: "rusutsu.com", Section: section, Requests: 1, Responses: 1}
+			if i < errors {
+				s.Errors = 1
+			}
+			ski.Observe(s)
+			errs.Observe(s)
+		}
+		ski.Flush()
+		errs.Flush()
+	}
+	tick := 0
+	evaluate := func() {
+		tick++
+		clock.Advance(start.Add(time.Duration(tick) * 2 * time.Second))
+	}
+	assertState := func(ad *AlertDetector, exp Notification) {
+		t.Helper()
+		if s := ad.GetState(); reflect.TypeOf(s) != reflect.TypeOf(exp) {
+			t.Errorf("rule %q state %v is not a %T", ad.Rule().Name, s, exp)
+		}
+	}
+
+	// Other sections' traffic and a 40% error ratio are within both rules.
+	observe("http://rusutsu.com/lift", 10, 4)
+	evaluate()
+	assertState(ski, NominalStatus{})
+	assertState(errs, NominalStatus{})
+
+	// Six failing requests to the ski section break both rules.
+	observe("http://rusutsu.com/ski", 6, 6)
+	evaluate()
+	alerted := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-notify:
+			a, ok := n.(Alert)
+			if !ok {
+				t.Fatalf("notification should be an alert: %v", n)
+			}
+			alerted[a.RuleName()] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("rules did not alert")
+		}
+	}
+	if !alerted["ski"] || !alerted["errors"] {
+		t.Errorf("unexpected alerts: %v", alerted)
+	}
+
+	// A 33% error ratio is below the threshold, but not the recover limit.
+	observe("http://rusutsu.com/lift", 14, 0)
+	evaluate()
+	assertState(errs, Alert{})
+
+	// 17% recovers.
+	observe("http://rusutsu.com/lift", 30, 0)
+	evaluate()
+	select {
+	case n := <-notify:
+		if s, ok := n.(NominalStatus); !ok || s.RuleName() != "errors" {
+			t.Errorf("expected errors rule to recover: %v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("errors rule did not recover")
+	}
+	assertState(ski, Alert{})
+}
+
+func TestRuleDwell(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	clock := NewPacketClock()
+	clock.Advance(start)
+	notify := make(chan Notification, 1)
+
+	ad := NewRuleDetector(ctx, clock, Rule{
+		Name: "dwell", Metric: MetricRequests, Scope: ScopeTotal,
+		Window: 2 * time.Second, Threshold: 5, Interval: time.Second, Flush: time.Second, Dwell: 4 * time.Second,
+	}, notify)
+	at := func(s int, reqs int) Notification {
+		now := start.Add(time.Duration(s) * time.Second)
+		if reqs > 0 {
+			ad.Increment(reqs, now)
+		}
+		ad.flushAt(clock.Time())
+		clock.Advance(now)
+		return ad.GetState()
+	}
+
+	// The threshold is broken straight away, but the detector must first
+	// dwell in the Nominal state.
+	for s := 1; s < 4; s++ {
+		if state := at(s, 10); reflect.TypeOf(state) != reflect.TypeOf(NominalStatus{}) {
+			t.Fatalf("alerted before dwelling at %ds: %v", s, state)
+		}
+	}
+	at(4, 10)
+	if n := <-notify; reflect.TypeOf(n) != reflect.TypeOf(Alert{}) {
+		t.Fatalf("expected an alert after dwelling: %v", n)
+	}
+
+	// Traffic stops, the alert is held for the dwell time before recovering.
+	for s := 5; s < 8; s++ {
+		if state := at(s, 0); reflect.TypeOf(state) != reflect.TypeOf(Alert{}) {
+			t.Fatalf("recovered before dwelling at %ds: %v", s, state)
+		}
+	}
+	at(8, 0)
+	select {
+	case n := <-notify:
+		if reflect.TypeOf(n) != reflect.TypeOf(NominalStatus{}) {
+			t.Errorf("expected recovery: %v", n)
+		}
+	default:
+		t.Error("alert did not recover after dwelling")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	clock := NewPacketClock()
+	clock.Advance(start)
+	notify := make(chan Notification, 1)
+
+	rule := Rule{
+		Name: "ski", Metric: MetricRequests, Scope: ScopeSection, Key: "http://rusutsu.com/ski",
+		Window: 10 * time.Second, Threshold: 100, Interval: time.Second, Flush: time.Second,
+	}
+	ad := NewRuleDetector(ctx, clock, rule, notify)
+	for s := 1; s <= 5; s++ {
+		now := start.Add(time.Duration(s) * time.Second)
+		ad.Observe(Sample{TS: now, Section: rule.Key, Requests: 10})
+		ad.flushAt(now)
+		clock.Advance(now)
+	}
+	if state := ad.GetState(); reflect.TypeOf(state) != reflect.TypeOf(NominalStatus{}) {
+		t.Fatalf("alerted below the threshold: %v", state)
+	}
+
+	// Lowering the threshold alerts on the traffic already recorded.
+	rule.Threshold = 20
+	rule.Interval = 2 * time.Second
+	if err := ad.Reconfigure(rule); err != nil {
+		t.Fatal(err)
+	}
+	if r := ad.Rule(); r.Threshold != 20 || r.Interval != 2*time.Second {
+		t.Errorf("rule was not reconfigured: %+v", r)
+	}
+	for s := 6; s <= 10; s++ {
+		clock.Advance(start.Add(time.Duration(s) * time.Second))
+	}
+	select {
+	case n := <-notify:
+		if reflect.TypeOf(n) != reflect.TypeOf(Alert{}) || n.Value() != 50 || n.Threshold() != 20 {
+			t.Errorf("unexpected notification: %v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconfigured threshold did not alert")
+	}
+
+	rule.Key = "http://rusutsu.com/lift"
+	if err := ad.Reconfigure(rule); err == nil {
+		t.Error("the measured key was reconfigured")
+	}
+	rule.Key, rule.Window = "http://rusutsu.com/ski", 0
+	if err := ad.Reconfigure(rule); err == nil {
+		t.Error("an invalid rule was accepted")
+	}
+}
@@ -0,0 +1,93 @@
+package traffic
+
+import (
+	"math"
+	"time"
+)
+
+// baseline is the expected value of a rule's metric, learnt as the
+// exponentially weighted moving average and standard deviation of the
+// metric over the rule's preceding windows.
+type baseline struct {
+	mean float64
+	std  float64
+}
+
+// ewma computes the baseline of the values ordered oldest first, weighting
+// recent values more heavily. The smoothing factor spans the whole history.
+func ewma(values []float64) baseline {
+	if len(values) == 0 {
+		return baseline{}
+	}
+	alpha := 2 / (float64(len(values)) + 1)
+	mean, variance := values[0], 0.0
+	for _, v := range values[1:] {
+		diff := v - mean
+		incr := alpha * diff
+		mean += incr
+		variance = (1 - alpha) * (variance + diff*incr)
+	}
+	return baseline{mean: mean, std: math.Sqrt(variance)}
+}
+
+// baseline learns the expected metric of an anomaly rule from the windows
+// preceding the current one. Windows before the detector first recorded
+// traffic are excluded, so ok is false until enough history is observed.
+//
+// Long histories are only retained in coarser buckets than the window, eg:
+// hours, which are sampled whole as apportioning them evenly across their
+// windows would hide the variation between windows. Each sample is scaled
+// to a window, and as a bucket averages independent windows the variance
+// of the windows is the variance of the samples times the windows per
+// bucket.
+func (a *AlertDetector) baseline(r Rule) (b baseline, ok bool) {
+	first := a.firstRecorded()
+	if first.IsZero() {
+		return baseline{}, false
+	}
+	w := r.Window
+	// The trailing window is the current measurement, not history.
+	end := a.clock.Time().Add(-w)
+	num := int(r.Baseline / w)
+	if n := int(end.Sub(first) / w); n < num {
+		num = n
+	}
+	if num < minBaselineWindows {
+		return baseline{}, false
+	}
+	start := end.Add(-w * time.Duration(num))
+	samples, span := num, w
+	if res := a.monitor.Resolution(start); res > w {
+		// Sub-second windows are finer than any bucket, and their whole
+		// history may fit within one.
+		if samples = int(end.Sub(start) / res); samples < 1 {
+			samples = 1
+		}
+		span = end.Sub(start) / time.Duration(samples)
+	}
+	counts := a.monitor.RangeSums(start, end, samples)
+	var responses []float64
+	if r.Metric == MetricErrorRatio {
+		responses = a.responses.RangeSums(start, end, samples)
+	}
+
+	history := make([]float64, 0, samples)
+	for i, c := range counts {
+		switch r.Metric {
+		case MetricErrorRatio:
+			if responses[i] == 0 {
+				c = 0
+			} else {
+				c /= responses[i]
+			}
+		case MetricBytes:
+			c /= span.Seconds()
+		default:
+			c *= float64(w) / float64(span)
+		}
+		history = append(history, c)
+	}
+	b = ewma(history)
+	b.std *= math.Sqrt(float64(span) / float64(w))
+	return b, true
+}
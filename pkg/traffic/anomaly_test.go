@@ -0,0 +1,161 @@
+package traffic
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	b := ewma([]float64{10, 10, 10, 10})
+	if b.mean != 10 || b.std != 0 {
+		t.Errorf("constant baseline: %+v", b)
+	}
+
+	b = ewma([]float64{8, 12, 8, 12, 8, 12, 8, 12})
+	if math.Abs(b.mean-10) > 1 || b.std < 1 || b.std > 3 {
+		t.Errorf("alternating baseline: %+v", b)
+	}
+
+	// Recent values carry more weight.
+	if b := ewma([]float64{0, 0, 0, 10, 10, 10}); b.mean < 5 {
+		t.Errorf("baseline did not follow recent values: %+v", b)
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	clock := NewPacketClock()
+	clock.Advance(start)
+	notify := make(chan Notification, 4)
+
+	ad := NewRuleDetector(ctx, clock, Rule{
+		Name: "anomaly", Mode: ModeAnomaly, Metric: MetricRequests, Scope: ScopeTotal,
+		Window: 10 * time.Second, Baseline: 2 * time.Minute, Threshold: 20,
+		Interval: 10 * time.Second, Flush: time.Second,
+	}, notify)
+
+	s := 0
+	run := func(seconds int, rate func(s int) int) {
+		for end := s + seconds; s < end; s++ {
+			now := start.Add(time.Duration(s) * time.Second)
+			ad.Increment(rate(s), now)
+			ad.flushAt(now)
+			clock.Advance(now)
+		}
+	}
+	steady := func(s int) int { return 2 + s%3 }
+	assertState := func(exp Notification) {
+		t.Helper()
+		if state := ad.GetState(); reflect.TypeOf(state) != reflect.TypeOf(exp) {
+			t.Fatalf("state at %ds %v is not a %T", s, state, exp)
+		}
+	}
+
+	// Steady traffic of ~30 requests per window is learnt, not alerted on
+	// although it breaks the Threshold.
+	run(180, steady)
+	assertState(NominalStatus{})
+	select {
+	case n := <-notify:
+		t.Fatalf("steady traffic alerted: %v", n)
+	default:
+	}
+
+	// Tripling traffic deviates from the baseline.
+	run(10, func(int) int { return 10 })
+	assertState(Alert{})
+	n := <-notify
+	if a, ok := n.(Alert); !ok || !strings.Contains(a.String(), "Anomalous traffic") || a.baseline.mean < 20 || a.baseline.mean > 40 {
+		t.Fatalf("unexpected anomaly notification: %v", n)
+	}
+
+	// Returning to steady traffic recovers.
+	run(20, steady)
+	assertState(NominalStatus{})
+	if n := <-notify; reflect.TypeOf(n) != reflect.TypeOf(NominalStatus{}) {
+		t.Errorf("expected recovery: %v", n)
+	}
+}
+
+func TestLongBaseline(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	clock := NewPacketClock()
+	clock.Advance(start)
+	rule := Rule{
+		Name: "anomaly", Mode: ModeAnomaly, Metric: MetricRequests, Scope: ScopeTotal,
+		Window: time.Minute, Baseline: 12 * time.Hour, Interval: time.Minute, Flush: time.Second,
+	}
+	notify := make(chan Notification)
+	go func() {
+		for range notify {
+		}
+	}()
+	ad := NewRuleDetector(ctx, clock, rule, notify)
+
+	// Independent counts per minute, of standard deviation ~6.
+	rnd := rand.New(rand.NewSource(1))
+	for m := 0; m <= 12*60; m++ {
+		now := start.Add(time.Duration(m) * time.Minute)
+		ad.Increment(rnd.Intn(21), now)
+		ad.flushAt(now)
+		clock.Advance(now)
+	}
+
+	// The history is only retained in hour buckets, whose variation is
+	// scaled back to the minute windows.
+	if res := ad.monitor.Resolution(clock.Time().Add(-rule.Baseline)); res != time.Hour {
+		t.Fatalf("baseline read from %s buckets, expected hours", res)
+	}
+	b, ok := ad.baseline(rule)
+	if !ok {
+		t.Fatal("no baseline learnt from 12h of history")
+	}
+	if b.mean < 8 || b.mean > 12 || b.std < 3 || b.std > 12 {
+		t.Errorf("baseline %+v, expected a mean of ~10 and deviation of ~6", b)
+	}
+}
+
+func TestSubSecondBaseline(t *testing.T) {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	clock := NewPacketClock()
+	clock.Advance(start)
+	rule := Rule{
+		Name: "anomaly", Mode: ModeAnomaly, Metric: MetricRequests, Scope: ScopeTotal,
+		Window: 100 * time.Millisecond, Baseline: 500 * time.Millisecond, Interval: time.Second, Flush: time.Second,
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	notify := make(chan Notification)
+	go func() {
+		for range notify {
+		}
+	}()
+	ad := NewRuleDetector(ctx, clock, rule, notify)
+
+	// The history fits within a single second bucket.
+	for ms := 0; ms <= 700; ms += 100 {
+		now := start.Add(time.Duration(ms) * time.Millisecond)
+		ad.Increment(2, now)
+		ad.flushAt(now)
+		clock.Advance(now)
+	}
+	b, ok := ad.baseline(rule)
+	if !ok {
+		t.Fatal("no baseline learnt from 700ms of history")
+	}
+	if b.mean != 2 {
+		t.Errorf("baseline %+v, expected a mean of 2", b)
+	}
+}
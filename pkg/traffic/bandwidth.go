@@ -0,0 +1,168 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic/internal/timeseries"
+)
+
+// Bandwidth is the bytes transferred for a key in each direction.
+type Bandwidth struct {
+	Sent     int64
+	Received int64
+}
+
+// Total is the bytes transferred in both directions.
+func (b Bandwidth) Total() int64 {
+	return b.Sent + b.Received
+}
+
+// BandwidthTracker records the bytes sent and received per key, such as
+// remote hosts or sections, into multi-resolution time series so top
+// talkers can be queried over trailing windows. Memory is bounded by Prune,
+// like a WindowCounter's; the bytes of pruned keys are no longer totalled.
+type BandwidthTracker struct {
+	clock Clock
+	max   int
+	keys  sync.Map
+}
+
+// bandwidthSeries wraps a key's timeseries, which are not concurrency-safe.
+type bandwidthSeries struct {
+	mux      sync.Mutex
+	sent     *timeseries.TimeSeries
+	received *timeseries.TimeSeries
+	last     *int64 // UnixNano of the latest bytes, accessed atomically
+}
+
+// NewBandwidthTracker initializes a tracker reading the current time from c,
+// which Prune bounds to max keys.
+func NewBandwidthTracker(c Clock, max int) *BandwidthTracker {
+	return &BandwidthTracker{clock: c, max: max}
+}
+
+func (b *BandwidthTracker) newSeries() *bandwidthSeries {
+	return &bandwidthSeries{
+		sent:     timeseries.NewTimeSeriesWithClock(timeseries.NewFloat, b.clock),
+		received: timeseries.NewTimeSeriesWithClock(timeseries.NewFloat, b.clock),
+		last:     new(int64),
+	}
+}
+
+// Observe records sent and received bytes for key at time t.
+func (b *BandwidthTracker) Observe(key string, sent, received int64, t time.Time) {
+	s, ok := b.keys.Load(key)
+	if !ok {
+		s, _ = b.keys.LoadOrStore(key, b.newSeries())
+	}
+	bs := s.(*bandwidthSeries)
+	bs.mux.Lock()
+	if sent != 0 {
+		f := timeseries.Float(sent)
+		bs.sent.AddWithTime(&f, t)
+	}
+	if received != 0 {
+		f := timeseries.Float(received)
+		bs.received.AddWithTime(&f, t)
+	}
+	bs.mux.Unlock()
+	if n := t.UnixNano(); n > atomic.LoadInt64(bs.last) {
+		atomic.StoreInt64(bs.last, n)
+	}
+}
+
+// Recent totals the bytes of each key transferred within the trailing
+// window. Keys without bytes in the window are omitted.
+func (b *BandwidthTracker) Recent(window time.Duration) map[string]Bandwidth {
+	return b.export(func(ts *timeseries.TimeSeries) timeseries.Observable {
+		return ts.Recent(window)
+	})
+}
+
+// Total totals all of the bytes transferred for each key.
+func (b *BandwidthTracker) Total() map[string]Bandwidth {
+	return b.export(func(ts *timeseries.TimeSeries) timeseries.Observable {
+		return ts.Total()
+	})
+}
+
+// Prune removes the keys without bytes within MaxWindow, then the least
+// recently seen keys until at most the tracker's capacity remain. It returns
+// the number of keys removed.
+func (b *BandwidthTracker) Prune() int {
+	return pruneRecent(&b.keys, b.max, b.clock.Time().Add(-MaxWindow), func(v interface{}) int64 {
+		return atomic.LoadInt64(v.(*bandwidthSeries).last)
+	})
+}
+
+func (b *BandwidthTracker) export(query func(*timeseries.TimeSeries) timeseries.Observable) map[string]Bandwidth {
+	output := make(map[string]Bandwidth)
+	b.keys.Range(func(key, value interface{}) bool {
+		bs := value.(*bandwidthSeries)
+		bs.mux.Lock()
+		bw := Bandwidth{
+			Sent:     int64(query(bs.sent).(*timeseries.Float).Value() + 0.5),
+			Received: int64(query(bs.received).(*timeseries.Float).Value() + 0.5),
+		}
+		bs.mux.Unlock()
+		if bw.Total() > 0 {
+			output[key.(string)] = bw
+		}
+		return true
+	})
+	return output
+}
+
+// bandwidthJSON is the JSON encoding of a key's bandwidthSeries.
+type bandwidthJSON struct {
+	Sent     json.RawMessage `json:"sent"`
+	Received json.RawMessage `json:"received"`
+}
+
+// MarshalJSON encodes the bytes transferred for each key.
+func (b *BandwidthTracker) MarshalJSON() ([]byte, error) {
+	keys := make(map[string]bandwidthJSON)
+	var err error
+	b.keys.Range(func(key, value interface{}) bool {
+		bs := value.(*bandwidthSeries)
+		var j bandwidthJSON
+		bs.mux.Lock()
+		if j.Sent, err = json.Marshal(bs.sent); err == nil {
+			j.Received, err = json.Marshal(bs.received)
+		}
+		bs.mux.Unlock()
+		keys[key.(string)] = j
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(keys)
+}
+
+// UnmarshalJSON replaces the bytes transferred for the keys encoded by
+// MarshalJSON. Keys are treated as last seen when restored, so idle keys
+// are pruned MaxWindow later.
+func (b *BandwidthTracker) UnmarshalJSON(data []byte) error {
+	keys := make(map[string]bandwidthJSON)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	now := b.clock.Time().UnixNano()
+	for k, j := range keys {
+		bs := b.newSeries()
+		if err := json.Unmarshal(j.Sent, bs.sent); err != nil {
+			return fmt.Errorf("bytes sent of %q: %w", k, err)
+		}
+		if err := json.Unmarshal(j.Received, bs.received); err != nil {
+			return fmt.Errorf("bytes received of %q: %w", k, err)
+		}
+		*bs.last = now
+		b.keys.Store(k, bs)
+	}
+	return nil
+}
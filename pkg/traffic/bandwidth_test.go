@@ -0,0 +1,80 @@
+package traffic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBandwidthTracker(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	bt := NewBandwidthTracker(c, 10)
+
+	// An hour ago the host downloaded heavily, in the last minute it uploads.
+	for i := 0; i < 100; i++ {
+		bt.Observe("rusutsu.com", 10, 1000, start.Add(time.Duration(i)*time.Second))
+	}
+	recent := start.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		bt.Observe("rusutsu.com", 500, 0, recent.Add(time.Duration(i)*time.Second))
+	}
+	bt.Observe("niseko.com", 0, 0, recent)
+	c.Advance(recent.Add(10 * time.Second))
+
+	if bw := bt.Recent(time.Minute)["rusutsu.com"]; bw != (Bandwidth{Sent: 5000}) {
+		t.Errorf("unexpected recent bandwidth: %+v", bw)
+	}
+	if bw := bt.Total()["rusutsu.com"]; bw != (Bandwidth{Sent: 6000, Received: 100000}) || bw.Total() != 106000 {
+		t.Errorf("unexpected total bandwidth: %+v", bw)
+	}
+	if _, ok := bt.Total()["niseko.com"]; ok {
+		t.Error("keys without bytes should be omitted")
+	}
+}
+
+func TestBandwidthSnapshot(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	bt := NewBandwidthTracker(c, 10)
+	bt.Observe("rusutsu.com", 10, 1000, start)
+	c.Advance(start.Add(time.Hour))
+	bt.Observe("rusutsu.com", 500, 0, start.Add(time.Hour))
+
+	data, err := json.Marshal(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Restored later, only the last observation remains recent.
+	c.Advance(start.Add(time.Hour + 10*time.Second))
+	restored := NewBandwidthTracker(c, 10)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if bw := restored.Recent(time.Minute)["rusutsu.com"]; bw != (Bandwidth{Sent: 500}) {
+		t.Errorf("unexpected restored recent bandwidth: %+v", bw)
+	}
+	if bw := restored.Total()["rusutsu.com"]; bw != (Bandwidth{Sent: 510, Received: 1000}) {
+		t.Errorf("unexpected restored total bandwidth: %+v", bw)
+	}
+}
+
+func TestBandwidthPrune(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	bt := NewBandwidthTracker(c, 2)
+	bt.Observe("10.0.0.1", 10, 0, start)
+	for i, host := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		bt.Observe(host, 10, 0, start.Add(MaxWindow+time.Duration(i)*time.Second))
+	}
+	c.Advance(start.Add(MaxWindow + time.Minute))
+
+	// The idle host is pruned, then the least recently seen beyond capacity.
+	if n := bt.Prune(); n != 2 {
+		t.Errorf("pruned %d hosts, expected 2", n)
+	}
+	m := bt.Total()
+	if _, ok := m["10.0.0.3"]; !ok || len(m) != 2 {
+		t.Errorf("unexpected hosts after pruning: %v", m)
+	}
+}
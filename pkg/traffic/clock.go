@@ -0,0 +1,153 @@
+package traffic
+
+import (
+	"sync"
+	"time"
+)
+
+var _ (Clock) = (*nowClock)(nil)
+var _ (Clock) = (*PacketClock)(nil)
+
+// Clock tells the traffic models what time it is, and when to run their
+// periodic work. Live capture uses the wall clock, while replayed captures
+// are driven by the timestamps of the packets read.
+type Clock interface {
+	Time() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a Clock at intervals.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// NewWallClock returns a Clock which reads the system time.
+func NewWallClock() Clock {
+	return &nowClock{}
+}
+
+type nowClock struct{}
+
+func (c *nowClock) Time() time.Time {
+	return time.Now()
+}
+
+func (c *nowClock) NewTicker(d time.Duration) Ticker {
+	return &wallTicker{t: time.NewTicker(d)}
+}
+
+type wallTicker struct {
+	t *time.Ticker
+}
+
+func (w *wallTicker) C() <-chan time.Time { return w.t.C }
+func (w *wallTicker) Stop()               { w.t.Stop() }
+
+// PacketClock is a Clock which only moves forward when Advance is called,
+// used to drive the traffic models with captured packet timestamps.
+//
+// Ticks are delivered synchronously from Advance, so every interval of
+// packet time is observed by the ticker's receiver even when packets are
+// replayed faster than real time.
+type PacketClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	tickers []*packetTicker
+}
+
+// NewPacketClock initializes a PacketClock which has not yet seen a packet.
+func NewPacketClock() *PacketClock {
+	return &PacketClock{}
+}
+
+// Time returns the latest packet timestamp the clock was advanced to.
+func (c *PacketClock) Time() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// NewTicker creates a ticker which fires every d of packet time.
+func (c *PacketClock) NewTicker(d time.Duration) Ticker {
+	t := &packetTicker{
+		clock: c,
+		d:     d,
+		c:     make(chan time.Time),
+		done:  make(chan struct{}),
+	}
+	c.mux.Lock()
+	if !c.now.IsZero() {
+		t.next = c.now.Add(d)
+	}
+	c.tickers = append(c.tickers, t)
+	c.mux.Unlock()
+	return t
+}
+
+// Advance moves the clock forward to t, firing any tickers whose interval
+// has elapsed. Timestamps older than the current time are ignored.
+func (c *PacketClock) Advance(t time.Time) {
+	c.mux.Lock()
+	if !t.After(c.now) {
+		c.mux.Unlock()
+		return
+	}
+	c.now = t
+	tickers := make([]*packetTicker, len(c.tickers))
+	copy(tickers, c.tickers)
+	c.mux.Unlock()
+
+	for _, tk := range tickers {
+		tk.advance(t)
+	}
+}
+
+func (c *PacketClock) remove(t *packetTicker) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, tk := range c.tickers {
+		if tk == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type packetTicker struct {
+	mux      sync.Mutex
+	clock    *PacketClock
+	d        time.Duration
+	next     time.Time
+	c        chan time.Time
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *packetTicker) C() <-chan time.Time { return t.c }
+
+func (t *packetTicker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.clock.remove(t)
+	})
+}
+
+// advance delivers one tick per elapsed interval, blocking until the
+// receiver reads it or the ticker is stopped.
+func (t *packetTicker) advance(now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.next.IsZero() {
+		t.next = now.Add(t.d)
+		return
+	}
+	for !t.next.After(now) {
+		select {
+		case t.c <- t.next:
+		case <-t.done:
+			return
+		}
+		t.next = t.next.Add(t.d)
+	}
+}
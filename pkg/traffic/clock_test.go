@@ -0,0 +1,51 @@
+package traffic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketClockTicks(t *testing.T) {
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	c := NewPacketClock()
+	tk := c.NewTicker(2 * time.Second)
+	defer tk.Stop()
+
+	ticks := make(chan time.Time, 100)
+	go func() {
+		for tick := range tk.C() {
+			ticks <- tick
+		}
+	}()
+
+	// The first packet only starts the clock.
+	c.Advance(start)
+	if !c.Time().Equal(start) {
+		t.Errorf("clock time %v != %v", c.Time(), start)
+	}
+
+	// Old packets do not move the clock backwards.
+	c.Advance(start.Add(-time.Minute))
+	if !c.Time().Equal(start) {
+		t.Errorf("clock moved backwards: %v", c.Time())
+	}
+
+	// Advancing 10 seconds of packet time delivers every 2 second tick.
+	c.Advance(start.Add(10 * time.Second))
+	for i := 1; i <= 5; i++ {
+		select {
+		case tick := <-ticks:
+			exp := start.Add(time.Duration(i) * 2 * time.Second)
+			if !tick.Equal(exp) {
+				t.Errorf("tick %d: %v != %v", i, tick, exp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("tick %d was not delivered", i)
+		}
+	}
+	select {
+	case tick := <-ticks:
+		t.Errorf("unexpected tick: %v", tick)
+	default:
+	}
+}
@@ -0,0 +1,136 @@
+package timeseries
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// snapshot is the JSON encoding of a timeSeries.
+type snapshot struct {
+	LastAdd time.Time       `json:"last_add"`
+	Total   json.RawMessage `json:"total"`
+	Levels  []levelSnapshot `json:"levels"`
+}
+
+// levelSnapshot is the JSON encoding of a tsLevel. Empty buckets are
+// omitted.
+type levelSnapshot struct {
+	Size    time.Duration    `json:"size"`
+	End     time.Time        `json:"end"`
+	Buckets []bucketSnapshot `json:"buckets"`
+}
+
+// bucketSnapshot is a bucket of a level, Age buckets before the newest.
+type bucketSnapshot struct {
+	Age   int             `json:"age"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON encodes the observations of the time series, which the
+// Observable must support, eg: Float and Histogram. Pending observations are
+// bucketed first.
+func (ts *timeSeries) MarshalJSON() ([]byte, error) {
+	ts.mergePendingUpdates()
+	empty, err := json.Marshal(ts.provider())
+	if err != nil {
+		return nil, err
+	}
+	s := snapshot{
+		LastAdd: ts.lastAdd,
+		Levels:  make([]levelSnapshot, 0, len(ts.levels)),
+	}
+	if s.Total, err = json.Marshal(ts.total); err != nil {
+		return nil, err
+	}
+	for _, l := range ts.levels {
+		ls := levelSnapshot{Size: l.size, End: l.end, Buckets: make([]bucketSnapshot, 0)}
+		for age := 0; age < ts.numBuckets; age++ {
+			b := l.buckets[(l.newest-age+ts.numBuckets)%ts.numBuckets]
+			if b == nil {
+				continue
+			}
+			v, err := json.Marshal(b)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(v, empty) {
+				ls.Buckets = append(ls.Buckets, bucketSnapshot{Age: age, Value: v})
+			}
+		}
+		s.Levels = append(s.Levels, ls)
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON replaces the observations of the initialized time series
+// with those encoded by MarshalJSON, which must have the same resolutions.
+// Buckets too old to be retained at the current time are discarded.
+func (ts *timeSeries) UnmarshalJSON(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if len(s.Levels) != len(ts.levels) {
+		return fmt.Errorf("timeseries: snapshot has %d levels, expected %d", len(s.Levels), len(ts.levels))
+	}
+	for i, ls := range s.Levels {
+		if ls.Size != ts.levels[i].size {
+			return fmt.Errorf("timeseries: snapshot level %d resolution is %s, expected %s", i, ls.Size, ts.levels[i].size)
+		}
+	}
+
+	ts.Clear()
+	if len(s.Total) > 0 {
+		if err := json.Unmarshal(s.Total, ts.total); err != nil {
+			return err
+		}
+	}
+	ts.lastAdd = s.LastAdd
+	for i, ls := range s.Levels {
+		l := ts.levels[i]
+		l.end = ls.End
+		for _, b := range ls.Buckets {
+			if b.Age < 0 || b.Age >= ts.numBuckets {
+				continue
+			}
+			o := l.provider()
+			if err := json.Unmarshal(b.Value, o); err != nil {
+				ts.Clear()
+				return err
+			}
+			l.buckets[l.newest-b.Age] = o
+		}
+	}
+	ts.advance(ts.clock.Time())
+	return nil
+}
+
+// histogramJSON is the JSON encoding of a Histogram.
+type histogramJSON struct {
+	Count   float64   `json:"count"`
+	Sum     float64   `json:"sum"`
+	Max     int64     `json:"max"`
+	Buckets []float64 `json:"buckets"`
+}
+
+// MarshalJSON encodes the distribution. Cleared histograms encode as
+// empty, whatever their capacity.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	j := histogramJSON{Count: h.count, Sum: h.sum, Max: h.max}
+	if h.count != 0 {
+		j.Buckets = h.buckets
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON replaces the distribution with one encoded by MarshalJSON.
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var j histogramJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	h.count, h.sum, h.max, h.buckets = j.Count, j.Sum, j.Max, j.Buckets
+	return nil
+}
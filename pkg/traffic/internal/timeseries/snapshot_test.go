@@ -0,0 +1,72 @@
+package timeseries
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	fake := new(mockClock)
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	fake.Set(start)
+	ts := new(TestTimeSeries)
+	ts.timeSeries.init(testResolutions, NewFloat, buckets, fake)
+	for i := 1; i <= 300; i++ {
+		fake.Set(start.Add(time.Duration(i) * time.Second))
+		ob := Float(1)
+		ts.AddWithTime(&ob, fake.Time())
+	}
+	data, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := new(TestTimeSeries)
+	restored.timeSeries.init(testResolutions, NewFloat, buckets, fake)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []time.Duration{30 * time.Second, 5 * time.Minute} {
+		checkApproximate(t, restored.Recent(d), ts.Recent(d).(*Float).Value())
+	}
+	checkApproximate(t, restored.Total(), 300)
+
+	// Restored later, buckets older than a level holds are discarded.
+	fake.Set(start.Add(15 * time.Minute))
+	later := new(TestTimeSeries)
+	later.timeSeries.init(testResolutions, NewFloat, buckets, fake)
+	if err := json.Unmarshal(data, later); err != nil {
+		t.Fatal(err)
+	}
+	checkApproximate(t, later.Latest(0, buckets), 0)
+	checkApproximate(t, later.Latest(2, buckets), 300)
+
+	// Snapshots only restore into the same resolutions.
+	other := NewTimeSeriesWithClock(NewFloat, fake)
+	if err := json.Unmarshal(data, other); err == nil {
+		t.Error("snapshot restored into other resolutions")
+	}
+}
+
+func TestHistogramSnapshot(t *testing.T) {
+	fake := &mockClock{time: tu(100)}
+	ts := NewTimeSeriesWithClock(NewHistogram, fake)
+	for i := int64(0); i < 100; i++ {
+		h := new(Histogram)
+		h.Observe(10 * (i + 1))
+		ts.AddWithTime(h, tu(i+1))
+	}
+	data, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewTimeSeriesWithClock(NewHistogram, fake)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	h, w := restored.Range(tu(0), tu(100)).(*Histogram), ts.Range(tu(0), tu(100)).(*Histogram)
+	if h.Count() != w.Count() || h.Max() != w.Max() || h.Percentile(0.9) != w.Percentile(0.9) {
+		t.Errorf("restored histogram %+v != %+v", h, w)
+	}
+}
@@ -8,6 +8,7 @@ package timeseries
 import (
 	"fmt"
 	"log"
+	"math"
 	"time"
 )
 
@@ -71,6 +72,136 @@ func (f *Float) CopyFrom(other Observable) {
 	*f = *o
 }
 
+// histogramSubBuckets is the number of buckets each power of two is split
+// into, bounding the relative error of estimated percentiles to ~19%.
+const histogramSubBuckets = 4
+
+// Histogram attaches the methods of Observable to a distribution of int64
+// values. Values are counted into exponentially sized buckets, so histograms
+// may be merged and scaled like any other Observable, at the cost of
+// percentiles being estimates.
+type Histogram struct {
+	count   float64   // number of values observed
+	sum     float64   // sum of values observed
+	max     int64     // largest value observed
+	buckets []float64 // value counts, grown as larger values are observed
+}
+
+// NewHistogram returns an empty Histogram.
+func NewHistogram() Observable {
+	return new(Histogram)
+}
+
+// histogramBucket returns the bucket index counting v.
+func histogramBucket(v int64) int {
+	if v <= 0 {
+		return 0
+	}
+	return int(math.Log2(float64(v))*histogramSubBuckets) + 1
+}
+
+// histogramBound returns the lower bound of the values counted in bucket i.
+func histogramBound(i int) float64 {
+	if i <= 0 {
+		return 0
+	}
+	return math.Exp2(float64(i-1) / histogramSubBuckets)
+}
+
+// Observe records the value v into the distribution.
+func (h *Histogram) Observe(v int64) {
+	i := histogramBucket(v)
+	if i >= len(h.buckets) {
+		h.grow(i + 1)
+	}
+	h.buckets[i]++
+	h.count++
+	h.sum += float64(v)
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *Histogram) grow(n int) {
+	b := make([]float64, n)
+	copy(b, h.buckets)
+	h.buckets = b
+}
+
+// Count returns the number of values observed.
+func (h *Histogram) Count() float64 { return h.count }
+
+// Sum returns the sum of the values observed.
+func (h *Histogram) Sum() float64 { return h.sum }
+
+// Max returns the largest value observed.
+func (h *Histogram) Max() int64 { return h.max }
+
+// Percentile estimates the value below which the fraction p of the observed
+// values fall, interpolating within the bucket which contains it.
+func (h *Histogram) Percentile(p float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	target := p * h.count
+	cum := 0.0
+	for i, c := range h.buckets {
+		if c == 0 {
+			continue
+		}
+		if cum+c >= target {
+			lower, upper := histogramBound(i), histogramBound(i+1)
+			v := int64(lower + (upper-lower)*(target-cum)/c)
+			if v > h.max {
+				v = h.max
+			}
+			return v
+		}
+		cum += c
+	}
+	return h.max
+}
+
+func (h *Histogram) Multiply(ratio float64) {
+	h.count *= ratio
+	h.sum *= ratio
+	for i := range h.buckets {
+		h.buckets[i] *= ratio
+	}
+}
+
+func (h *Histogram) Add(other Observable) {
+	o := other.(*Histogram)
+	if len(o.buckets) > len(h.buckets) {
+		h.grow(len(o.buckets))
+	}
+	for i, c := range o.buckets {
+		h.buckets[i] += c
+	}
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+func (h *Histogram) Clear() {
+	h.count = 0
+	h.sum = 0
+	h.max = 0
+	for i := range h.buckets {
+		h.buckets[i] = 0
+	}
+}
+
+func (h *Histogram) CopyFrom(other Observable) {
+	o := other.(*Histogram)
+	h.count = o.count
+	h.sum = o.sum
+	h.max = o.max
+	h.buckets = append(h.buckets[:0], o.buckets...)
+}
+
 // A clock tells the current time.
 type Clock interface {
 	Time() time.Time
@@ -379,6 +510,17 @@ func (ts *timeSeries) ComputeRange(start, finish time.Time, num int) []Observabl
 	return results
 }
 
+// Resolution returns the bucket width of the level ComputeRange reads a
+// range beginning at start from.
+func (ts *timeSeries) Resolution(start time.Time) time.Duration {
+	for _, l := range ts.levels {
+		if !start.Before(l.end.Add(-l.size * time.Duration(ts.numBuckets))) {
+			return l.size
+		}
+	}
+	return ts.levels[len(ts.levels)-1].size
+}
+
 // RecentList returns the specified number of values in slice over the most
 // recent time period of the specified range.
 func (ts *timeSeries) RecentList(delta time.Duration, num int) []Observable {
@@ -168,3 +168,67 @@ func min(a, b float64) float64 {
 	}
 	return b
 }
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram().(*Histogram)
+	for v := int64(1); v <= 1000; v++ {
+		h.Observe(v)
+	}
+	if g, w := h.Count(), 1000.0; g != w {
+		t.Errorf("Histogram count = %v; want %v", g, w)
+	}
+	if g, w := h.Max(), int64(1000); g != w {
+		t.Errorf("Histogram max = %v; want %v", g, w)
+	}
+	// Estimates are within the relative width of a bucket.
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		w := p * 1000
+		if g := float64(h.Percentile(p)); math.Abs(g-w)/w > 0.19 {
+			t.Errorf("Histogram p%v = %v; want %v", p*100, g, w)
+		}
+	}
+	if g := h.Percentile(1); g != 1000 {
+		t.Errorf("Histogram p100 = %v; want max", g)
+	}
+
+	// Merging and scaling keeps the shape of the distribution.
+	o := NewHistogram().(*Histogram)
+	o.Observe(5000)
+	h.Add(o)
+	if g, w := h.Max(), int64(5000); g != w {
+		t.Errorf("Histogram merged max = %v; want %v", g, w)
+	}
+	c := NewHistogram().(*Histogram)
+	c.CopyFrom(h)
+	c.Multiply(0.5)
+	if g, w := c.Count(), 500.5; g != w {
+		t.Errorf("Histogram scaled count = %v; want %v", g, w)
+	}
+	if g, w := c.Percentile(0.5), h.Percentile(0.5); g != w {
+		t.Errorf("Histogram scaled p50 = %v; want %v", g, w)
+	}
+	c.Clear()
+	if g := c.Percentile(0.5); g != 0 {
+		t.Errorf("Histogram post-clear p50 = %v; want 0", g)
+	}
+}
+
+func TestHistogramTimeSeries(t *testing.T) {
+	ts := NewTimeSeries(NewHistogram)
+	for i := int64(0); i < 100; i++ {
+		h := new(Histogram)
+		h.Observe(10 * (i + 1))
+		ts.AddWithTime(h, tu(i+1))
+	}
+	h := ts.Range(tu(0), tu(100)).(*Histogram)
+	if g, w := h.Count(), 100.0; math.Abs(g-w) > 1e-2 {
+		t.Errorf("Histogram range count = %v; want %v", g, w)
+	}
+	if g := h.Max(); g != 1000 {
+		t.Errorf("Histogram range max = %v; want 1000", g)
+	}
+	recent := ts.Range(tu(90), tu(100)).(*Histogram)
+	if g := recent.Percentile(0); g < 800 {
+		t.Errorf("Histogram recent minimum = %v; want >= 800", g)
+	}
+}
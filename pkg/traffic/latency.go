@@ -0,0 +1,156 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic/internal/timeseries"
+)
+
+// LatencySummary describes the distribution of latencies observed for a key.
+// Percentiles are estimates accurate to within ~20%.
+type LatencySummary struct {
+	Count int
+	Sum   time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// LatencyTracker records a latency distribution per key, such as the
+// sections counted by a RequestCounter, into multi-resolution time series so
+// percentiles can be queried over trailing windows. Memory is bounded by
+// Prune, like a WindowCounter's; the latencies of pruned keys are no longer
+// summarised.
+type LatencyTracker struct {
+	clock Clock
+	max   int
+	keys  sync.Map
+}
+
+// latencySeries wraps a key's timeseries, which is not concurrency-safe.
+type latencySeries struct {
+	mux  sync.Mutex
+	ts   *timeseries.TimeSeries
+	last *int64 // UnixNano of the latest observation, accessed atomically
+}
+
+// NewLatencyTracker initializes a tracker reading the current time from c,
+// which Prune bounds to max keys.
+func NewLatencyTracker(c Clock, max int) *LatencyTracker {
+	return &LatencyTracker{clock: c, max: max}
+}
+
+// Observe records a latency of d for key at time t.
+func (l *LatencyTracker) Observe(key string, d time.Duration, t time.Time) {
+	s, ok := l.keys.Load(key)
+	if !ok {
+		s, _ = l.keys.LoadOrStore(key, &latencySeries{
+			ts:   timeseries.NewTimeSeriesWithClock(timeseries.NewHistogram, l.clock),
+			last: new(int64),
+		})
+	}
+	h := new(timeseries.Histogram)
+	h.Observe(int64(d / time.Microsecond))
+
+	ls := s.(*latencySeries)
+	ls.mux.Lock()
+	ls.ts.AddWithTime(h, t)
+	ls.mux.Unlock()
+	if n := t.UnixNano(); n > atomic.LoadInt64(ls.last) {
+		atomic.StoreInt64(ls.last, n)
+	}
+}
+
+// Prune removes the keys without latencies observed within MaxWindow, then
+// the least recently observed keys until at most the tracker's capacity
+// remain. It returns the number of keys removed.
+func (l *LatencyTracker) Prune() int {
+	return pruneRecent(&l.keys, l.max, l.clock.Time().Add(-MaxWindow), func(v interface{}) int64 {
+		return atomic.LoadInt64(v.(*latencySeries).last)
+	})
+}
+
+// Recent summarises the latencies of each key observed within the trailing
+// window. Keys without observations in the window are omitted.
+func (l *LatencyTracker) Recent(window time.Duration) map[string]LatencySummary {
+	return l.export(func(ts *timeseries.TimeSeries) timeseries.Observable {
+		return ts.Recent(window)
+	})
+}
+
+// Total summarises all of the latencies observed for each key.
+func (l *LatencyTracker) Total() map[string]LatencySummary {
+	return l.export(func(ts *timeseries.TimeSeries) timeseries.Observable {
+		return ts.Total()
+	})
+}
+
+func (l *LatencyTracker) export(query func(*timeseries.TimeSeries) timeseries.Observable) map[string]LatencySummary {
+	output := make(map[string]LatencySummary)
+	l.keys.Range(func(key, value interface{}) bool {
+		ls := value.(*latencySeries)
+		ls.mux.Lock()
+		h := query(ls.ts).(*timeseries.Histogram)
+		s := summarize(h)
+		ls.mux.Unlock()
+		if s.Count > 0 {
+			output[key.(string)] = s
+		}
+		return true
+	})
+	return output
+}
+
+func summarize(h *timeseries.Histogram) LatencySummary {
+	micros := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	return LatencySummary{
+		Count: int(h.Count() + 0.5),
+		Sum:   micros(int64(h.Sum())),
+		P50:   micros(h.Percentile(0.5)),
+		P90:   micros(h.Percentile(0.9)),
+		P99:   micros(h.Percentile(0.99)),
+		Max:   micros(h.Max()),
+	}
+}
+
+// MarshalJSON encodes the latency distributions of each key.
+func (l *LatencyTracker) MarshalJSON() ([]byte, error) {
+	keys := make(map[string]json.RawMessage)
+	var err error
+	l.keys.Range(func(key, value interface{}) bool {
+		ls := value.(*latencySeries)
+		ls.mux.Lock()
+		keys[key.(string)], err = json.Marshal(ls.ts)
+		ls.mux.Unlock()
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(keys)
+}
+
+// UnmarshalJSON replaces the distributions of the keys encoded by
+// MarshalJSON. Keys are treated as last observed when restored, so idle
+// keys are pruned MaxWindow later.
+func (l *LatencyTracker) UnmarshalJSON(data []byte) error {
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	now := l.clock.Time().UnixNano()
+	for k, v := range keys {
+		ts := timeseries.NewTimeSeriesWithClock(timeseries.NewHistogram, l.clock)
+		if err := json.Unmarshal(v, ts); err != nil {
+			return fmt.Errorf("latency of %q: %w", k, err)
+		}
+		last := now
+		l.keys.Store(k, &latencySeries{ts: ts, last: &last})
+	}
+	return nil
+}
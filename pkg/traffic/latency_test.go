@@ -0,0 +1,84 @@
+package traffic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	lt := NewLatencyTracker(c, 10)
+
+	// An hour ago the section was slow, in the last minute it is fast.
+	for i := 0; i < 100; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		lt.Observe("/ski", time.Second, ts)
+	}
+	recent := start.Add(time.Hour)
+	for i := 0; i < 100; i++ {
+		ts := recent.Add(time.Duration(i) * 100 * time.Millisecond)
+		lt.Observe("/ski", time.Duration(i+1)*time.Millisecond, ts)
+	}
+	c.Advance(recent.Add(10 * time.Second))
+
+	s, ok := lt.Recent(time.Minute)["/ski"]
+	if !ok {
+		t.Fatal("no recent latencies for /ski")
+	}
+	if s.Count != 100 {
+		t.Errorf("recent count %d != 100", s.Count)
+	}
+	if s.Max != 100*time.Millisecond {
+		t.Errorf("recent max %v != 100ms", s.Max)
+	}
+	within := func(g, w time.Duration) bool {
+		return g > w*8/10 && g < w*12/10
+	}
+	if !within(s.P50, 50*time.Millisecond) || !within(s.P90, 90*time.Millisecond) || !within(s.P99, 99*time.Millisecond) {
+		t.Errorf("recent percentiles off: %+v", s)
+	}
+
+	total := lt.Total()["/ski"]
+	if total.Count != 200 || total.Max != time.Second {
+		t.Errorf("unexpected total summary: %+v", total)
+	}
+	if _, ok := lt.Recent(time.Minute)["/wat"]; ok {
+		t.Error("unobserved key should be omitted")
+	}
+	// Sections idle for longer than MaxWindow are pruned.
+	lt.Observe("/lift", time.Second, start)
+	c.Advance(start.Add(MaxWindow + time.Minute))
+	if n := lt.Prune(); n != 1 {
+		t.Errorf("pruned %d keys, expected the idle /lift", n)
+	}
+	if _, ok := lt.Total()["/ski"]; !ok {
+		t.Error("active /ski was pruned")
+	}
+}
+
+func TestLatencySnapshot(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	lt := NewLatencyTracker(c, 10)
+	for i := 0; i < 10; i++ {
+		lt.Observe("/ski", time.Duration(i+1)*time.Millisecond, start.Add(time.Duration(i)*time.Second))
+	}
+	c.Advance(start.Add(10 * time.Second))
+
+	data, err := json.Marshal(lt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewLatencyTracker(c, 10)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if s, w := restored.Recent(time.Minute)["/ski"], lt.Recent(time.Minute)["/ski"]; s != w {
+		t.Errorf("restored recent latency %+v != %+v", s, w)
+	}
+	if s, w := restored.Total()["/ski"], lt.Total()["/ski"]; s != w {
+		t.Errorf("restored total latency %+v != %+v", s, w)
+	}
+}
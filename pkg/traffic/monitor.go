@@ -1,18 +1,13 @@
 package traffic
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
 	"github.com/ropes/banken/pkg/traffic/internal/timeseries"
 )
 
-type nowClock struct{}
-
-func (c *nowClock) Time() time.Time {
-	return time.Now()
-}
-
 // Monitor aggregates http request counts into a searchable data structure.
 // timeseries.TimeSeries data structure is not a concurrency-safe package,
 //so all calls to it are wrapped in a mutex.
@@ -24,7 +19,12 @@ type Monitor struct {
 // NewMonitor initializes the data type with clock and NewFloat observable
 // for storing time series data points.
 func NewMonitor() *Monitor {
-	c := &nowClock{}
+	return NewMonitorWithClock(NewWallClock())
+}
+
+// NewMonitorWithClock initializes the Monitor reading the current time from
+// the given Clock.
+func NewMonitorWithClock(c Clock) *Monitor {
 	return &Monitor{
 		tsdb: timeseries.NewTimeSeriesWithClock(timeseries.NewFloat, c),
 	}
@@ -57,3 +57,54 @@ func (tm *Monitor) RecentSum(delta time.Duration) int {
 	f := obs.(*timeseries.Float)
 	return int(*f)
 }
+
+// RecentSums aggregates the occurrences of num consecutive spans of width
+// delta ending now, ordered oldest first. Older spans are estimated from the
+// coarser hour and day resolution buckets.
+func (tm *Monitor) RecentSums(delta time.Duration, num int) []float64 {
+	tm.tsMux.Lock()
+	obs := tm.tsdb.RecentList(delta*time.Duration(num), num)
+	tm.tsMux.Unlock()
+	sums := make([]float64, len(obs))
+	for i, o := range obs {
+		sums[i] = float64(*o.(*timeseries.Float))
+	}
+	return sums
+}
+
+// RangeSums aggregates the occurrences of num consecutive spans evenly
+// dividing [start, finish), ordered oldest first.
+func (tm *Monitor) RangeSums(start, finish time.Time, num int) []float64 {
+	tm.tsMux.Lock()
+	obs := tm.tsdb.ComputeRange(start, finish, num)
+	tm.tsMux.Unlock()
+	sums := make([]float64, len(obs))
+	for i, o := range obs {
+		sums[i] = float64(*o.(*timeseries.Float))
+	}
+	return sums
+}
+
+// Resolution is the width of the buckets occurrences since start are
+// estimated from; spans narrower than it are apportioned from a bucket.
+func (tm *Monitor) Resolution(start time.Time) time.Duration {
+	tm.tsMux.Lock()
+	defer tm.tsMux.Unlock()
+	return tm.tsdb.Resolution(start)
+}
+
+// MarshalJSON encodes the recorded occurrences, so they may be restored
+// after a restart.
+func (tm *Monitor) MarshalJSON() ([]byte, error) {
+	tm.tsMux.Lock()
+	defer tm.tsMux.Unlock()
+	return json.Marshal(tm.tsdb)
+}
+
+// UnmarshalJSON replaces the recorded occurrences with those encoded by
+// MarshalJSON, discarding any too old to be retained.
+func (tm *Monitor) UnmarshalJSON(data []byte) error {
+	tm.tsMux.Lock()
+	defer tm.tsMux.Unlock()
+	return json.Unmarshal(data, tm.tsdb)
+}
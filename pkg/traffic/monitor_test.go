@@ -1,6 +1,7 @@
 package traffic
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
@@ -202,3 +203,30 @@ func TestHeavyDenseIncrementingNormalInit(t *testing.T) {
 		})
 	}
 }
+
+func TestMonitorSnapshot(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	tm := NewMonitorWithClock(c)
+	tm.Increment(2, start)
+	tm.Increment(3, start.Add(50*time.Minute))
+	c.Advance(start.Add(50 * time.Minute))
+
+	data, err := json.Marshal(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMonitorWithClock(c)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if sum := restored.RecentSum(time.Minute); sum != 3 {
+		t.Errorf("restored recent sum %d != 3", sum)
+	}
+	if sum := restored.RecentSum(2 * time.Hour); sum != 5 {
+		t.Errorf("restored sum of last 2hr %d != 5", sum)
+	}
+	if err := json.Unmarshal([]byte(`{"levels":[]}`), restored); err == nil {
+		t.Error("snapshot of other resolutions should be rejected")
+	}
+}
@@ -0,0 +1,199 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var _ (Notification) = (*Alert)(nil)
+var _ (Notification) = (*NominalStatus)(nil)
+var _ (Notification) = (*NilStatus)(nil)
+
+// State of an AlertDetector reported by a Notification.
+type State string
+
+// States of the AlertDetector.
+const (
+	StateAlert   State = "alert"
+	StateNominal State = "nominal"
+	// StateEnded is reported once the AlertDetector has exited operation.
+	StateEnded State = "ended"
+)
+
+// Notification of AlertDetector state back to caller. Accessors expose the
+// measurement behind the state, so consumers need not parse String, and
+// notifications marshal to JSON objects of the same fields.
+type Notification interface {
+	String() string
+	json.Marshaler
+
+	State() State
+	// Time the state was evaluated.
+	Time() time.Time
+	// RuleName is the name of the rule evaluated, empty for the request
+	// rate --alert-threshold.
+	RuleName() string
+	Metric() Metric
+	// Scope and Key identify the host or section the rule measures.
+	Scope() Scope
+	Key() string
+	// Window is the trailing timespan the Value was measured over.
+	Window() time.Duration
+	// Value of the rule's Metric observed.
+	Value() float64
+	// Threshold the Value is tested against; for anomaly rules the upper
+	// band of the learnt baseline.
+	Threshold() float64
+}
+
+// status holds the evaluation of a rule shared by its notifications.
+type status struct {
+	ts        time.Time
+	rule      Rule
+	value     float64
+	threshold float64
+
+	// baseline the value deviated from, set by anomaly rules.
+	baseline baseline
+}
+
+// Time the state was evaluated.
+func (s status) Time() time.Time { return s.ts }
+
+// RuleName is the name of the rule evaluated.
+func (s status) RuleName() string { return s.rule.Name }
+
+// Metric measured by the rule.
+func (s status) Metric() Metric { return s.rule.Metric }
+
+// Scope of the traffic measured by the rule.
+func (s status) Scope() Scope { return s.rule.Scope }
+
+// Key is the host or section measured by the rule.
+func (s status) Key() string { return s.rule.Key }
+
+// Window is the trailing timespan the Value was measured over.
+func (s status) Window() time.Duration { return s.rule.Window }
+
+// Value of the rule's Metric observed.
+func (s status) Value() float64 { return s.value }
+
+// Threshold the Value is tested against.
+func (s status) Threshold() float64 { return s.threshold }
+
+// notificationJSON is the JSON encoding of every Notification.
+type notificationJSON struct {
+	State     State     `json:"state"`
+	Time      time.Time `json:"time"`
+	Rule      string    `json:"rule,omitempty"`
+	Mode      Mode      `json:"mode,omitempty"`
+	Metric    Metric    `json:"metric,omitempty"`
+	Scope     Scope     `json:"scope,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Window    string    `json:"window,omitempty"`
+	Value     float64   `json:"value"`
+	Threshold *float64  `json:"threshold,omitempty"`
+	Baseline  *struct {
+		Mean   float64 `json:"mean"`
+		StdDev float64 `json:"stddev"`
+	} `json:"baseline,omitempty"`
+	Message string `json:"message"`
+}
+
+func (s status) marshal(state State, message string) ([]byte, error) {
+	j := notificationJSON{
+		State:   state,
+		Time:    s.ts,
+		Rule:    s.rule.Name,
+		Mode:    s.rule.Mode,
+		Metric:  s.rule.Metric,
+		Scope:   s.rule.Scope,
+		Key:     s.rule.Key,
+		Value:   s.value,
+		Message: message,
+	}
+	if s.rule.Window != 0 {
+		j.Window = s.rule.Window.String()
+	}
+	// Anomaly rules have no threshold until their baseline is learnt.
+	if !math.IsInf(s.threshold, 1) {
+		j.Threshold = &s.threshold
+	}
+	if s.rule.Mode == ModeAnomaly && !math.IsInf(s.threshold, 1) {
+		j.Baseline = &struct {
+			Mean   float64 `json:"mean"`
+			StdDev float64 `json:"stddev"`
+		}{s.baseline.mean, s.baseline.std}
+	}
+	return json.Marshal(j)
+}
+
+// Alert indicates that HTTP traffic surpassed a rule's limit.
+type Alert struct {
+	status
+}
+
+// State is StateAlert.
+func (a Alert) State() State { return StateAlert }
+
+// MarshalJSON encodes the alert's fields and message.
+func (a Alert) MarshalJSON() ([]byte, error) { return a.marshal(StateAlert, a.String()) }
+
+// Alert formats state of alert to caller.
+func (a Alert) String() string {
+	what, unit, v := "traffic", "hits", strconv.Itoa(int(a.value))
+	switch a.rule.Metric {
+	case MetricErrorRatio:
+		what, unit, v = "error ratio", "ratio", fmt.Sprintf("%.3f", a.value)
+	case MetricBytes:
+		what, unit, v = "bandwidth", "bytes/s", fmt.Sprintf("%.0f", a.value)
+	}
+	ts := a.ts.Format(time.RFC3339)
+	if a.rule.Mode == ModeAnomaly {
+		return rulePrefix(a.rule.Name) + fmt.Sprintf("Anomalous %s generated an alert --- %s = %s, baseline = %.3g ± %.3g, triggered at %s",
+			what, unit, v, a.baseline.mean, a.baseline.std, ts)
+	}
+	return rulePrefix(a.rule.Name) + fmt.Sprintf("High %s generated an alert --- %s = %s, triggered at %s", what, unit, v, ts)
+}
+
+// NominalStatus indicates normal HTTP traffic conditions.
+type NominalStatus struct {
+	status
+}
+
+// State is StateNominal.
+func (s NominalStatus) State() State { return StateNominal }
+
+// MarshalJSON encodes the status' fields and message.
+func (s NominalStatus) MarshalJSON() ([]byte, error) { return s.marshal(StateNominal, s.String()) }
+
+// String formats state information to watcher.
+func (s NominalStatus) String() string {
+	return rulePrefix(s.rule.Name) + fmt.Sprintf("Traffic within nominal parameters - time: %s", s.ts.Format(time.RFC3339))
+}
+
+// rulePrefix labels the notifications of named rules.
+func rulePrefix(rule string) string {
+	if rule == "" {
+		return ""
+	}
+	return fmt.Sprintf("[%s] ", rule)
+}
+
+// NilStatus informs caller that AlertDetector state has exited operation.
+type NilStatus struct {
+	status
+}
+
+// State is StateEnded.
+func (e NilStatus) State() State { return StateEnded }
+
+// MarshalJSON encodes the status' message.
+func (e NilStatus) MarshalJSON() ([]byte, error) { return e.marshal(StateEnded, e.String()) }
+
+func (e NilStatus) String() string {
+	return fmt.Sprintf("state execution has ended: %v", time.Now())
+}
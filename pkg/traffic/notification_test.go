@@ -0,0 +1,70 @@
+package traffic
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNotificationAccessors(t *testing.T) {
+	ts := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	rule := Rule{
+		Name: "ski", Mode: ModeThreshold, Metric: MetricErrorRatio, Scope: ScopeSection,
+		Key: "http://rusutsu.com/ski", Window: time.Minute, Threshold: 0.5,
+	}
+	var n Notification = Alert{status{ts: ts, rule: rule, value: 0.75, threshold: 0.5}}
+	if n.State() != StateAlert || !n.Time().Equal(ts) || n.RuleName() != "ski" || n.Metric() != MetricErrorRatio ||
+		n.Scope() != ScopeSection || n.Key() != "http://rusutsu.com/ski" || n.Window() != time.Minute ||
+		n.Value() != 0.75 || n.Threshold() != 0.5 {
+		t.Errorf("unexpected alert accessors: %v", n)
+	}
+	if exp := "[ski] High error ratio generated an alert --- ratio = 0.750, triggered at 2020-02-20T12:00:00Z"; n.String() != exp {
+		t.Errorf("alert string %q != %q", n.String(), exp)
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{
+		"state":     "alert",
+		"time":      "2020-02-20T12:00:00Z",
+		"rule":      "ski",
+		"mode":      "threshold",
+		"metric":    "error-ratio",
+		"scope":     "section",
+		"key":       "http://rusutsu.com/ski",
+		"window":    "1m0s",
+		"value":     0.75,
+		"threshold": 0.5,
+		"message":   n.String(),
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("alert json %v != %v", out, exp)
+	}
+
+	// Anomaly rules report their baseline, once learnt.
+	rule = Rule{Mode: ModeAnomaly, Metric: MetricRequests, Scope: ScopeTotal, Window: time.Minute}
+	n = NominalStatus{status{ts: ts, rule: rule, value: 12, threshold: 20, baseline: baseline{mean: 11, std: 3}}}
+	b, err = json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = nil
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["state"] != "nominal" || !reflect.DeepEqual(out["baseline"], map[string]interface{}{"mean": 11.0, "stddev": 3.0}) {
+		t.Errorf("unexpected nominal json: %v", out)
+	}
+	n = NominalStatus{status{ts: ts, rule: rule, threshold: math.Inf(1)}}
+	if _, err := json.Marshal(n); err != nil {
+		t.Errorf("learning anomaly status failed to marshal: %v", err)
+	}
+}
@@ -1,6 +1,21 @@
 package traffic
 
-import "sync"
+import (
+	"encoding/json"
+	"sync"
+)
+
+var _ Counter = (*RequestCounter)(nil)
+var _ Counter = (*TopK)(nil)
+
+// Counter counts occurrences per key, eg: requests per URL. Counts encode
+// to JSON so they may be restored after a restart.
+type Counter interface {
+	IncKey(key string, i uint64)
+	Export() map[string]uint64
+	json.Marshaler
+	json.Unmarshaler
+}
 
 type keyCounter struct {
 	count uint64
@@ -20,7 +35,8 @@ func (k *keyCounter) Get() uint64 {
 }
 
 // RequestCounter provides safe concurrent counting
-// of URLs requests made.
+// of URLs requests made. Every key is counted exactly, see TopK for
+// counting in bounded memory.
 type RequestCounter struct {
 	reqs sync.Map
 }
@@ -49,3 +65,20 @@ func (r *RequestCounter) Export() map[string]uint64 {
 	})
 	return output
 }
+
+// MarshalJSON encodes the count of each key.
+func (r *RequestCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Export())
+}
+
+// UnmarshalJSON adds the counts encoded by MarshalJSON to the counter.
+func (r *RequestCounter) UnmarshalJSON(data []byte) error {
+	counts := make(map[string]uint64)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+	for k, c := range counts {
+		r.IncKey(k, c)
+	}
+	return nil
+}
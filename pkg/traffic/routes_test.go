@@ -1,6 +1,7 @@
 package traffic
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 	"testing"
@@ -133,3 +134,23 @@ func TestConcurrentKeyMap(t *testing.T) {
 	}
 
 }
+
+func TestRequestCounterSnapshot(t *testing.T) {
+	rc := new(RequestCounter)
+	rc.IncKey("/ski", 3)
+	rc.IncKey("/onsen", 1)
+	data, err := json.Marshal(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := new(RequestCounter)
+	restored.IncKey("/ski", 2)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	output := restored.Export()
+	if output["/ski"] != 5 || output["/onsen"] != 1 {
+		t.Errorf("restored counts should add to counted: %v", output)
+	}
+}
@@ -0,0 +1,255 @@
+package traffic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric is the traffic measurement an alerting Rule tests.
+type Metric string
+
+// Metrics supported by alerting rules.
+const (
+	// MetricRequests counts the requests within the rule's window.
+	MetricRequests Metric = "requests"
+	// MetricErrorRatio is the fraction of responses within the rule's window
+	// with a 4xx or 5xx status.
+	MetricErrorRatio Metric = "error-ratio"
+	// MetricBytes is the response bytes per second averaged over the rule's
+	// window.
+	MetricBytes Metric = "bytes"
+)
+
+// Mode selects how a Rule decides its metric is abnormal.
+type Mode string
+
+// Modes supported by alerting rules.
+const (
+	// ModeThreshold alerts when the metric exceeds the rule's Threshold.
+	ModeThreshold Mode = "threshold"
+	// ModeAnomaly alerts when the metric deviates above a baseline learnt
+	// from the rule's preceding windows.
+	ModeAnomaly Mode = "anomaly"
+)
+
+// Scope selects which traffic Samples a Rule measures.
+type Scope string
+
+// Scopes supported by alerting rules.
+const (
+	// ScopeTotal measures all traffic.
+	ScopeTotal Scope = "total"
+	// ScopeHost measures the traffic of the host given by the rule's Key.
+	ScopeHost Scope = "host"
+	// ScopeSection measures the traffic of the section given by the rule's
+	// Key, eg: 'http://rusutsu.com/ski'.
+	ScopeSection Scope = "section"
+)
+
+// Rule configures a named alerting condition. Each Rule drives its own
+// AlertDetector, so rules switch between Nominal and Alerted independently.
+type Rule struct {
+	Name   string
+	Mode   Mode
+	Metric Metric
+	Scope  Scope
+	Key    string
+
+	// Window is the trailing timespan the Metric is measured over.
+	Window time.Duration
+	// Threshold is the Metric value above which the rule alerts. Anomaly
+	// rules only alert above the Threshold, ignoring deviations of low
+	// traffic.
+	Threshold float64
+	// Recover is the Metric value below which an alerted rule returns to
+	// nominal. Zero recovers below the Threshold.
+	Recover float64
+
+	// Interval is how often the rule is evaluated, 2s when zero.
+	Interval time.Duration
+	// Flush is how often observed traffic is recorded into the rule's
+	// timeseries, 2s when zero.
+	Flush time.Duration
+	// Dwell is the minimum time spent in the Nominal or Alerted state before
+	// transitioning, so alerts do not flap when traffic hovers around the
+	// limits.
+	Dwell time.Duration
+
+	// Baseline is the history of Windows an anomaly rule learns the expected
+	// Metric from, 24h when zero.
+	Baseline time.Duration
+	// Deviation is the number of standard deviations above the baseline at
+	// which an anomaly rule alerts, 3 when zero.
+	Deviation float64
+}
+
+// defaultRuleInterval is the evaluation and flush interval of rules which do
+// not set their own.
+const defaultRuleInterval = 2 * time.Second
+
+// Anomaly rule defaults.
+const (
+	defaultBaseline  = 24 * time.Hour
+	defaultDeviation = 3
+	// minBaselineWindows is the number of windows an anomaly rule must
+	// observe before its baseline is trusted.
+	minBaselineWindows = 5
+)
+
+// Sample is one observation of HTTP traffic offered to alerting rules.
+type Sample struct {
+	TS        time.Time
+	Host      string
+	Section   string
+	Requests  int
+	Responses int
+	Errors    int
+	Bytes     int64
+}
+
+// Validate reports whether the rule is complete and consistent.
+func (r Rule) Validate() error {
+	switch r.Mode {
+	case "", ModeThreshold:
+	case ModeAnomaly:
+		if r.Recover != 0 {
+			return fmt.Errorf("rule %q: anomaly rules recover within the baseline deviation, not at a recover value", r.Name)
+		}
+		if r.Baseline < 0 || r.Deviation < 0 {
+			return fmt.Errorf("rule %q: baseline and deviation may not be negative", r.Name)
+		}
+		if b := r.withDefaults().Baseline; b < minBaselineWindows*r.Window {
+			return fmt.Errorf("rule %q: baseline %s must span at least %d windows", r.Name, b, minBaselineWindows)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown mode %q", r.Name, r.Mode)
+	}
+	switch r.Metric {
+	case MetricRequests, MetricErrorRatio, MetricBytes:
+	default:
+		return fmt.Errorf("rule %q: unknown metric %q", r.Name, r.Metric)
+	}
+	switch r.Scope {
+	case ScopeTotal:
+		if r.Key != "" {
+			return fmt.Errorf("rule %q: total scope does not take a key", r.Name)
+		}
+	case ScopeHost, ScopeSection:
+		if r.Key == "" {
+			return fmt.Errorf("rule %q: %s scope requires a key", r.Name, r.Scope)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown scope %q", r.Name, r.Scope)
+	}
+	if r.Window <= 0 {
+		return fmt.Errorf("rule %q: window must be positive", r.Name)
+	}
+	if r.Interval < 0 || r.Flush < 0 || r.Dwell < 0 {
+		return fmt.Errorf("rule %q: interval, flush and dwell may not be negative", r.Name)
+	}
+	if r.Recover > r.Threshold {
+		return fmt.Errorf("rule %q: recover %g exceeds threshold %g", r.Name, r.Recover, r.Threshold)
+	}
+	return nil
+}
+
+// matches reports whether the Sample is within the rule's scope.
+func (r Rule) matches(s Sample) bool {
+	switch r.Scope {
+	case ScopeHost:
+		return s.Host == r.Key
+	case ScopeSection:
+		return s.Section == r.Key
+	default:
+		return true
+	}
+}
+
+// withDefaults fills in the unset mode, intervals and anomaly parameters.
+func (r Rule) withDefaults() Rule {
+	if r.Mode == "" {
+		r.Mode = ModeThreshold
+	}
+	if r.Baseline == 0 {
+		r.Baseline = defaultBaseline
+	}
+	if r.Deviation == 0 {
+		r.Deviation = defaultDeviation
+	}
+	if r.Interval == 0 {
+		r.Interval = defaultRuleInterval
+	}
+	if r.Flush == 0 {
+		r.Flush = defaultRuleInterval
+	}
+	return r
+}
+
+// recoverAt is the value below which an alerted rule returns to nominal.
+func (r Rule) recoverAt() float64 {
+	if r.Recover == 0 {
+		return r.Threshold
+	}
+	return r.Recover
+}
+
+// ParseRule reads a Rule from comma separated key=value pairs, eg:
+//
+//	name=ski,metric=requests,scope=section,key=http://rusutsu.com/ski,window=1m,threshold=100,recover=80
+//
+// mode defaults to threshold, metric to requests, scope to total and window
+// to 2m. The interval, flush and dwell durations, and the baseline and
+// deviation of anomaly rules are optional.
+func ParseRule(s string) (Rule, error) {
+	r := Rule{
+		Metric: MetricRequests,
+		Scope:  ScopeTotal,
+		Window: 2 * time.Minute,
+	}
+	var err error
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return Rule{}, fmt.Errorf("malformed rule field %q, expected key=value", field)
+		}
+		switch v := kv[1]; kv[0] {
+		case "name":
+			r.Name = v
+		case "mode":
+			r.Mode = Mode(v)
+		case "baseline":
+			r.Baseline, err = time.ParseDuration(v)
+		case "deviation":
+			r.Deviation, err = strconv.ParseFloat(v, 64)
+		case "metric":
+			r.Metric = Metric(v)
+		case "scope":
+			r.Scope = Scope(v)
+		case "key":
+			r.Key = v
+		case "window":
+			r.Window, err = time.ParseDuration(v)
+		case "interval":
+			r.Interval, err = time.ParseDuration(v)
+		case "flush":
+			r.Flush, err = time.ParseDuration(v)
+		case "dwell":
+			r.Dwell, err = time.ParseDuration(v)
+		case "threshold":
+			r.Threshold, err = strconv.ParseFloat(v, 64)
+		case "recover":
+			r.Recover, err = strconv.ParseFloat(v, 64)
+		default:
+			return Rule{}, fmt.Errorf("unknown rule field %q", kv[0])
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("rule field %q: %w", kv[0], err)
+		}
+	}
+	if r.Name == "" {
+		return Rule{}, fmt.Errorf("rule %q requires a name", s)
+	}
+	return r, r.Validate()
+}
@@ -0,0 +1,68 @@
+package traffic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("name=ski,metric=error-ratio,scope=section,key=http://rusutsu.com/ski,window=1m,threshold=0.5,recover=0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := Rule{
+		Name:      "ski",
+		Metric:    MetricErrorRatio,
+		Scope:     ScopeSection,
+		Key:       "http://rusutsu.com/ski",
+		Window:    time.Minute,
+		Threshold: 0.5,
+		Recover:   0.2,
+	}
+	if r != exp {
+		t.Errorf("rule %+v != %+v", r, exp)
+	}
+
+	r, err = ParseRule("name=steady,threshold=10,interval=10s,flush=1s,dwell=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Interval != 10*time.Second || r.Flush != time.Second || r.Dwell != time.Minute {
+		t.Errorf("unexpected rule timing: %+v", r)
+	}
+
+	r, err = ParseRule("name=learnt,mode=anomaly,window=1m,baseline=6h,deviation=2.5,threshold=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Mode != ModeAnomaly || r.Baseline != 6*time.Hour || r.Deviation != 2.5 {
+		t.Errorf("unexpected anomaly rule: %+v", r)
+	}
+
+	r, err = ParseRule("name=total,threshold=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Metric != MetricRequests || r.Scope != ScopeTotal || r.Window != 2*time.Minute {
+		t.Errorf("unexpected rule defaults: %+v", r)
+	}
+
+	for _, s := range []string{
+		"threshold=100",
+		"name=x,metric=latency",
+		"name=x,scope=host",
+		"name=x,key=rusutsu.com",
+		"name=x,window=0s",
+		"name=x,threshold=1,recover=2",
+		"name=x,dwell=-1s",
+		"name=x,mode=seasonal",
+		"name=x,mode=anomaly,threshold=2,recover=1",
+		"name=x,mode=anomaly,window=1h,baseline=2h",
+		"name=x,threshold",
+		"name=x,colour=blue",
+	} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("rule %q should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package traffic
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic/internal/timeseries"
+)
+
+// SectionTracker retains the detail of the requests to each section, eg:
+// 'http://rusutsu.com/ski', which its count alone does not: the full paths
+// requested under it, their methods and clients, and the section's requests
+// over the last MaxWindow. Paths, methods and clients are counted in TopK
+// summaries of capacity keys, since methods are as arbitrary as paths, and
+// Prune bounds the sections tracked like a WindowCounter's keys.
+type SectionTracker struct {
+	clock    Clock
+	capacity int
+	max      int
+	sections sync.Map
+}
+
+// sectionRequests is the detail of a section's requests, guarded by mux but
+// for last.
+type sectionRequests struct {
+	mux     sync.Mutex
+	paths   *TopK
+	methods *TopK
+	clients *TopK
+	ts      *timeseries.MinuteHourSeries
+	last    *int64 // UnixNano of the latest request, accessed atomically
+}
+
+// SectionDetail is the detail of the requests to a section.
+type SectionDetail struct {
+	// Paths, Methods and Clients count the requests per full path, method
+	// and client address.
+	Paths   map[string]uint64
+	Methods map[string]uint64
+	Clients map[string]uint64
+	// Requests are the request counts of consecutive spans ending now,
+	// ordered oldest first.
+	Requests []float64
+}
+
+// NewSectionTracker initializes a tracker reading the current time from c,
+// which counts up to capacity paths and clients per section, and which
+// Prune bounds to max sections.
+func NewSectionTracker(c Clock, capacity, max int) *SectionTracker {
+	return &SectionTracker{clock: c, capacity: capacity, max: max}
+}
+
+// Observe records a request to path under section by client at time t.
+func (s *SectionTracker) Observe(section, path, method, client string, t time.Time) {
+	v, ok := s.sections.Load(section)
+	if !ok {
+		v, _ = s.sections.LoadOrStore(section, &sectionRequests{
+			paths:   NewTopK(s.capacity),
+			methods: NewTopK(s.capacity),
+			clients: NewTopK(s.capacity),
+			ts:      timeseries.NewMinuteHourSeriesWithClock(timeseries.NewFloat, s.clock),
+			last:    new(int64),
+		})
+	}
+	sr := v.(*sectionRequests)
+	sr.paths.IncKey(path, 1)
+	sr.methods.IncKey(method, 1)
+	if client != "" {
+		sr.clients.IncKey(client, 1)
+	}
+	f := timeseries.Float(1)
+	sr.mux.Lock()
+	sr.ts.AddWithTime(&f, t)
+	sr.mux.Unlock()
+	if n := t.UnixNano(); n > atomic.LoadInt64(sr.last) {
+		atomic.StoreInt64(sr.last, n)
+	}
+}
+
+// Detail provides the detail of the requests to section, with its request
+// counts of num spans of width span, which together are limited to
+// MaxWindow. It reports false when no request to the section is tracked.
+func (s *SectionTracker) Detail(section string, span time.Duration, num int) (SectionDetail, bool) {
+	v, ok := s.sections.Load(section)
+	if !ok {
+		return SectionDetail{}, false
+	}
+	if span*time.Duration(num) > MaxWindow {
+		span = MaxWindow / time.Duration(num)
+	}
+	sr := v.(*sectionRequests)
+	d := SectionDetail{
+		Paths:   sr.paths.Export(),
+		Methods: sr.methods.Export(),
+		Clients: sr.clients.Export(),
+	}
+	sr.mux.Lock()
+	obs := sr.ts.RecentList(span*time.Duration(num), num)
+	sr.mux.Unlock()
+	d.Requests = make([]float64, len(obs))
+	for i, o := range obs {
+		d.Requests[i] = float64(*o.(*timeseries.Float))
+	}
+	return d, true
+}
+
+// Prune removes the sections without requests within MaxWindow, then the
+// least recently requested sections until at most the tracker's capacity
+// remain. It returns the number of sections removed.
+func (s *SectionTracker) Prune() int {
+	return pruneRecent(&s.sections, s.max, s.clock.Time().Add(-MaxWindow), func(v interface{}) int64 {
+		return atomic.LoadInt64(v.(*sectionRequests).last)
+	})
+}
@@ -0,0 +1,62 @@
+package traffic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSectionTracker(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	st := NewSectionTracker(c, 2, 10)
+
+	st.Observe("http://rusutsu.com/lift", "/lift/pass", "POST", "10.0.0.9", start)
+	for i := 0; i < 3; i++ {
+		st.Observe("http://rusutsu.com/ski", "/ski/kona", "GET", "10.0.0.1", start.Add(time.Duration(i)*time.Minute))
+	}
+	st.Observe("http://rusutsu.com/ski", "/ski/yuki", "HEAD", "10.0.0.2", start.Add(3*time.Minute))
+	c.Advance(start.Add(4 * time.Minute))
+
+	d, ok := st.Detail("http://rusutsu.com/ski", time.Minute, 5)
+	if !ok {
+		t.Fatal("ski section was not tracked")
+	}
+	if exp := map[string]uint64{"/ski/kona": 3, "/ski/yuki": 1}; !reflect.DeepEqual(d.Paths, exp) {
+		t.Errorf("paths %v != %v", d.Paths, exp)
+	}
+	if exp := map[string]uint64{"GET": 3, "HEAD": 1}; !reflect.DeepEqual(d.Methods, exp) {
+		t.Errorf("methods %v != %v", d.Methods, exp)
+	}
+	if exp := map[string]uint64{"10.0.0.1": 3, "10.0.0.2": 1}; !reflect.DeepEqual(d.Clients, exp) {
+		t.Errorf("clients %v != %v", d.Clients, exp)
+	}
+	if exp := []float64{1, 1, 1, 1, 0}; !reflect.DeepEqual(d.Requests, exp) {
+		t.Errorf("requests per minute %v != %v", d.Requests, exp)
+	}
+
+	// Requests are charted over at most MaxWindow.
+	if d, _ := st.Detail("http://rusutsu.com/ski", time.Hour, 24); len(d.Requests) != 24 {
+		t.Errorf("charted %d spans, expected 24", len(d.Requests))
+	}
+	if _, ok := st.Detail("http://rusutsu.com/gondola", time.Minute, 5); ok {
+		t.Error("untracked section has detail")
+	}
+
+	// Arbitrary methods are summarized like paths and clients.
+	for _, m := range []string{"PUT", "BREW", "WHEN", "PROPFIND"} {
+		st.Observe("http://rusutsu.com/lift", "/lift/pass", m, "10.0.0.9", start)
+	}
+	if d, _ := st.Detail("http://rusutsu.com/lift", time.Minute, 5); len(d.Methods) != 2 {
+		t.Errorf("methods %v exceed the capacity of 2", d.Methods)
+	}
+
+	// Sections idle for longer than MaxWindow are pruned.
+	c.Advance(start.Add(MaxWindow + 2*time.Minute))
+	if n := st.Prune(); n != 1 {
+		t.Errorf("pruned %d sections, expected the idle lift", n)
+	}
+	if _, ok := st.Detail("http://rusutsu.com/lift", time.Minute, 5); ok {
+		t.Error("idle lift section was not pruned")
+	}
+}
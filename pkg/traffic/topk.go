@@ -0,0 +1,139 @@
+package traffic
+
+import (
+	"container/heap"
+	"encoding/json"
+	"math"
+	"sync"
+)
+
+// TopK counts occurrences per key in bounded memory with the Space-Saving
+// algorithm, so high cardinality keys, eg: the URLs of a crawler, cannot grow
+// it without limit. At most capacity keys are counted; a new key replaces the
+// least counted key and inherits its count as overestimation error.
+//
+// Every key occurring more than ErrorBound times is counted, and the counts
+// exported overestimate the true counts by at most ErrorBound, which is no
+// more than the total count divided by the capacity.
+type TopK struct {
+	mux      sync.Mutex
+	capacity int
+	replaced bool // whether counts may be overestimated
+	keys     map[string]*topKEntry
+	min      topKHeap
+}
+
+// topKEntry is a counted key, positioned by index in the min-heap.
+type topKEntry struct {
+	key   string
+	count uint64
+	index int
+}
+
+// NewTopK initializes a TopK counting at most capacity keys.
+func NewTopK(capacity int) *TopK {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TopK{
+		capacity: capacity,
+		keys:     make(map[string]*topKEntry, capacity),
+		min:      make(topKHeap, 0, capacity),
+	}
+}
+
+// TopKCapacity is the capacity of a TopK whose counts overestimate by at most
+// the fraction epsilon of the total count, eg: 0.0001 for 10000 keys.
+func TopKCapacity(epsilon float64) int {
+	return int(math.Ceil(1 / epsilon))
+}
+
+// IncKey increments a key's count, replacing the least counted key when
+// the capacity is reached.
+func (t *TopK) IncKey(key string, i uint64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if e, ok := t.keys[key]; ok {
+		e.count += i
+		heap.Fix(&t.min, e.index)
+		return
+	}
+	if len(t.min) < t.capacity {
+		e := &topKEntry{key: key, count: i}
+		t.keys[key] = e
+		heap.Push(&t.min, e)
+		return
+	}
+	e := t.min[0]
+	t.replaced = true
+	delete(t.keys, e.key)
+	e.key = key
+	e.count += i
+	t.keys[key] = e
+	heap.Fix(&t.min, 0)
+}
+
+// Export provides the estimated count of each counted key.
+func (t *TopK) Export() map[string]uint64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	output := make(map[string]uint64, len(t.min))
+	for _, e := range t.min {
+		output[e.key] = e.count
+	}
+	return output
+}
+
+// ErrorBound is the most any exported count overestimates the key's true
+// count by, the least count once a key was replaced.
+func (t *TopK) ErrorBound() uint64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if !t.replaced {
+		return 0
+	}
+	return t.min[0].count
+}
+
+// MarshalJSON encodes the estimated count of each key, in the format of
+// RequestCounter, so state may be restored to either.
+func (t *TopK) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Export())
+}
+
+// UnmarshalJSON adds the counts encoded by MarshalJSON to the counter.
+func (t *TopK) UnmarshalJSON(data []byte) error {
+	counts := make(map[string]uint64)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+	for k, c := range counts {
+		t.IncKey(k, c)
+	}
+	return nil
+}
+
+// topKHeap orders the counted keys by least count, see container/heap.
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	e := x.(*topKEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
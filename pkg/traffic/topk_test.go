@@ -0,0 +1,83 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	tk := NewTopK(10)
+	exact := make(map[string]uint64)
+	inc := func(k string, i uint64) {
+		tk.IncKey(k, i)
+		exact[k] += i
+	}
+
+	// Heavy hitters amongst a long tail of one-off keys.
+	r := rand.New(rand.NewSource(1))
+	var total uint64
+	for i := 0; i < 10000; i++ {
+		switch n := r.Intn(10); {
+		case n < 3:
+			inc("/ski", 1)
+		case n < 5:
+			inc("/lift", 1)
+		case n < 6:
+			inc("/onsen", 1)
+		default:
+			inc(fmt.Sprintf("/crawl/%d", i), 1)
+		}
+		total++
+	}
+
+	counts := tk.Export()
+	if len(counts) != 10 {
+		t.Errorf("counted %d keys beyond the capacity", len(counts))
+	}
+	bound := tk.ErrorBound()
+	if bound > total/10 {
+		t.Errorf("error bound %d exceeds total/capacity %d", bound, total/10)
+	}
+	for _, k := range []string{"/ski", "/lift", "/onsen"} {
+		c, ok := counts[k]
+		if !ok {
+			t.Fatalf("heavy hitter %q was not counted: %v", k, counts)
+		}
+		if c < exact[k] || c > exact[k]+bound {
+			t.Errorf("%q count %d outside [%d, %d]", k, c, exact[k], exact[k]+bound)
+		}
+	}
+
+	data, err := json.Marshal(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := new(RequestCounter)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if c := restored.Export()["/ski"]; c != counts["/ski"] {
+		t.Errorf("restored count %d != %d", c, counts["/ski"])
+	}
+}
+
+func TestTopKCapacity(t *testing.T) {
+	if c := TopKCapacity(0.0001); c != 10000 {
+		t.Errorf("capacity %d != 10000", c)
+	}
+	tk := NewTopK(2)
+	tk.IncKey("/ski", 5)
+	tk.IncKey("/lift", 3)
+	if b := tk.ErrorBound(); b != 0 {
+		t.Errorf("counts below capacity are exact, error bound %d", b)
+	}
+	tk.IncKey("/onsen", 1)
+	if counts := tk.Export(); counts["/ski"] != 5 || counts["/onsen"] != 4 || len(counts) != 2 {
+		t.Errorf("least counted key should be replaced: %v", counts)
+	}
+	if b := tk.ErrorBound(); b != 4 {
+		t.Errorf("error bound %d != 4", b)
+	}
+}
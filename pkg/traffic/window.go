@@ -0,0 +1,161 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ropes/banken/pkg/traffic/internal/timeseries"
+)
+
+// MaxWindow is the longest trailing window a WindowCounter counts over.
+const MaxWindow = time.Hour
+
+// WindowCounter counts requests per key into minute and hour resolution
+// time series, so the top keys may be ranked over trailing windows of up to
+// MaxWindow rather than since start. Memory is bounded by Prune, which
+// removes keys idle for longer than MaxWindow and the least recently seen
+// keys beyond the counter's capacity.
+type WindowCounter struct {
+	clock Clock
+	max   int
+	keys  sync.Map
+}
+
+// windowSeries wraps a key's timeseries, which is not concurrency-safe.
+type windowSeries struct {
+	mux  sync.Mutex
+	ts   *timeseries.MinuteHourSeries
+	last *int64 // UnixNano of the latest count, accessed atomically
+}
+
+// NewWindowCounter initializes a counter reading the current time from c,
+// which Prune bounds to max keys.
+func NewWindowCounter(c Clock, max int) *WindowCounter {
+	return &WindowCounter{clock: c, max: max}
+}
+
+// IncKey counts i requests of key at time t.
+func (w *WindowCounter) IncKey(key string, i uint64, t time.Time) {
+	s, ok := w.keys.Load(key)
+	if !ok {
+		s, _ = w.keys.LoadOrStore(key, &windowSeries{
+			ts:   timeseries.NewMinuteHourSeriesWithClock(timeseries.NewFloat, w.clock),
+			last: new(int64),
+		})
+	}
+	ws := s.(*windowSeries)
+	f := timeseries.Float(i)
+	ws.mux.Lock()
+	ws.ts.AddWithTime(&f, t)
+	ws.mux.Unlock()
+	if n := t.UnixNano(); n > atomic.LoadInt64(ws.last) {
+		atomic.StoreInt64(ws.last, n)
+	}
+}
+
+// Recent counts the requests of each key within the trailing window, which
+// is limited to MaxWindow. Keys without requests in the window are omitted.
+func (w *WindowCounter) Recent(window time.Duration) map[string]uint64 {
+	if window > MaxWindow {
+		window = MaxWindow
+	}
+	output := make(map[string]uint64)
+	w.keys.Range(func(key, value interface{}) bool {
+		ws := value.(*windowSeries)
+		ws.mux.Lock()
+		c := uint64(ws.ts.Recent(window).(*timeseries.Float).Value() + 0.5)
+		ws.mux.Unlock()
+		if c > 0 {
+			output[key.(string)] = c
+		}
+		return true
+	})
+	return output
+}
+
+// Prune removes the keys without requests within MaxWindow, then the least
+// recently requested keys until at most the counter's capacity remain. It
+// returns the number of keys removed.
+func (w *WindowCounter) Prune() int {
+	return pruneRecent(&w.keys, w.max, w.clock.Time().Add(-MaxWindow), func(v interface{}) int64 {
+		return atomic.LoadInt64(v.(*windowSeries).last)
+	})
+}
+
+// pruneRecent removes the keys of m last seen before idle, then the least
+// recently seen keys until at most max remain. last reads the UnixNano a
+// key's value was last seen at. It returns the number of keys removed.
+func pruneRecent(m *sync.Map, max int, idle time.Time, last func(interface{}) int64) int {
+	type seen struct {
+		key  interface{}
+		last int64
+	}
+	before := idle.UnixNano()
+	removed := 0
+	keys := make([]seen, 0)
+	m.Range(func(key, value interface{}) bool {
+		l := last(value)
+		if l < before {
+			m.Delete(key)
+			removed++
+		} else {
+			keys = append(keys, seen{key, l})
+		}
+		return true
+	})
+	if len(keys) <= max {
+		return removed
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].last > keys[j].last
+	})
+	for _, k := range keys[max:] {
+		m.Delete(k.key)
+		removed++
+	}
+	return removed
+}
+
+// MarshalJSON encodes the requests counted for each key.
+func (w *WindowCounter) MarshalJSON() ([]byte, error) {
+	keys := make(map[string]json.RawMessage)
+	var err error
+	w.keys.Range(func(key, value interface{}) bool {
+		ws := value.(*windowSeries)
+		ws.mux.Lock()
+		keys[key.(string)], err = json.Marshal(ws.ts)
+		ws.mux.Unlock()
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(keys)
+}
+
+// UnmarshalJSON replaces the requests counted for the keys encoded by
+// MarshalJSON. Keys are treated as last requested when restored, so idle
+// keys are pruned MaxWindow later.
+func (w *WindowCounter) UnmarshalJSON(data []byte) error {
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	now := w.clock.Time().UnixNano()
+	for k, v := range keys {
+		ws := &windowSeries{
+			ts:   timeseries.NewMinuteHourSeriesWithClock(timeseries.NewFloat, w.clock),
+			last: new(int64),
+		}
+		if err := json.Unmarshal(v, ws.ts); err != nil {
+			return fmt.Errorf("requests of %q: %w", k, err)
+		}
+		*ws.last = now
+		w.keys.Store(k, ws)
+	}
+	return nil
+}
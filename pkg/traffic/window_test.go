@@ -0,0 +1,73 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindowCounter(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	wc := NewWindowCounter(c, 10)
+
+	// The lift was busy half an hour ago, the ski run is busy now.
+	wc.IncKey("/lift", 50, start)
+	recent := start.Add(30 * time.Minute)
+	for i := 0; i < 10; i++ {
+		wc.IncKey("/ski", 2, recent.Add(time.Duration(i)*time.Second))
+	}
+	c.Advance(recent.Add(10 * time.Second))
+
+	if m := wc.Recent(time.Minute); len(m) != 1 || m["/ski"] != 20 {
+		t.Errorf("unexpected counts over the last minute: %v", m)
+	}
+	if m := wc.Recent(time.Hour); m["/lift"] != 50 || m["/ski"] != 20 {
+		t.Errorf("unexpected counts over the last hour: %v", m)
+	}
+
+	data, err := json.Marshal(wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewWindowCounter(c, 10)
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if m := restored.Recent(time.Hour); m["/lift"] != 50 || m["/ski"] != 20 {
+		t.Errorf("unexpected restored counts: %v", m)
+	}
+
+	// Keys idle for longer than MaxWindow are pruned.
+	c.Advance(start.Add(MaxWindow + time.Minute))
+	if n := wc.Prune(); n != 1 {
+		t.Errorf("pruned %d keys, expected the idle /lift", n)
+	}
+	if m := wc.Recent(time.Hour); len(m) != 1 || m["/ski"] != 20 {
+		t.Errorf("unexpected counts after pruning: %v", m)
+	}
+}
+
+func TestWindowCounterCapacity(t *testing.T) {
+	c := NewPacketClock()
+	start := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	wc := NewWindowCounter(c, 3)
+	for i := 0; i < 5; i++ {
+		wc.IncKey(fmt.Sprintf("/run-%d", i), 1, start.Add(time.Duration(i)*time.Second))
+	}
+	c.Advance(start.Add(5 * time.Second))
+
+	if n := wc.Prune(); n != 2 {
+		t.Errorf("pruned %d keys beyond capacity, expected 2", n)
+	}
+	m := wc.Recent(time.Minute)
+	for _, k := range []string{"/run-2", "/run-3", "/run-4"} {
+		if m[k] != 1 {
+			t.Errorf("most recent key %q was pruned: %v", k, m)
+		}
+	}
+	if len(m) != 3 {
+		t.Errorf("unexpected keys after pruning: %v", m)
+	}
+}
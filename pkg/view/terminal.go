@@ -6,37 +6,106 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 )
 
+// MinWidth and MinHeight are the smallest terminal dimensions the panels are
+// laid out in. Smaller terminals show a notice to enlarge the window
+// instead, until they are resized.
+const (
+	MinWidth  = 60
+	MinHeight = 20
+)
+
+const (
+	titleHeight = 3
+	// chartHeight is the rows of the requests chart, including its border.
+	chartHeight = 12
+	// minAlertsHeight is the fewest rows of the alerts list, which the top
+	// panels shrink to leave room for.
+	minAlertsHeight = 5
+	// minPanelHeight is the fewest rows of the top panels and chart, which
+	// the chart needs to draw a line within its border.
+	minPanelHeight = 3
+)
+
+// screen holds the widgets laid out on the terminal, which are re-laid out
+// when it is resized.
+type screen struct {
+	mux      sync.Mutex
+	max      []int // rows of the top paths, talkers and chart panels, including borders
+	rows     []int // rows of the panels laid out
+	tooSmall bool
+	detailed bool          // whether the detail pane replaces the top panels
+	resized  chan struct{} // signals Run to lay the panels out again
+	// hidden are the widgets not drawn by Render while the detail pane is
+	// open, or closed.
+	hidden map[bool]map[ui.Drawable]bool
+
+	title  *widgets.Paragraph
+	hint   string // title text while not filtering
+	notice *widgets.Paragraph
+	grid   *ui.Grid
+}
+
+// current is the screen initialized by Init.
+var current *screen
+
+// Detail is the pane opened from a row of the top paths panel, detailing the
+// requests to that section.
+type Detail struct {
+	Paths   *widgets.List
+	Methods *widgets.List
+	Clients *widgets.List
+	Chart   *widgets.Plot
+}
+
+// Drilldown opens the Detail of the top paths panel's rows, see Run.
+type Drilldown interface {
+	// Drill fills the Detail with the requests to the section listed by the
+	// top paths panel's row, reporting false when it has no detail.
+	Drill(row int) bool
+	// Undrill stops updating the Detail.
+	Undrill()
+}
+
+// Filterer narrows the traffic displayed to the requests matching the filter
+// typed at the '/' prompt, see Run.
+type Filterer interface {
+	// Filter applies the filter, or clears it when empty. An invalid filter
+	// is reported without changing the filter applied.
+	Filter(filter string) error
+}
+
 // Init constructs termui UI data structures and returns them so data
 // controllers can update the UI.
 //
-// UI will attempt to scale based on the given Terminal dimensions, but
-// it will not update dimensions if the window is changed after startup.
-func Init(ctx context.Context, n int) (topN, reqCnts, alerts *widgets.List) {
+// The panels are laid out on a grid scaled to the terminal dimensions, which
+// Run lays out again when the terminal is resized.
+func Init(ctx context.Context, n, talkersN int) (topN, reqCnts, talkers, captures, alerts *widgets.List, chart *widgets.Plot, detail *Detail) {
 	if err := ui.Init(); err != nil {
 		log.Fatalf("failed to initialize termui: %v", err)
 	}
-	maxX, maxY := ui.TerminalDimensions()
 	title := widgets.NewParagraph()
-	minY := 3
-	title.SetRect(0, 0, maxX, minY)
-	title.Text = "Banken[番犬] HTTP Traffic Monitor -- press 'q' to quit"
+	title.Text = "Banken[番犬] HTTP Traffic Monitor -- press 'q' to quit, '/' to filter, 'w' to change the top window, 'v' the chart view, 'tab' to select a top path and 'enter' to detail it"
 	title.TextStyle = ui.NewStyle(ui.ColorCyan)
-	ui.Render(title)
+	title.WrapText = false
+
+	notice := widgets.NewParagraph()
+	notice.Title = "Banken[番犬]"
+	notice.TextStyle = ui.NewStyle(ui.ColorYellow)
 
 	// TopN URL list
 	topN = widgets.NewList()
 	topN.Title = fmt.Sprintf("Top %d HTTP Requested Paths", n)
 	topN.Rows = []string{}
-	topQuarter := maxX / 3
-	topSplit := topQuarter * 2
 	topN.TitleStyle = ui.NewStyle(ui.ColorYellow)
+	topN.SelectedRowStyle = topN.TextStyle
 	topN.WrapText = false
-	topN.SetRect(0, minY, topSplit, minY+n+2)
 
 	// Req Avgs
 	reqCnts = widgets.NewList()
@@ -44,7 +113,48 @@ func Init(ctx context.Context, n int) (topN, reqCnts, alerts *widgets.List) {
 	reqCnts.Rows = []string{}
 	reqCnts.TitleStyle = ui.NewStyle(ui.ColorBlue)
 	reqCnts.WrapText = false
-	reqCnts.SetRect(topSplit+1, minY, maxX, minY+n+2)
+
+	// Top talkers by bytes
+	talkers = widgets.NewList()
+	talkers.Title = "Top Talkers by Bytes"
+	talkers.Rows = []string{}
+	talkers.TitleStyle = ui.NewStyle(ui.ColorGreen)
+	talkers.WrapText = false
+
+	// Capture status per interface
+	captures = widgets.NewList()
+	captures.Title = "Capture Status"
+	captures.Rows = []string{"starting capture..."}
+	captures.TitleStyle = ui.NewStyle(ui.ColorMagenta)
+	captures.WrapText = false
+
+	// Requests over time, with the alert threshold as a reference line
+	chart = widgets.NewPlot()
+	chart.Title = "HTTP Requests over Time"
+	chart.TitleStyle = ui.NewStyle(ui.ColorCyan)
+	chart.LineColors = []ui.Color{ui.ColorCyan, ui.ColorRed}
+	chart.Data = [][]float64{{0, 0}}
+
+	// Detail of a top path's section
+	detail = &Detail{
+		Paths:   widgets.NewList(),
+		Methods: widgets.NewList(),
+		Clients: widgets.NewList(),
+		Chart:   widgets.NewPlot(),
+	}
+	detail.Paths.Title = "Paths"
+	detail.Paths.TitleStyle = ui.NewStyle(ui.ColorYellow)
+	detail.Paths.WrapText = false
+	detail.Methods.Title = "Methods"
+	detail.Methods.TitleStyle = ui.NewStyle(ui.ColorBlue)
+	detail.Methods.WrapText = false
+	detail.Clients.Title = "Clients"
+	detail.Clients.TitleStyle = ui.NewStyle(ui.ColorGreen)
+	detail.Clients.WrapText = false
+	detail.Chart.Title = "Section Requests over Time"
+	detail.Chart.TitleStyle = ui.NewStyle(ui.ColorCyan)
+	detail.Chart.LineColors = []ui.Color{ui.ColorCyan}
+	detail.Chart.Data = [][]float64{{0, 0}}
 
 	// Alert List
 	alerts = widgets.NewList()
@@ -53,80 +163,361 @@ func Init(ctx context.Context, n int) (topN, reqCnts, alerts *widgets.List) {
 	alerts.SelectedRowStyle = ui.NewStyle(ui.ColorRed)
 	alerts.TitleStyle = ui.NewStyle(ui.ColorRed)
 	alerts.WrapText = true
-	alerts.SetRect(0, minY+n+3, maxX, maxY-(n+3))
 
-	ui.Render(topN)
-	ui.Render(reqCnts)
-	ui.Render(alerts)
+	current = &screen{
+		max:     []int{n + 2, talkersN + 2, chartHeight},
+		title:   title,
+		hint:    title.Text,
+		notice:  notice,
+		grid:    ui.NewGrid(),
+		resized: make(chan struct{}, 1),
+	}
+	current.layout(ui.TerminalDimensions())
+	current.set(topN, reqCnts, talkers, captures, alerts, chart, detail)
+	current.render()
+
+	return topN, reqCnts, talkers, captures, alerts, chart, detail
+}
+
+// set places the panels on the grid, in rows sized by layout. The detail
+// pane takes the rows of the top panels and chart while it is open.
+func (s *screen) set(topN, reqCnts, talkers, captures, alerts *widgets.List, chart *widgets.Plot, detail *Detail) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.hidden = map[bool]map[ui.Drawable]bool{
+		true:  {topN: true, reqCnts: true, talkers: true, captures: true, chart: true},
+		false: {detail.Paths: true, detail.Methods: true, detail.Clients: true, detail.Chart: true},
+	}
+	s.grid.Items = nil
+	if s.detailed {
+		s.grid.Set(
+			ui.NewRow(s.ratio(titleHeight), ui.NewCol(1, s.title)),
+			ui.NewRow(s.ratio(s.rows[0]+s.rows[1]),
+				ui.NewCol(2.0/3, detail.Paths),
+				ui.NewCol(1.0/3,
+					ui.NewRow(1.0/3, detail.Methods),
+					ui.NewRow(2.0/3, detail.Clients),
+				),
+			),
+			ui.NewRow(s.ratio(s.rows[2]), ui.NewCol(1, detail.Chart)),
+			ui.NewRow(1-s.ratio(titleHeight+s.rows[0]+s.rows[1]+s.rows[2]), ui.NewCol(1, alerts)),
+		)
+		return
+	}
+	s.grid.Set(
+		ui.NewRow(s.ratio(titleHeight), ui.NewCol(1, s.title)),
+		ui.NewRow(s.ratio(s.rows[0]),
+			ui.NewCol(2.0/3, topN),
+			ui.NewCol(1.0/3, reqCnts),
+		),
+		ui.NewRow(s.ratio(s.rows[1]),
+			ui.NewCol(2.0/3, talkers),
+			ui.NewCol(1.0/3, captures),
+		),
+		ui.NewRow(s.ratio(s.rows[2]), ui.NewCol(1, chart)),
+		ui.NewRow(1-s.ratio(titleHeight+s.rows[0]+s.rows[1]+s.rows[2]), ui.NewCol(1, alerts)),
+	)
+}
+
+// ratio is the fraction of the grid's height taken by rows, nudged up so
+// the grid does not round the rows down.
+func (s *screen) ratio(rows int) float64 {
+	return (float64(rows) + 0.01) / float64(s.grid.Dy()+1)
+}
+
+// layout sizes the grid to the terminal, shrinking the panels so the
+// alerts list keeps minAlertsHeight rows. The panels are hidden when the
+// terminal is smaller than MinWidth by MinHeight.
+func (s *screen) layout(width, height int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.tooSmall = width < MinWidth || height < MinHeight
+	s.notice.SetRect(0, 0, width, height)
+	s.notice.Text = fmt.Sprintf("Enlarge the terminal to at least %dx%d to display traffic, currently %dx%d. Press 'q' to quit.", MinWidth, MinHeight, width, height)
+	s.grid.SetRect(0, 0, width, height)
+
+	// Share the rows left by the title and alerts between the top panels,
+	// each keeping minPanelHeight rows.
+	s.rows = make([]int, len(s.max))
+	copy(s.rows, s.max)
+	total := 0
+	for _, r := range s.max {
+		total += r - minPanelHeight
+	}
+	if avail := height - titleHeight - minAlertsHeight; total+minPanelHeight*len(s.max) > avail {
+		spare := avail - minPanelHeight*len(s.max)
+		if spare < 0 {
+			spare = 0
+		}
+		left := spare
+		for i, r := range s.max[:len(s.max)-1] {
+			extra := spare * (r - minPanelHeight) / total
+			s.rows[i] = minPanelHeight + extra
+			left -= extra
+		}
+		s.rows[len(s.rows)-1] = minPanelHeight + left
+	}
+}
+
+// render draws the panels, or the notice when the terminal is too small.
+func (s *screen) render() {
+	ui.Clear()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.tooSmall {
+		ui.Render(s.notice)
+		return
+	}
+	ui.Render(s.grid)
+}
+
+// Render draws the updated widgets, unless the terminal is too small to lay
+// them out. Data controllers render their widgets with it, rather than
+// termui's Render, so updates do not draw over the too small notice, nor the
+// panels the detail pane replaces over it or the other way around.
+func Render(items ...ui.Drawable) {
+	if current == nil {
+		return
+	}
+	current.mux.Lock()
+	defer current.mux.Unlock()
+	if current.tooSmall {
+		return
+	}
+	shown := make([]ui.Drawable, 0, len(items))
+	for _, i := range items {
+		if !current.hidden[current.detailed][i] {
+			shown = append(shown, i)
+		}
+	}
+	ui.Render(shown...)
+}
 
-	return topN, reqCnts, alerts
+// status replaces the title text, restoring the hint when empty.
+func (s *screen) status(text string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if text == "" {
+		text = s.hint
+	}
+	s.title.Text = text
+	if !s.tooSmall {
+		ui.Render(s.title)
+	}
+}
+
+// SetTopN sizes the top paths panel to n rows, laying the panels out again
+// when it changed, eg: after --top-n-reqs is reloaded.
+func SetTopN(n int) {
+	if current == nil {
+		return
+	}
+	current.mux.Lock()
+	changed := current.max[0] != n+2
+	current.max[0] = n + 2
+	current.mux.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case current.resized <- struct{}{}:
+	default:
+	}
+}
+
+// detailing reports whether the detail pane is open.
+func (s *screen) detailing() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.detailed
+}
+
+// detail opens or closes the detail pane.
+func (s *screen) detail(open bool) {
+	s.mux.Lock()
+	s.detailed = open
+	s.mux.Unlock()
 }
 
 // Run catches key events which are needed for scrolling Alert notices in the
 // UI, and catching shutdown commands. Calling can context.CancelFunc() signals
 // the controllers to exit by closing the main context.Context.
-func Run(can context.CancelFunc, topN, reqCnts, alerts *widgets.List) {
+// Other keys call the controller bound to them in keys, eg: 'w' to rank the
+// top paths over another window. Resizing the terminal, or SetTopN, lays the
+// panels out again, keeping the alert selected.
+//
+// Tab moves the scrolling keys between the alerts and the top paths, where
+// Enter opens the detail pane of the selected row's section from drill.
+// Escape closes it again.
+//
+// Slash opens a prompt in the title bar for the filter applied by filter,
+// which is then shown in its place until Escape clears it.
+func Run(can context.CancelFunc, keys map[string]func(), drill Drilldown, filter Filterer, topN, reqCnts, talkers, captures, alerts *widgets.List, chart *widgets.Plot, detail *Detail) {
 	defer ui.Close()
-	// Alert list scrolling hooks
+	relayout := func() {
+		current.set(topN, reqCnts, talkers, captures, alerts, chart, detail)
+		current.render()
+	}
+	unselected := topN.SelectedRowStyle
+	// Filter prompt, typed into input while prompting
+	prompting, input, applied := false, []rune{}, ""
+	prompt := func(err error) {
+		text := fmt.Sprintf("Filter by host/path substring, ~regexp or method=GET,POST: %s_ -- press 'enter' to apply, 'esc' to cancel", string(input))
+		if err != nil {
+			text = fmt.Sprintf("%v -- %s", err, text)
+		}
+		current.status(text)
+	}
+	filtered := func() {
+		if applied == "" {
+			current.status("")
+			return
+		}
+		current.status(fmt.Sprintf("Banken[番犬] HTTP Traffic Monitor -- filtered to %q, press '/' to change, 'esc' to clear", applied))
+	}
+	// List scrolling hooks
+	focus := alerts
 	previousKey := ""
 	uiEvents := ui.PollEvents()
 	for {
-		alertsScrollable := false
-		if len(alerts.Rows) > 0 {
-			alertsScrollable = true
+		scrolled := focus
+		if current.detailing() {
+			scrolled = detail.Paths
+		}
+		scrollable := len(scrolled.Rows) > 0
+
+		var e ui.Event
+		select {
+		case e = <-uiEvents:
+		case <-current.resized:
+			current.layout(ui.TerminalDimensions())
+			relayout()
+			continue
+		}
+		if prompting && e.Type == ui.KeyboardEvent && e.ID != "<C-c>" {
+			switch e.ID {
+			case "<Enter>":
+				if err := filter.Filter(string(input)); err != nil {
+					prompt(err)
+					continue
+				}
+				prompting, applied = false, strings.TrimSpace(string(input))
+				filtered()
+				continue
+			case "<Escape>":
+				prompting = false
+				filtered()
+				continue
+			case "<Backspace>", "<C-<Backspace>>":
+				if len(input) > 0 {
+					input = input[:len(input)-1]
+				}
+			case "<Space>":
+				input = append(input, ' ')
+			default:
+				if r := []rune(e.ID); len(r) == 1 {
+					input = append(input, r...)
+				}
+			}
+			prompt(nil)
+			continue
 		}
 
-		e := <-uiEvents
 		switch e.ID {
 		case "q", "<C-c>":
 			can()
 			return
+		case "<Resize>":
+			r := e.Payload.(ui.Resize)
+			current.layout(r.Width, r.Height)
+			relayout()
+			continue
+		case "/":
+			prompting, input = true, []rune(applied)
+			prompt(nil)
+			continue
+		case "<Tab>":
+			if focus == alerts {
+				focus = topN
+				topN.SelectedRowStyle = ui.NewStyle(ui.ColorYellow, ui.ColorClear, ui.ModifierReverse)
+			} else {
+				focus = alerts
+				topN.SelectedRowStyle = unselected
+			}
+		case "<Enter>":
+			if focus == topN && !current.detailing() && drill.Drill(topN.SelectedRow) {
+				detail.Paths.SelectedRow = 0
+				current.detail(true)
+				relayout()
+				continue
+			}
+		case "<Escape>":
+			if current.detailing() {
+				drill.Undrill()
+				current.detail(false)
+				relayout()
+				continue
+			}
+			if applied != "" {
+				if err := filter.Filter(""); err == nil {
+					applied = ""
+					filtered()
+				}
+			}
 		case "j", "<Down>":
-			if alertsScrollable {
-				alerts.ScrollDown()
+			if scrollable {
+				scrolled.ScrollDown()
 			}
 		case "k", "<Up>":
-			if alertsScrollable {
-				alerts.ScrollUp()
+			if scrollable {
+				scrolled.ScrollUp()
 			}
 		case "<C-d>":
-			if alertsScrollable {
-				alerts.ScrollHalfPageDown()
+			if scrollable {
+				scrolled.ScrollHalfPageDown()
 			}
 		case "<C-u>":
-			if alertsScrollable {
-				alerts.ScrollHalfPageUp()
+			if scrollable {
+				scrolled.ScrollHalfPageUp()
 			}
 		case "<C-f>":
-			if alertsScrollable {
-				alerts.ScrollPageDown()
+			if scrollable {
+				scrolled.ScrollPageDown()
 			}
 		case "<C-b>":
-			if alertsScrollable {
-				alerts.ScrollPageUp()
+			if scrollable {
+				scrolled.ScrollPageUp()
 			}
 		case "g":
-			if previousKey == "g" && alertsScrollable {
-				alerts.ScrollTop()
+			if previousKey == "g" && scrollable {
+				scrolled.ScrollTop()
 			}
 		case "<Home>":
-			if alertsScrollable {
-				alerts.ScrollTop()
+			if scrollable {
+				scrolled.ScrollTop()
 			}
 		case "G", "<End>":
-			if alertsScrollable {
-				alerts.ScrollBottom()
+			if scrollable {
+				scrolled.ScrollBottom()
 			}
 		}
 
+		if f, ok := keys[e.ID]; ok {
+			f()
+		}
+
 		if previousKey == "g" {
 			previousKey = ""
 		} else {
 			previousKey = e.ID
 		}
 
-		ui.Render(alerts)
-		ui.Render(topN)
-		ui.Render(reqCnts)
+		Render(alerts, topN, reqCnts, talkers, captures, chart, detail.Paths, detail.Methods, detail.Clients, detail.Chart)
 	}
 }
+
+// Close restores the terminal, so errors can be reported after the UI was
+// initialized.
+func Close() {
+	ui.Close()
+}